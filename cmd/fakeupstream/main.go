@@ -0,0 +1,28 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+
+	"orchids-api/internal/fakeupstream"
+)
+
+func main() {
+	addr := flag.String("addr", ":3100", "监听地址")
+	script := flag.String("script", "", "脚本响应的 JSON 文件")
+	sessionID := flag.String("session", "", "模拟的 Clerk 会话 ID")
+	tokenTTL := flag.Duration("token-ttl", 0, "签发的 JWT 有效期，默认 60s")
+	flag.Parse()
+
+	server := fakeupstream.New(fakeupstream.Options{SessionID: *sessionID, TokenTTL: *tokenTTL})
+	if *script != "" {
+		if err := server.LoadScript(*script); err != nil {
+			log.Fatalf("加载脚本失败: %v", err)
+		}
+	}
+
+	log.Printf("fakeupstream 监听 %s", *addr)
+	log.Println("网关的 UPSTREAM_BASE_URL 与 CLERK_BASE_URL 设为本服务地址即可离线运行")
+	log.Fatal(http.ListenAndServe(*addr, server))
+}
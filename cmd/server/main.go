@@ -2,19 +2,29 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
+	"syscall"
+	_ "time/tzdata" // 配额时区，alpine 镜像不带时区数据
 
+	"orchids-api/internal/accountroute"
 	"orchids-api/internal/api"
+	"orchids-api/internal/clerk"
+	"orchids-api/internal/client"
 	"orchids-api/internal/config"
 	"orchids-api/internal/debug"
 	"orchids-api/internal/handler"
 	"orchids-api/internal/loadbalancer"
+	"orchids-api/internal/mediacache"
 	"orchids-api/internal/middleware"
+	"orchids-api/internal/modelroute"
 	"orchids-api/internal/store"
+	"orchids-api/internal/videojob"
 	"orchids-api/web"
 )
 
@@ -45,6 +55,11 @@ func main() {
 	loadEnv()
 
 	cfg := config.Load()
+	client.SetUpstreamBaseURL(cfg.UpstreamBaseURL)
+	clerk.SetBaseURL(cfg.ClerkBaseURL)
+	if cfg.UpstreamBaseURL != "" || cfg.ClerkBaseURL != "" {
+		log.Printf("使用自定义上游地址: upstream=%s clerk=%s", cfg.UpstreamBaseURL, cfg.ClerkBaseURL)
+	}
 
 	// 启动时清理所有调试日志
 	if cfg.DebugEnabled {
@@ -63,21 +78,77 @@ func main() {
 	defer s.Close()
 
 	lb := loadbalancer.New(s)
+	if cfg.StickySessions {
+		lb.SetSessionTTL(cfg.StickySessionTTL)
+	}
+	lb.SetQueue(cfg.QueueSize, cfg.QueueTimeout)
+	lb.StartFlusher(context.Background(), cfg.FlushInterval)
+	// 熔断账号冷却结束后用获取 token 探测会话是否恢复
+	lb.StartProber(context.Background(), cfg.HealthProbeInterval, func(acc *store.Account) error {
+		_, err := client.NewFromAccount(acc).GetToken()
+		return err
+	})
+	router, err := modelroute.New(s)
+	if err != nil {
+		log.Fatalf("Failed to load model routes: %v", err)
+	}
+	accountRouter, err := accountroute.New(s)
+	if err != nil {
+		log.Fatalf("Failed to load account routes: %v", err)
+	}
 	apiHandler := api.New(s)
+	apiHandler.SetModelRouter(router)
+	apiHandler.SetAccountRouter(accountRouter)
+	apiHandler.SetLoadBalancer(lb)
 	h := handler.NewWithLoadBalancer(cfg, lb)
+	h.SetModelRouter(router)
+	h.SetAccountRouter(accountRouter)
+
+	videoJobs := videojob.New(s, lb, client.New(cfg), cfg.VideoWorkers)
+	if err := videoJobs.Start(context.Background()); err != nil {
+		log.Fatalf("Failed to start video jobs: %v", err)
+	}
+	h.SetVideoJobs(videoJobs)
+
+	media, err := mediacache.New(cfg.MediaDir, cfg.MediaTTL)
+	if err != nil {
+		log.Fatalf("Failed to initialize media cache: %v", err)
+	}
+	media.Start(context.Background())
+	h.SetMediaCache(media)
 
 	mux := http.NewServeMux()
 
 	mux.HandleFunc("/v1/messages", h.HandleMessages)
 	mux.HandleFunc("/v1/chat/completions", h.HandleOpenAIChat)
 	mux.HandleFunc("/v1/models", h.HandleOpenAIModels)
+	mux.HandleFunc("/v1/models/", h.HandleOpenAIModel)
 	mux.HandleFunc("/v1/images/generations", h.HandleOpenAIImages)
+	mux.HandleFunc("/v1/images/edits", h.HandleOpenAIImageEdits)
+	mux.HandleFunc("/v1/images/variations", h.HandleOpenAIImageVariations)
 	mux.HandleFunc("/v1/videos/generations", h.HandleOpenAIVideos)
+	mux.HandleFunc("/v1/videos", h.HandleOpenAIVideoJobs)
+	mux.HandleFunc("/v1/videos/", h.HandleOpenAIVideoJob)
+	mux.Handle("/v1/media/", http.StripPrefix("/v1/media", media))
+	mux.HandleFunc("/v1/ws", h.HandleWebSocket)
+	mux.HandleFunc("/v1beta/models/", h.HandleGemini)
+
+	// Ollama 兼容接口
+	mux.HandleFunc("/api/chat", h.HandleOllamaChat)
+	mux.HandleFunc("/api/generate", h.HandleOllamaGenerate)
+	mux.HandleFunc("/api/tags", h.HandleOllamaTags)
+	mux.HandleFunc("/api/show", h.HandleOllamaShow)
+	mux.HandleFunc("/api/version", h.HandleOllamaVersion)
 
 	mux.HandleFunc("/api/accounts", middleware.BasicAuth(cfg.AdminUser, cfg.AdminPass, apiHandler.HandleAccounts))
 	mux.HandleFunc("/api/accounts/", middleware.BasicAuth(cfg.AdminUser, cfg.AdminPass, apiHandler.HandleAccountByID))
 	mux.HandleFunc("/api/export", middleware.BasicAuth(cfg.AdminUser, cfg.AdminPass, apiHandler.HandleExport))
 	mux.HandleFunc("/api/import", middleware.BasicAuth(cfg.AdminUser, cfg.AdminPass, apiHandler.HandleImport))
+	mux.HandleFunc("/api/model-routes", middleware.BasicAuth(cfg.AdminUser, cfg.AdminPass, apiHandler.HandleModelRoutes))
+	mux.HandleFunc("/api/model-routes/", middleware.BasicAuth(cfg.AdminUser, cfg.AdminPass, apiHandler.HandleModelRouteByID))
+	mux.HandleFunc("/api/account-routes", middleware.BasicAuth(cfg.AdminUser, cfg.AdminPass, apiHandler.HandleAccountRoutes))
+	mux.HandleFunc("/api/account-routes/", middleware.BasicAuth(cfg.AdminUser, cfg.AdminPass, apiHandler.HandleAccountRouteByID))
+	mux.HandleFunc("/api/load-balancer", middleware.BasicAuth(cfg.AdminUser, cfg.AdminPass, apiHandler.HandleLoadBalancer))
 
 	mux.HandleFunc(cfg.AdminPath+"/", middleware.BasicAuthHandler(cfg.AdminUser, cfg.AdminPass, http.StripPrefix(cfg.AdminPath, web.StaticHandler())))
 
@@ -95,7 +166,18 @@ func main() {
 	// 应用CORS中间件到所有路由
 	handler := middleware.CORS(mux)
 	
-	if err := http.ListenAndServe(":"+cfg.Port, handler); err != nil {
+	server := &http.Server{Addr: ":" + cfg.Port, Handler: handler}
+	go func() {
+		// 退出前写入尚未落库的请求计数与配额用量
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		<-ctx.Done()
+		log.Println("正在关闭服务")
+		server.Close()
+	}()
+
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		log.Fatal(err)
 	}
+	lb.Flush()
 }
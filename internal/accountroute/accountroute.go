@@ -0,0 +1,119 @@
+package accountroute
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+
+	"orchids-api/internal/store"
+)
+
+// Input 用于匹配分组规则的请求信息
+type Input struct {
+	APIKey string
+	Model  string // 客户端请求的 model
+	Header http.Header
+}
+
+// Router 缓存启用的分组规则，规则变更后调用 Reload 立即生效
+type Router struct {
+	store  *store.Store
+	mu     sync.RWMutex
+	routes []store.AccountRoute // 按 priority、id 排序
+}
+
+// New 从 store 加载分组规则
+func New(s *store.Store) (*Router, error) {
+	r := &Router{store: s}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload 重新加载全部启用的规则
+func (r *Router) Reload() error {
+	list, err := r.store.ListAccountRoutes()
+	if err != nil {
+		return err
+	}
+
+	routes := make([]store.AccountRoute, 0, len(list))
+	for _, route := range list {
+		if !route.Enabled {
+			continue
+		}
+		if err := Validate(route); err != nil {
+			// 单条坏规则不影响其他规则
+			log.Printf("跳过无效的账号分组规则 #%d: %v", route.ID, err)
+			continue
+		}
+		routes = append(routes, *route)
+	}
+
+	r.mu.Lock()
+	r.routes = routes
+	r.mu.Unlock()
+	return nil
+}
+
+// Match 返回第一条命中的规则
+func (r *Router) Match(in Input) (store.AccountRoute, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, route := range r.routes {
+		if matches(route, in) {
+			return route, true
+		}
+	}
+	return store.AccountRoute{}, false
+}
+
+// Validate 检查规则字段，供管理接口在写入前调用
+func Validate(route *store.AccountRoute) error {
+	if route.Pattern == "" {
+		return errors.New("pattern is required")
+	}
+	if strings.TrimSpace(route.Group) == "" {
+		return errors.New("group is required")
+	}
+	switch route.MatchType {
+	case store.RouteByAPIKey:
+		return nil
+	case store.RouteByHeader:
+		if route.Header == "" {
+			return errors.New("header is required for header rules")
+		}
+	case store.RouteByModel:
+	default:
+		return fmt.Errorf("match_type must be %s, %s or %s", store.RouteByAPIKey, store.RouteByModel, store.RouteByHeader)
+	}
+	if _, err := path.Match(route.Pattern, ""); err != nil {
+		return fmt.Errorf("invalid pattern: %w", err)
+	}
+	return nil
+}
+
+// matches api_key 规则精确匹配，model 与 header 规则按通配符匹配（model 不区分大小写）
+func matches(route store.AccountRoute, in Input) bool {
+	switch route.MatchType {
+	case store.RouteByAPIKey:
+		return in.APIKey != "" && in.APIKey == route.Pattern
+	case store.RouteByModel:
+		ok, _ := path.Match(strings.ToLower(route.Pattern), strings.ToLower(in.Model))
+		return ok
+	case store.RouteByHeader:
+		value := in.Header.Get(route.Header)
+		if value == "" {
+			return false
+		}
+		ok, _ := path.Match(route.Pattern, value)
+		return ok
+	}
+	return false
+}
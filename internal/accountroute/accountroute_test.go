@@ -0,0 +1,74 @@
+package accountroute
+
+import (
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"orchids-api/internal/store"
+)
+
+func TestMatch(t *testing.T) {
+	s, err := store.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	for _, route := range []*store.AccountRoute{
+		{MatchType: store.RouteByAPIKey, Pattern: "sk-team-a", Group: "team-a", Priority: 10, Enabled: true},
+		{MatchType: store.RouteByHeader, Header: "X-Workload", Pattern: "batch-*", Group: "batch", Spillover: true, Priority: 20, Enabled: true},
+		{MatchType: store.RouteByModel, Pattern: "claude-opus-*", Group: "opus", Priority: 30, Enabled: true},
+		{MatchType: store.RouteByAPIKey, Pattern: "sk-disabled", Group: "team-b", Priority: 10, Enabled: false},
+	} {
+		if err := s.CreateAccountRoute(route); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r, err := New(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := http.Header{}
+	header.Set("X-Workload", "batch-nightly")
+	tests := []struct {
+		name  string
+		in    Input
+		group string
+	}{
+		{"api key", Input{APIKey: "sk-team-a", Model: "claude-opus-4-5", Header: header}, "team-a"},
+		{"header", Input{APIKey: "sk-other", Model: "claude-opus-4-5", Header: header}, "batch"},
+		{"model", Input{Model: "Claude-Opus-4-5", Header: http.Header{}}, "opus"},
+		{"disabled", Input{APIKey: "sk-disabled", Model: "gpt-4o", Header: http.Header{}}, ""},
+	}
+	for _, tt := range tests {
+		route, ok := r.Match(tt.in)
+		if tt.group == "" {
+			if ok {
+				t.Errorf("%s: matched route #%d, want none", tt.name, route.ID)
+			}
+			continue
+		}
+		if !ok || route.Group != tt.group {
+			t.Errorf("%s: group = %q (matched %v), want %q", tt.name, route.Group, ok, tt.group)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		route store.AccountRoute
+		ok    bool
+	}{
+		{store.AccountRoute{MatchType: store.RouteByModel, Pattern: "gpt-*", Group: "g"}, true},
+		{store.AccountRoute{MatchType: store.RouteByModel, Pattern: "[", Group: "g"}, false},
+		{store.AccountRoute{MatchType: store.RouteByHeader, Pattern: "x", Group: "g"}, false},
+		{store.AccountRoute{MatchType: store.RouteByAPIKey, Pattern: "sk", Group: " "}, false},
+		{store.AccountRoute{MatchType: "ip", Pattern: "x", Group: "g"}, false},
+	}
+	for _, tt := range tests {
+		if err := Validate(&tt.route); (err == nil) != tt.ok {
+			t.Errorf("Validate(%+v) = %v, want ok=%v", tt.route, err, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"orchids-api/internal/accountroute"
+	"orchids-api/internal/store"
+)
+
+// SetAccountRouter 设置账号分组规则，规则变更后立即重新加载
+func (a *API) SetAccountRouter(r *accountroute.Router) {
+	a.accountRouter = r
+}
+
+// HandleAccountRoutes 处理 /api/account-routes：GET 列表，POST 新建
+func (a *API) HandleAccountRoutes(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodGet:
+		routes, err := a.store.ListAccountRoutes()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if routes == nil {
+			routes = []*store.AccountRoute{}
+		}
+		json.NewEncoder(w).Encode(routes)
+
+	case http.MethodPost:
+		route := store.AccountRoute{Priority: 100, Enabled: true}
+		if err := json.NewDecoder(r.Body).Decode(&route); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := accountroute.Validate(&route); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := a.store.CreateAccountRoute(&route); err != nil {
+			log.Printf("Failed to create account route: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		a.reloadAccountRoutes()
+
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(route)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleAccountRouteByID 处理 /api/account-routes/{id}
+func (a *API) HandleAccountRouteByID(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id, err := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, "/api/account-routes/"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		route, err := a.store.GetAccountRoute(id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(route)
+
+	case http.MethodPut:
+		route, err := a.store.GetAccountRoute(id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		// 未提供的字段保持原值
+		if err := json.NewDecoder(r.Body).Decode(route); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		route.ID = id
+		if err := accountroute.Validate(route); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := a.store.UpdateAccountRoute(route); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		a.reloadAccountRoutes()
+		json.NewEncoder(w).Encode(route)
+
+	case http.MethodDelete:
+		if err := a.store.DeleteAccountRoute(id); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		a.reloadAccountRoutes()
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (a *API) reloadAccountRoutes() {
+	if a.accountRouter == nil {
+		return
+	}
+	if err := a.accountRouter.Reload(); err != nil {
+		log.Printf("重新加载账号分组规则失败: %v", err)
+	}
+}
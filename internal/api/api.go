@@ -2,18 +2,26 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
 
+	"orchids-api/internal/accountroute"
 	"orchids-api/internal/clerk"
+	"orchids-api/internal/loadbalancer"
+	"orchids-api/internal/modelroute"
 	"orchids-api/internal/store"
 )
 
 type API struct {
-	store *store.Store
+	store         *store.Store
+	router        *modelroute.Router
+	accountRouter *accountroute.Router
+	lb            *loadbalancer.LoadBalancer
 }
 
 type ExportData struct {
@@ -35,6 +43,11 @@ func New(s *store.Store) *API {
 func (a *API) RegisterRoutes(mux *http.ServeMux) {
 	mux.HandleFunc("/api/accounts", a.HandleAccounts)
 	mux.HandleFunc("/api/accounts/", a.HandleAccountByID)
+	mux.HandleFunc("/api/model-routes", a.HandleModelRoutes)
+	mux.HandleFunc("/api/model-routes/", a.HandleModelRouteByID)
+	mux.HandleFunc("/api/account-routes", a.HandleAccountRoutes)
+	mux.HandleFunc("/api/account-routes/", a.HandleAccountRouteByID)
+	mux.HandleFunc("/api/load-balancer", a.HandleLoadBalancer)
 }
 
 func (a *API) HandleAccounts(w http.ResponseWriter, r *http.Request) {
@@ -47,6 +60,9 @@ func (a *API) HandleAccounts(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		a.attachHealth(accounts...)
+		a.attachInFlight(accounts...)
+		a.attachQuota(accounts...)
 		json.NewEncoder(w).Encode(accounts)
 
 	case http.MethodPost:
@@ -55,6 +71,10 @@ func (a *API) HandleAccounts(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
+		if err := validateAccount(&acc); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 
 		if acc.ClientCookie != "" && acc.SessionID == "" {
 			info, err := clerk.FetchAccountInfo(acc.ClientCookie)
@@ -101,6 +121,9 @@ func (a *API) HandleAccountByID(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, err.Error(), http.StatusNotFound)
 			return
 		}
+		a.attachHealth(acc)
+		a.attachInFlight(acc)
+		a.attachQuota(acc)
 		json.NewEncoder(w).Encode(acc)
 
 	case http.MethodPut:
@@ -110,12 +133,24 @@ func (a *API) HandleAccountByID(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		var acc store.Account
+		// 未提供优先级、并发上限与配额设置时保持原值
+		acc := store.Account{
+			Priority:       existing.Priority,
+			MaxConcurrency: existing.MaxConcurrency,
+			RequestQuota:   existing.RequestQuota,
+			TokenQuota:     existing.TokenQuota,
+			QuotaWindow:    existing.QuotaWindow,
+			QuotaTimezone:  existing.QuotaTimezone,
+		}
 		if err := json.NewDecoder(r.Body).Decode(&acc); err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 		acc.ID = id
+		if err := validateAccount(&acc); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 
 		if acc.SessionID == "" {
 			acc.SessionID = existing.SessionID
@@ -132,6 +167,12 @@ func (a *API) HandleAccountByID(w http.ResponseWriter, r *http.Request) {
 		if acc.Email == "" {
 			acc.Email = existing.Email
 		}
+		if acc.Models == nil {
+			acc.Models = existing.Models
+		}
+		if acc.Groups == nil {
+			acc.Groups = existing.Groups
+		}
 
 		if err := a.store.UpdateAccount(&acc); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -151,6 +192,60 @@ func (a *API) HandleAccountByID(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// validateAccount 校验分组、并发上限与配额设置，配额窗口为空时按 daily
+func validateAccount(acc *store.Account) error {
+	if acc.MaxConcurrency < 0 {
+		return errors.New("max_concurrency must not be negative")
+	}
+	for _, g := range acc.Groups {
+		if strings.TrimSpace(g) == "" {
+			return errors.New("groups must not contain empty names")
+		}
+	}
+	if acc.RequestQuota < 0 || acc.TokenQuota < 0 {
+		return errors.New("request_quota and token_quota must not be negative")
+	}
+	switch acc.QuotaWindow {
+	case "":
+		acc.QuotaWindow = store.QuotaDaily
+	case store.QuotaDaily, store.QuotaHourly:
+	default:
+		return fmt.Errorf("quota_window must be %q or %q", store.QuotaDaily, store.QuotaHourly)
+	}
+	if acc.QuotaTimezone != "" {
+		if _, err := time.LoadLocation(acc.QuotaTimezone); err != nil {
+			return fmt.Errorf("invalid quota_timezone: %v", err)
+		}
+	}
+	return nil
+}
+
+// attachHealth 附加负载均衡记录的健康状态，没有记录的账号视为正常。
+// 设置了负载均衡时使用内存中的状态，数据库中的记录可能尚未落库
+func (a *API) attachHealth(accounts ...*store.Account) {
+	if a.lb != nil {
+		for _, acc := range accounts {
+			if acc.Health = a.lb.Health(acc); acc.Health == nil {
+				acc.Health = &store.AccountHealth{State: store.CircuitClosed}
+			}
+		}
+		return
+	}
+
+	health, err := a.store.ListAccountHealth()
+	if err != nil {
+		log.Printf("Failed to load account health: %v", err)
+		return
+	}
+	for _, acc := range accounts {
+		if h, ok := health[acc.ID]; ok {
+			acc.Health = h
+		} else {
+			acc.Health = &store.AccountHealth{State: store.CircuitClosed}
+		}
+	}
+}
+
 func (a *API) HandleExport(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -0,0 +1,111 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"orchids-api/internal/loadbalancer"
+	"orchids-api/internal/store"
+)
+
+// SetLoadBalancer 设置负载均衡，用于查看运行时指标与切换策略
+func (a *API) SetLoadBalancer(lb *loadbalancer.LoadBalancer) {
+	a.lb = lb
+}
+
+// attachInFlight 附加账号当前进行中的请求数
+func (a *API) attachInFlight(accounts ...*store.Account) {
+	if a.lb == nil {
+		return
+	}
+	for _, acc := range accounts {
+		acc.InFlight = a.lb.Stats(acc).InFlight
+	}
+}
+
+// attachQuota 附加账号当前窗口的配额使用情况
+func (a *API) attachQuota(accounts ...*store.Account) {
+	if a.lb == nil {
+		return
+	}
+	for _, acc := range accounts {
+		q := a.lb.Quota(acc)
+		acc.Quota = &q
+	}
+}
+
+// accountStats 账号运行时指标
+type accountStats struct {
+	ID             int64      `json:"id"`
+	Name           string     `json:"name"`
+	Priority       int        `json:"priority"`
+	InFlight       int        `json:"in_flight"`
+	MaxConcurrency int        `json:"max_concurrency"`
+	LatencyMs      *int64     `json:"latency_ewma_ms"` // 尚无样本时为 null
+	LastUsedAt     *time.Time `json:"last_used_at"`
+}
+
+// HandleLoadBalancer 处理 /api/load-balancer：GET 查看策略与账号指标，PUT 切换策略
+func (a *API) HandleLoadBalancer(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if a.lb == nil {
+		http.Error(w, "Load balancer not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		a.writeLoadBalancer(w)
+
+	case http.MethodPut:
+		var req struct {
+			Strategy string `json:"strategy"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if _, ok := loadbalancer.NewStrategy(req.Strategy); !ok {
+			http.Error(w, "Unknown strategy: "+req.Strategy, http.StatusBadRequest)
+			return
+		}
+		if err := a.lb.SetStrategy(req.Strategy); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		a.writeLoadBalancer(w)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (a *API) writeLoadBalancer(w http.ResponseWriter) {
+	accounts, err := a.lb.EnabledAccounts()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	stats := make([]accountStats, 0, len(accounts))
+	for _, acc := range accounts {
+		st := a.lb.Stats(acc)
+		item := accountStats{ID: acc.ID, Name: acc.Name, Priority: acc.Priority, InFlight: st.InFlight, MaxConcurrency: acc.MaxConcurrency}
+		if st.Latency > 0 {
+			ms := st.Latency.Milliseconds()
+			item.LatencyMs = &ms
+		}
+		if !st.LastUsedAt.IsZero() {
+			item.LastUsedAt = &st.LastUsedAt
+		}
+		stats = append(stats, item)
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"strategy":   a.lb.StrategyName(),
+		"strategies": loadbalancer.StrategyNames(),
+		"accounts":   stats,
+		"queue":      a.lb.QueueStats(),
+	})
+}
@@ -0,0 +1,154 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"orchids-api/internal/modelroute"
+	"orchids-api/internal/store"
+)
+
+// SetModelRouter 设置模型路由，规则变更后立即重新加载
+func (a *API) SetModelRouter(r *modelroute.Router) {
+	a.router = r
+}
+
+// HandleModelRoutes 处理 /api/model-routes：GET 列表，POST 新建
+func (a *API) HandleModelRoutes(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodGet:
+		routes, err := a.store.ListModelRoutes()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if routes == nil {
+			routes = []*store.ModelRoute{}
+		}
+		json.NewEncoder(w).Encode(routes)
+
+	case http.MethodPost:
+		route := store.ModelRoute{MatchType: store.MatchExact, Priority: 100, Enabled: true}
+		if err := json.NewDecoder(r.Body).Decode(&route); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := modelroute.Validate(&route); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := a.store.CreateModelRoute(&route); err != nil {
+			log.Printf("Failed to create model route: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		a.reloadRoutes()
+
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(route)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleModelRouteByID 处理 /api/model-routes/{id} 与 /api/model-routes/resolve?model=
+func (a *API) HandleModelRouteByID(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	idStr := strings.TrimPrefix(r.URL.Path, "/api/model-routes/")
+	if idStr == "resolve" {
+		a.handleResolveModel(w, r)
+		return
+	}
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		route, err := a.store.GetModelRoute(id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(route)
+
+	case http.MethodPut:
+		route, err := a.store.GetModelRoute(id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		// 未提供的字段保持原值
+		if err := json.NewDecoder(r.Body).Decode(route); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		route.ID = id
+		if err := modelroute.Validate(route); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := a.store.UpdateModelRoute(route); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		a.reloadRoutes()
+		json.NewEncoder(w).Encode(route)
+
+	case http.MethodDelete:
+		if err := a.store.DeleteModelRoute(id); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		a.reloadRoutes()
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleResolveModel 调试用：查看某个 model 会命中哪条规则
+func (a *API) handleResolveModel(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if a.router == nil {
+		http.Error(w, "Model router not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	model := r.URL.Query().Get("model")
+	target, err := a.router.Resolve(model)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"model":          model,
+		"route_id":       target.RouteID,
+		"upstream_model": target.Model,
+		"agent_mode":     target.AgentMode,
+	})
+}
+
+func (a *API) reloadRoutes() {
+	if a.router == nil {
+		return
+	}
+	if err := a.router.Reload(); err != nil {
+		log.Printf("重新加载模型路由失败: %v", err)
+	}
+}
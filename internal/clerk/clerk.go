@@ -5,9 +5,30 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 	"time"
 )
 
+// DefaultBaseURL Clerk 服务的默认地址
+const DefaultBaseURL = "https://clerk.orchids.app"
+
+const apiVersionQuery = "__clerk_api_version=2025-11-10&_clerk_js_version=5.117.0"
+
+var baseURL = DefaultBaseURL
+
+// SetBaseURL 修改 Clerk 服务地址，为空时使用默认地址。需在发送请求前调用
+func SetBaseURL(url string) {
+	if url == "" {
+		url = DefaultBaseURL
+	}
+	baseURL = strings.TrimRight(url, "/")
+}
+
+// TokenURL 返回会话获取 JWT 的地址
+func TokenURL(sessionID string) string {
+	return fmt.Sprintf("%s/v1/client/sessions/%s/tokens?%s", baseURL, sessionID, apiVersionQuery)
+}
+
 type ClientResponse struct {
 	Response struct {
 		ID                  string `json:"id"`
@@ -39,7 +60,7 @@ type AccountInfo struct {
 }
 
 func FetchAccountInfo(clientCookie string) (*AccountInfo, error) {
-	url := "https://clerk.orchids.app/v1/client?__clerk_api_version=2025-11-10&_clerk_js_version=5.117.0"
+	url := baseURL + "/v1/client?" + apiVersionQuery
 
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
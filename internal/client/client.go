@@ -4,19 +4,35 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"math/rand/v2"
 	"net/http"
 	"strings"
 
+	"orchids-api/internal/clerk"
 	"orchids-api/internal/config"
 	"orchids-api/internal/debug"
 	"orchids-api/internal/store"
 )
 
-const upstreamURL = "https://orchids-server.calmstone-6964e08a.westeurope.azurecontainerapps.io/agent/coding-agent"
+// DefaultUpstreamBaseURL Orchids 上游服务的默认地址
+const DefaultUpstreamBaseURL = "https://orchids-server.calmstone-6964e08a.westeurope.azurecontainerapps.io"
+
+var upstreamURL = DefaultUpstreamBaseURL + "/agent/coding-agent"
+
+// SetUpstreamBaseURL 修改上游服务地址（如预发布镜像或本地 fakeupstream），为空时使用默认地址。
+// 需在发送请求前调用
+func SetUpstreamBaseURL(baseURL string) {
+	if baseURL == "" {
+		baseURL = DefaultUpstreamBaseURL
+	}
+	upstreamURL = strings.TrimRight(baseURL, "/") + "/agent/coding-agent"
+}
 
 type Client struct {
 	config     *config.Config
@@ -43,6 +59,33 @@ type AgentRequest struct {
 	Model         string        `json:"model,omitempty"`
 }
 
+// UpstreamError 上游（Clerk 或 Orchids）返回的非 200 响应
+type UpstreamError struct {
+	Op         string
+	StatusCode int
+	Body       string
+}
+
+func (e *UpstreamError) Error() string {
+	return fmt.Sprintf("%s request failed with status %d: %s", e.Op, e.StatusCode, e.Body)
+}
+
+// IsAccountError 判断错误是否应计入账号健康状态：
+// 客户端取消、请求本身无效（400/404/413/422）等与账号无关的错误不计入
+func IsAccountError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var upstreamErr *UpstreamError
+	if errors.As(err, &upstreamErr) {
+		switch upstreamErr.StatusCode {
+		case http.StatusBadRequest, http.StatusNotFound, http.StatusRequestEntityTooLarge, http.StatusUnprocessableEntity:
+			return false
+		}
+	}
+	return true
+}
+
 type SSEMessage struct {
 	Type  string                 `json:"type"`
 	Event map[string]interface{} `json:"event,omitempty"`
@@ -73,10 +116,60 @@ func NewFromAccount(acc *store.Account) *Client {
 	}
 }
 
+// WithAgentMode 返回使用指定 agentMode 的副本，mode 为空时返回自身
+func (c *Client) WithAgentMode(mode string) *Client {
+	if mode == "" || mode == c.config.AgentMode {
+		return c
+	}
+	cfg := *c.config
+	cfg.AgentMode = mode
+	return &Client{config: &cfg, account: c.account, httpClient: c.httpClient}
+}
+
+// GetToken 直接向 Clerk 获取新的 token 并更新缓存，用于探测会话是否可用
 func (c *Client) GetToken() (string, error) {
-	url := fmt.Sprintf("https://clerk.orchids.app/v1/client/sessions/%s/tokens?__clerk_api_version=2025-11-10&_clerk_js_version=5.117.0", c.config.SessionID)
+	ctx, cancel := context.WithTimeout(context.Background(), tokenFetchTimeout)
+	defer cancel()
+	jwt, err := c.fetchToken(ctx)
+	if err != nil {
+		return "", err
+	}
+	tokens.put(c.config.SessionID, jwt)
+	return jwt, nil
+}
+
+// token 返回缓存的 token，按需刷新
+func (c *Client) token(ctx context.Context) (string, error) {
+	return tokens.get(ctx, c.config.SessionID, c.fetchToken)
+}
+
+// doUpstream 带 token 发送上游请求，返回 401 时作废缓存的 token 并重新获取后重试一次。
+// newRequest 每次调用都需构造新的请求
+func (c *Client) doUpstream(ctx context.Context, newRequest func(token string) (*http.Request, error)) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		token, err := c.token(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get token: %w", err)
+		}
+		req, err := newRequest(token)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusUnauthorized || attempt > 0 {
+			return resp, nil
+		}
+		resp.Body.Close()
+		tokens.invalidate(c.config.SessionID, token)
+		log.Printf("上游返回 401，重新获取 token 后重试")
+	}
+}
 
-	req, err := http.NewRequest("POST", url, strings.NewReader("organization_id="))
+func (c *Client) fetchToken(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", clerk.TokenURL(c.config.SessionID), strings.NewReader("organization_id="))
 	if err != nil {
 		return "", err
 	}
@@ -92,7 +185,7 @@ func (c *Client) GetToken() (string, error) {
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("token request failed with status %d: %s", resp.StatusCode, string(body))
+		return "", &UpstreamError{Op: "token", StatusCode: resp.StatusCode, Body: string(body)}
 	}
 
 	var tokenResp TokenResponse
@@ -104,11 +197,6 @@ func (c *Client) GetToken() (string, error) {
 }
 
 func (c *Client) SendRequest(ctx context.Context, prompt string, chatHistory []interface{}, model string, onMessage func(SSEMessage), logger *debug.Logger) error {
-	token, err := c.GetToken()
-	if err != nil {
-		return fmt.Errorf("failed to get token: %w", err)
-	}
-
 	payload := AgentRequest{
 		Prompt:        prompt,
 		ChatHistory:   chatHistory,
@@ -129,16 +217,6 @@ func (c *Client) SendRequest(ctx context.Context, prompt string, chatHistory []i
 		return err
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "POST", upstreamURL, bytes.NewReader(body))
-	if err != nil {
-		return err
-	}
-
-	req.Header.Set("Accept", "text/event-stream")
-	req.Header.Set("Authorization", "Bearer "+token)
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-Orchids-Api-Version", "2")
-
 	// 记录上游请求
 	if logger != nil {
 		headers := map[string]string{
@@ -150,7 +228,17 @@ func (c *Client) SendRequest(ctx context.Context, prompt string, chatHistory []i
 		logger.LogUpstreamRequest(upstreamURL, headers, payload)
 	}
 
-	resp, err := c.httpClient.Do(req)
+	resp, err := c.doUpstream(ctx, func(token string) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", upstreamURL, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", "text/event-stream")
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Orchids-Api-Version", "2")
+		return req, nil
+	})
 	if err != nil {
 		return err
 	}
@@ -158,7 +246,7 @@ func (c *Client) SendRequest(ctx context.Context, prompt string, chatHistory []i
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("upstream request failed with status %d: %s", resp.StatusCode, string(body))
+		return &UpstreamError{Op: "upstream", StatusCode: resp.StatusCode, Body: string(body)}
 	}
 
 	reader := bufio.NewReader(resp.Body)
@@ -235,85 +323,93 @@ func (c *Client) SendRequest(ctx context.Context, prompt string, chatHistory []i
 }
 
 func (c *Client) GenerateImage(ctx context.Context, prompt string, size string) (string, error) {
-	token, err := c.GetToken()
-	if err != nil {
-		return "", fmt.Errorf("failed to get token: %w", err)
-	}
-
-	payload := map[string]interface{}{
+	return c.requestMedia(ctx, map[string]interface{}{
 		"prompt": prompt,
 		"size":   size,
 		"n":      1,
-	}
-
-	body, err := json.Marshal(payload)
-	if err != nil {
-		return "", err
-	}
-
-	req, err := http.NewRequestWithContext(ctx, "POST", upstreamURL, bytes.NewReader(body))
-	if err != nil {
-		return "", err
-	}
-
-	req.Header.Set("Authorization", "Bearer "+token)
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-Orchids-Api-Version", "2")
-
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
+	})
+}
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("upstream request failed with status %d: %s", resp.StatusCode, string(body))
+// EditImage 按提示词编辑图片，image 与 mask 为 PNG 数据，mask 可为空
+func (c *Client) EditImage(ctx context.Context, prompt string, size string, image []byte, mask []byte) (string, error) {
+	payload := map[string]interface{}{
+		"operation": "edit",
+		"prompt":    prompt,
+		"size":      size,
+		"n":         1,
+		"image":     pngDataURL(image),
 	}
-
-	var response map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		return "", err
-	}
-
-	if data, ok := response["data"].([]interface{}); ok && len(data) > 0 {
-		if item, ok := data[0].(map[string]interface{}); ok {
-			if url, ok := item["url"].(string); ok {
-				return url, nil
-			}
-		}
+	if len(mask) > 0 {
+		payload["mask"] = pngDataURL(mask)
 	}
+	return c.requestMedia(ctx, payload)
+}
 
-	return "", fmt.Errorf("invalid response format")
+// CreateImageVariation 生成图片变体，image 为 PNG 数据
+func (c *Client) CreateImageVariation(ctx context.Context, size string, image []byte) (string, error) {
+	return c.requestMedia(ctx, map[string]interface{}{
+		"operation": "variation",
+		"size":      size,
+		"n":         1,
+		"image":     pngDataURL(image),
+	})
 }
 
-func (c *Client) GenerateVideo(ctx context.Context, prompt string, size string) (string, error) {
-	token, err := c.GetToken()
-	if err != nil {
-		return "", fmt.Errorf("failed to get token: %w", err)
-	}
+// VideoOptions 视频生成参数，调用前由 handler 按模型能力校验
+type VideoOptions struct {
+	Model          string `json:"model,omitempty"`
+	Size           string `json:"size"`
+	AspectRatio    string `json:"aspect_ratio,omitempty"`
+	Seconds        int    `json:"seconds,omitempty"`
+	FPS            int    `json:"fps,omitempty"`
+	Seed           *int64 `json:"seed,omitempty"`
+	ReferenceImage []byte `json:"reference_image,omitempty"` // PNG，图生视频
+}
 
+func (c *Client) GenerateVideo(ctx context.Context, prompt string, opts VideoOptions) (string, error) {
 	payload := map[string]interface{}{
 		"prompt": prompt,
-		"size":   size,
+		"size":   opts.Size,
 		"n":      1,
 	}
-
-	body, err := json.Marshal(payload)
-	if err != nil {
-		return "", err
+	if opts.Model != "" {
+		payload["model"] = opts.Model
+	}
+	if opts.AspectRatio != "" {
+		payload["aspect_ratio"] = opts.AspectRatio
 	}
+	if opts.Seconds > 0 {
+		payload["duration"] = opts.Seconds
+	}
+	if opts.FPS > 0 {
+		payload["fps"] = opts.FPS
+	}
+	if opts.Seed != nil {
+		payload["seed"] = *opts.Seed
+	}
+	if len(opts.ReferenceImage) > 0 {
+		payload["image"] = pngDataURL(opts.ReferenceImage)
+	}
+	return c.requestMedia(ctx, payload)
+}
 
-	req, err := http.NewRequestWithContext(ctx, "POST", upstreamURL, bytes.NewReader(body))
+// requestMedia 发送媒体生成请求，返回第一个结果的 URL
+func (c *Client) requestMedia(ctx context.Context, payload map[string]interface{}) (string, error) {
+	body, err := json.Marshal(payload)
 	if err != nil {
 		return "", err
 	}
 
-	req.Header.Set("Authorization", "Bearer "+token)
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-Orchids-Api-Version", "2")
-
-	resp, err := c.httpClient.Do(req)
+	resp, err := c.doUpstream(ctx, func(token string) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", upstreamURL, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Orchids-Api-Version", "2")
+		return req, nil
+	})
 	if err != nil {
 		return "", err
 	}
@@ -321,7 +417,7 @@ func (c *Client) GenerateVideo(ctx context.Context, prompt string, size string)
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("upstream request failed with status %d: %s", resp.StatusCode, string(body))
+		return "", &UpstreamError{Op: "upstream", StatusCode: resp.StatusCode, Body: string(body)}
 	}
 
 	var response map[string]interface{}
@@ -339,3 +435,7 @@ func (c *Client) GenerateVideo(ctx context.Context, prompt string, size string)
 
 	return "", fmt.Errorf("invalid response format")
 }
+
+func pngDataURL(data []byte) string {
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(data)
+}
@@ -0,0 +1,38 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestIsAccountError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"客户端取消", context.Canceled, false},
+		{"包装的客户端取消", fmt.Errorf("read stream: %w", context.Canceled), false},
+		{"400", &UpstreamError{StatusCode: http.StatusBadRequest}, false},
+		{"404", &UpstreamError{StatusCode: http.StatusNotFound}, false},
+		{"413", &UpstreamError{StatusCode: http.StatusRequestEntityTooLarge}, false},
+		{"422", &UpstreamError{StatusCode: http.StatusUnprocessableEntity}, false},
+		{"包装的 400", fmt.Errorf("send: %w", &UpstreamError{StatusCode: http.StatusBadRequest}), false},
+		{"401", &UpstreamError{StatusCode: http.StatusUnauthorized}, true},
+		{"429", &UpstreamError{StatusCode: http.StatusTooManyRequests}, true},
+		{"503", &UpstreamError{StatusCode: http.StatusServiceUnavailable}, true},
+		{"超时", context.DeadlineExceeded, true},
+		{"网络错误", errors.New("connection reset by peer"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsAccountError(tt.err); got != tt.want {
+				t.Errorf("IsAccountError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
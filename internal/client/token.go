@@ -0,0 +1,167 @@
+package client
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// tokenRefreshMargin 剩余有效期低于该值时在后台提前刷新，当前请求继续使用缓存的 token
+	tokenRefreshMargin = 20 * time.Second
+	// tokenMinTTL 剩余有效期低于该值时不再使用，同步等待刷新
+	tokenMinTTL = 5 * time.Second
+	// tokenFetchTimeout 单次向 Clerk 获取 token 的超时
+	tokenFetchTimeout = 10 * time.Second
+)
+
+// tokens 按 SessionID 缓存的上游 JWT，所有 Client 共享
+var tokens = newTokenCache()
+
+type cachedToken struct {
+	jwt       string
+	expiresAt time.Time
+}
+
+// tokenCall 正在进行的获取，同一会话的并发请求共用一次获取
+type tokenCall struct {
+	done chan struct{}
+	jwt  string
+	err  error
+}
+
+type tokenCache struct {
+	mu      sync.Mutex
+	entries map[string]cachedToken
+	calls   map[string]*tokenCall
+	now     func() time.Time
+	timeout time.Duration
+}
+
+func newTokenCache() *tokenCache {
+	return &tokenCache{
+		entries: make(map[string]cachedToken),
+		calls:   make(map[string]*tokenCall),
+		now:     time.Now,
+		timeout: tokenFetchTimeout,
+	}
+}
+
+// get 返回会话的 token：缓存有效时直接返回，临近过期时在后台刷新，已过期或不存在时等待获取
+func (tc *tokenCache) get(ctx context.Context, key string, fetch func(context.Context) (string, error)) (string, error) {
+	tc.mu.Lock()
+	now := tc.now()
+	if e, ok := tc.entries[key]; ok && now.Before(e.expiresAt.Add(-tokenMinTTL)) {
+		if !now.Before(e.expiresAt.Add(-tokenRefreshMargin)) {
+			tc.startLocked(key, fetch)
+		}
+		tc.mu.Unlock()
+		return e.jwt, nil
+	}
+	call := tc.startLocked(key, fetch)
+	tc.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.jwt, call.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// startLocked 发起获取，已有进行中的获取时复用。获取不依赖发起请求的 ctx，
+// 超过 tc.timeout 即结束并移除，卡住的 Clerk 请求不会阻塞之后的获取。调用方需持有 tc.mu
+func (tc *tokenCache) startLocked(key string, fetch func(context.Context) (string, error)) *tokenCall {
+	if call, ok := tc.calls[key]; ok {
+		return call
+	}
+	call := &tokenCall{done: make(chan struct{})}
+	tc.calls[key] = call
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), tc.timeout)
+		defer cancel()
+
+		type result struct {
+			jwt string
+			err error
+		}
+		results := make(chan result, 1)
+		go func() {
+			jwt, err := fetch(ctx)
+			results <- result{jwt, err}
+		}()
+		select {
+		case r := <-results:
+			call.jwt, call.err = r.jwt, r.err
+		case <-ctx.Done():
+			call.err = ctx.Err()
+		}
+
+		tc.mu.Lock()
+		delete(tc.calls, key)
+		if call.err == nil {
+			tc.storeLocked(key, call.jwt)
+		}
+		tc.mu.Unlock()
+		close(call.done)
+	}()
+	return call
+}
+
+// put 缓存直接获取到的 token
+func (tc *tokenCache) put(key, jwt string) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	tc.storeLocked(key, jwt)
+}
+
+// storeLocked 按 exp 缓存 token，无法解析 exp 时不缓存，顺带清理已过期的条目。调用方需持有 tc.mu
+func (tc *tokenCache) storeLocked(key, jwt string) {
+	now := tc.now()
+	for k, e := range tc.entries {
+		if !now.Before(e.expiresAt) {
+			delete(tc.entries, k)
+		}
+	}
+	expiresAt, err := jwtExpiry(jwt)
+	if err != nil || !now.Before(expiresAt) {
+		delete(tc.entries, key)
+		return
+	}
+	tc.entries[key] = cachedToken{jwt: jwt, expiresAt: expiresAt}
+}
+
+// invalidate 作废被上游拒绝的 token；缓存已被更新为其他 token 时保留
+func (tc *tokenCache) invalidate(key, jwt string) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	if e, ok := tc.entries[key]; ok && e.jwt == jwt {
+		delete(tc.entries, key)
+	}
+}
+
+// jwtExpiry 解析 JWT payload 中的 exp，不校验签名
+func jwtExpiry(jwt string) (time.Time, error) {
+	parts := strings.Split(jwt, ".")
+	if len(parts) != 3 {
+		return time.Time{}, errors.New("malformed jwt")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return time.Time{}, err
+	}
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return time.Time{}, err
+	}
+	if claims.Exp == 0 {
+		return time.Time{}, errors.New("jwt has no exp claim")
+	}
+	return time.Unix(claims.Exp, 0), nil
+}
@@ -0,0 +1,163 @@
+package client
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testJWT(exp time.Time) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"sub":"user","exp":%d}`, exp.Unix())))
+	return "eyJhbGciOiJSUzI1NiJ9." + payload + ".sig"
+}
+
+func TestJWTExpiry(t *testing.T) {
+	exp := time.Unix(1760000000, 0)
+	got, err := jwtExpiry(testJWT(exp))
+	if err != nil || !got.Equal(exp) {
+		t.Errorf("got %v (%v), want %v", got, err, exp)
+	}
+	for _, bad := range []string{"", "abc", "a.!!.c", "a." + base64.RawURLEncoding.EncodeToString([]byte(`{}`)) + ".c"} {
+		if _, err := jwtExpiry(bad); err == nil {
+			t.Errorf("jwtExpiry(%q) 应返回错误", bad)
+		}
+	}
+}
+
+func TestTokenCache(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1760000000, 0)
+	var mu sync.Mutex
+	tc := newTokenCache()
+	tc.now = func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}
+
+	var fetches atomic.Int32
+	next := testJWT(now.Add(time.Minute))
+	fetch := func(context.Context) (string, error) {
+		fetches.Add(1)
+		mu.Lock()
+		defer mu.Unlock()
+		return next, nil
+	}
+
+	// 并发的首次获取只请求一次
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := tc.get(ctx, "sess", fetch); err != nil {
+				t.Errorf("get: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if got := fetches.Load(); got != 1 {
+		t.Fatalf("got %d fetches, want 1", got)
+	}
+
+	// 有效期内直接使用缓存
+	first, _ := tc.get(ctx, "sess", fetch)
+	if got := fetches.Load(); got != 1 {
+		t.Errorf("缓存命中: got %d fetches, want 1", got)
+	}
+
+	// 临近过期时返回旧 token，并在后台刷新
+	mu.Lock()
+	now = now.Add(45 * time.Second)
+	next = testJWT(now.Add(time.Minute))
+	mu.Unlock()
+	if got, _ := tc.get(ctx, "sess", fetch); got != first {
+		t.Error("临近过期时应先返回缓存的 token")
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		tc.mu.Lock()
+		refreshed := tc.entries["sess"].jwt == next
+		tc.mu.Unlock()
+		if refreshed {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("后台刷新未完成")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if got := fetches.Load(); got != 2 {
+		t.Errorf("提前刷新: got %d fetches, want 2", got)
+	}
+
+	// 作废旧 token 不影响已刷新的缓存
+	tc.invalidate("sess", first)
+	if got, _ := tc.get(ctx, "sess", fetch); got != next {
+		t.Error("作废旧 token 不应删除新 token")
+	}
+	tc.invalidate("sess", next)
+	tc.get(ctx, "sess", fetch)
+	if got := fetches.Load(); got != 3 {
+		t.Errorf("作废后: got %d fetches, want 3", got)
+	}
+
+	// 已过期的 token 不再使用
+	mu.Lock()
+	now = now.Add(2 * time.Minute)
+	next = testJWT(now.Add(time.Minute))
+	mu.Unlock()
+	if got, _ := tc.get(ctx, "sess", fetch); got != next {
+		t.Error("过期后应同步获取新 token")
+	}
+}
+
+func TestTokenCacheError(t *testing.T) {
+	tc := newTokenCache()
+	wantErr := fmt.Errorf("clerk down")
+	if _, err := tc.get(context.Background(), "sess", func(context.Context) (string, error) { return "", wantErr }); err != wantErr {
+		t.Errorf("got %v, want %v", err, wantErr)
+	}
+	if len(tc.entries) != 0 {
+		t.Error("获取失败不应缓存")
+	}
+}
+
+func TestTokenCacheFetchTimeout(t *testing.T) {
+	tc := newTokenCache()
+	tc.timeout = 20 * time.Millisecond
+
+	// 忽略 ctx 一直卡住的获取也会在超时后移除
+	hang := make(chan struct{})
+	defer close(hang)
+	_, err := tc.get(context.Background(), "sess", func(context.Context) (string, error) {
+		<-hang
+		return "", nil
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+	tc.mu.Lock()
+	pending := len(tc.calls)
+	tc.mu.Unlock()
+	if pending != 0 {
+		t.Fatalf("超时的获取仍在 calls 中: %d", pending)
+	}
+
+	// 之后的请求重新发起获取，并且获取拿到带超时的 ctx
+	want := testJWT(time.Now().Add(time.Minute))
+	got, err := tc.get(context.Background(), "sess", func(ctx context.Context) (string, error) {
+		if _, ok := ctx.Deadline(); !ok {
+			t.Error("获取的 ctx 没有超时")
+		}
+		return want, nil
+	})
+	if err != nil || got != want {
+		t.Errorf("got %q (%v), want new token", got, err)
+	}
+}
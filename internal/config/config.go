@@ -2,6 +2,8 @@ package config
 
 import (
 	"os"
+	"strconv"
+	"time"
 )
 
 type Config struct {
@@ -18,6 +20,22 @@ type Config struct {
 	AdminPass    string
 	AdminPath    string
 	OpenAIKey    string
+	VideoWorkers int
+
+	HealthProbeInterval time.Duration
+	StickySessions      bool
+	StickySessionTTL    time.Duration
+	QueueSize           int
+	QueueTimeout        time.Duration
+	FlushInterval       time.Duration
+
+	PublicBaseURL string
+	MediaDir      string
+	MediaTTL      time.Duration
+
+	// 为空时使用官方地址
+	UpstreamBaseURL string
+	ClerkBaseURL    string
 }
 
 func Load() *Config {
@@ -35,6 +53,21 @@ func Load() *Config {
 		AdminPass:    getEnv("ADMIN_PASS", "admin123"),
 		AdminPath:    getEnv("ADMIN_PATH", "/admin"),
 		OpenAIKey:    getEnv("OPENAI_KEY", ""),
+		VideoWorkers: getEnvInt("VIDEO_WORKERS", 2),
+
+		HealthProbeInterval: getEnvDuration("HEALTH_PROBE_INTERVAL", 15*time.Second),
+		StickySessions:      getEnv("STICKY_SESSIONS", "true") == "true",
+		StickySessionTTL:    getEnvDuration("STICKY_SESSION_TTL", 30*time.Minute),
+		QueueSize:           getEnvInt("QUEUE_SIZE", 100),
+		QueueTimeout:        getEnvDuration("QUEUE_TIMEOUT", 30*time.Second),
+		FlushInterval:       getEnvDuration("COUNTER_FLUSH_INTERVAL", 5*time.Second),
+
+		PublicBaseURL: getEnv("PUBLIC_BASE_URL", ""),
+		MediaDir:      getEnv("MEDIA_CACHE_DIR", "data/media"),
+		MediaTTL:      getEnvDuration("MEDIA_CACHE_TTL", 24*time.Hour),
+
+		UpstreamBaseURL: getEnv("UPSTREAM_BASE_URL", ""),
+		ClerkBaseURL:    getEnv("CLERK_BASE_URL", ""),
 	}
 }
 
@@ -48,3 +81,17 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+func getEnvInt(key string, defaultValue int) int {
+	if n, err := strconv.Atoi(os.Getenv(key)); err == nil && n > 0 {
+		return n
+	}
+	return defaultValue
+}
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	if d, err := time.ParseDuration(os.Getenv(key)); err == nil && d > 0 {
+		return d
+	}
+	return defaultValue
+}
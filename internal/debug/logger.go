@@ -123,6 +123,25 @@ func (l *Logger) LogOutputSSE(event string, data string) {
 	fmt.Fprintf(l.outFile, "[%dms] event: %s\ndata: %s\n\n", elapsed, event, data)
 }
 
+// LogModelFallback 记录模型降级（追加写入）
+func (l *Logger) LogModelFallback(from, to string, reason error) {
+	if !l.enabled {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	f, err := os.OpenFile(filepath.Join(l.dir, "model_fallback.log"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	elapsed := time.Since(l.startTime).Milliseconds()
+	fmt.Fprintf(f, "[%dms] %s -> %s: %v\n", elapsed, from, to, reason)
+}
+
 // LogSummary 记录请求摘要
 func (l *Logger) LogSummary(inputTokens, outputTokens int, duration time.Duration, stopReason string) {
 	if !l.enabled {
@@ -0,0 +1,297 @@
+package fakeupstream
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Options 模拟账号的信息，为空的字段使用默认值
+type Options struct {
+	SessionID string
+	UserID    string
+	Email     string
+	TokenTTL  time.Duration // 签发的 JWT 有效期，默认与 Clerk 一致为 60 秒
+}
+
+// Stats 各接口收到的请求数
+type Stats struct {
+	ClientRequests int // Clerk /v1/client
+	TokenRequests  int // Clerk 获取 JWT
+	AgentRequests  int // coding-agent 对话
+	MediaRequests  int // coding-agent 图片、视频
+	Unauthorized   int // 因 token 无效返回 401 的请求
+}
+
+// Request 记录的上游请求
+type Request struct {
+	Prompt    string
+	Model     string
+	AgentMode string
+	Media     bool
+}
+
+// Server 模拟 Clerk 的 client 与 token 接口，以及 Orchids coding-agent 的 SSE 协议，
+// 网关的 UPSTREAM_BASE_URL 与 CLERK_BASE_URL 都指向它即可离线运行
+type Server struct {
+	opts Options
+	mux  *http.ServeMux
+
+	mu       sync.Mutex
+	script   []*scriptEntry
+	issued   int64 // 已签发的 token 序号
+	revoked  int64 // 序号不大于该值的 token 已失效
+	stats    Stats
+	requests []Request
+}
+
+func New(opts Options) *Server {
+	if opts.SessionID == "" {
+		opts.SessionID = "sess_fake"
+	}
+	if opts.UserID == "" {
+		opts.UserID = "user_fake"
+	}
+	if opts.Email == "" {
+		opts.Email = "fake@example.com"
+	}
+	if opts.TokenTTL <= 0 {
+		opts.TokenTTL = time.Minute
+	}
+
+	s := &Server{opts: opts, mux: http.NewServeMux()}
+	s.mux.HandleFunc("/v1/client", s.handleClient)
+	s.mux.HandleFunc("/v1/client/sessions/", s.handleToken)
+	s.mux.HandleFunc("/agent/coding-agent", s.handleAgent)
+	s.mux.HandleFunc("/media/", handleMedia)
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Stats 返回各接口的请求数
+func (s *Server) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stats
+}
+
+// Requests 返回收到的 coding-agent 请求
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// RevokeTokens 使已签发的 token 全部失效，之后的上游请求返回 401，用于测试 token 刷新
+func (s *Server) RevokeTokens() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.revoked = s.issued
+}
+
+func (s *Server) handleClient(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !hasClientCookie(r) {
+		writeJSON(w, http.StatusUnauthorized, clerkError("signed_out", "client cookie is missing"))
+		return
+	}
+
+	s.mu.Lock()
+	s.stats.ClientRequests++
+	jwt := s.issueLocked(s.opts.SessionID)
+	s.mu.Unlock()
+
+	session := map[string]interface{}{
+		"id":     s.opts.SessionID,
+		"status": "active",
+		"user": map[string]interface{}{
+			"id": s.opts.UserID,
+			"email_addresses": []map[string]string{
+				{"email_address": s.opts.Email},
+			},
+		},
+		"last_active_token": map[string]string{"jwt": jwt},
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"response": map[string]interface{}{
+			"id":                     "client_fake",
+			"last_active_session_id": s.opts.SessionID,
+			"sessions":               []interface{}{session},
+		},
+	})
+}
+
+// handleToken POST /v1/client/sessions/{id}/tokens
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	sessionID, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/v1/client/sessions/"), "/tokens")
+	if !ok || sessionID == "" || strings.Contains(sessionID, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !hasClientCookie(r) {
+		writeJSON(w, http.StatusUnauthorized, clerkError("signed_out", "client cookie is missing"))
+		return
+	}
+
+	s.mu.Lock()
+	s.stats.TokenRequests++
+	jwt := s.issueLocked(sessionID)
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]string{"object": "token", "jwt": jwt})
+}
+
+func (s *Server) handleAgent(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var payload map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	prompt, _ := payload["prompt"].(string)
+	model, _ := payload["model"].(string)
+	agentMode, _ := payload["agentMode"].(string)
+	// 对话请求总是带 chatHistory，图片与视频请求没有
+	_, isAgent := payload["chatHistory"]
+
+	s.mu.Lock()
+	if err := s.verifyLocked(r.Header.Get("Authorization")); err != nil {
+		s.stats.Unauthorized++
+		s.mu.Unlock()
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": err.Error()})
+		return
+	}
+	if isAgent {
+		s.stats.AgentRequests++
+	} else {
+		s.stats.MediaRequests++
+	}
+	s.requests = append(s.requests, Request{Prompt: prompt, Model: model, AgentMode: agentMode, Media: !isAgent})
+	resp := s.nextLocked(prompt)
+	s.mu.Unlock()
+
+	if resp.Status != 0 && resp.Status != http.StatusOK {
+		w.WriteHeader(resp.Status)
+		w.Write([]byte(resp.Body))
+		return
+	}
+	if !isAgent {
+		if resp.DelayMS > 0 {
+			select {
+			case <-time.After(time.Duration(resp.DelayMS) * time.Millisecond):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		url := resp.URL
+		if url == "" {
+			url = "http://" + r.Host + "/media/fake.png"
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"data": []map[string]string{{"url": url}},
+		})
+		return
+	}
+	writeEvents(w, r, resp)
+}
+
+// issueLocked 签发会话的 JWT。调用方需持有 s.mu
+func (s *Server) issueLocked(sessionID string) string {
+	s.issued++
+	now := time.Now()
+	claims, _ := json.Marshal(map[string]interface{}{
+		"sid": sessionID,
+		"sub": s.opts.UserID,
+		"iat": now.Unix(),
+		"exp": now.Add(s.opts.TokenTTL).Unix(),
+		"jti": s.issued,
+	})
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`))
+	return header + "." + base64.RawURLEncoding.EncodeToString(claims) + ".fake"
+}
+
+// verifyLocked 检查 Authorization 中的 token 是否由本服务签发且仍有效。调用方需持有 s.mu
+func (s *Server) verifyLocked(authorization string) error {
+	token, ok := strings.CutPrefix(authorization, "Bearer ")
+	if !ok {
+		return errors.New("missing bearer token")
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return errors.New("malformed token")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return errors.New("malformed token")
+	}
+	var claims struct {
+		Exp int64 `json:"exp"`
+		JTI int64 `json:"jti"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return errors.New("malformed token")
+	}
+	if claims.JTI <= s.revoked || claims.JTI > s.issued {
+		return errors.New("token revoked")
+	}
+	if time.Now().Unix() >= claims.Exp {
+		return errors.New("token expired")
+	}
+	return nil
+}
+
+func hasClientCookie(r *http.Request) bool {
+	c, err := r.Cookie("__client")
+	return err == nil && c.Value != ""
+}
+
+func clerkError(code, message string) map[string]interface{} {
+	return map[string]interface{}{
+		"errors": []map[string]string{{"code": code, "message": message}},
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("fakeupstream 写入响应失败: %v", err)
+	}
+}
+
+// fakePNG 媒体请求默认返回的 1x1 图片
+var fakePNG = func() []byte {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 1, 1))); err != nil {
+		panic(fmt.Sprintf("encode fake png: %v", err))
+	}
+	return buf.Bytes()
+}()
+
+func handleMedia(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "image/png")
+	w.Write(fakePNG)
+}
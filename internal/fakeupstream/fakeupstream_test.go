@@ -0,0 +1,128 @@
+package fakeupstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"orchids-api/internal/clerk"
+	"orchids-api/internal/client"
+	"orchids-api/internal/config"
+	"orchids-api/internal/handler"
+	"orchids-api/internal/store"
+)
+
+// startFake 启动 fakeupstream 并让 client 与 clerk 指向它。
+// client 的 token 缓存按 SessionID 全局共享，每次使用不同的会话避免读到上次运行签发的 token
+func startFake(t *testing.T) (*Server, string) {
+	sessionID := fmt.Sprintf("sess_%s_%d", t.Name(), time.Now().UnixNano())
+	fake := New(Options{SessionID: sessionID})
+	srv := httptest.NewServer(fake)
+	client.SetUpstreamBaseURL(srv.URL)
+	clerk.SetBaseURL(srv.URL)
+	t.Cleanup(func() {
+		srv.Close()
+		client.SetUpstreamBaseURL("")
+		clerk.SetBaseURL("")
+	})
+	return fake, sessionID
+}
+
+func collectText(t *testing.T, c *client.Client, prompt string) (string, error) {
+	var text strings.Builder
+	finished := false
+	err := c.SendRequest(context.Background(), prompt, nil, "claude-opus-4.5", func(msg client.SSEMessage) {
+		switch msg.Event["type"] {
+		case "text-delta":
+			delta, _ := msg.Event["delta"].(string)
+			text.WriteString(delta)
+		case "finish":
+			finished = true
+		}
+	}, nil)
+	if err == nil && !finished {
+		t.Error("没有收到 finish 事件")
+	}
+	return text.String(), err
+}
+
+func TestClientAgainstFake(t *testing.T) {
+	fake, sessionID := startFake(t)
+	fake.Script(
+		Response{Match: "broken", Times: 1, Status: http.StatusServiceUnavailable, Body: "overloaded"},
+		Response{Match: "hello", Text: "Hi there, friend."},
+	)
+
+	info, err := clerk.FetchAccountInfo("cookie")
+	if err != nil {
+		t.Fatalf("FetchAccountInfo: %v", err)
+	}
+	if info.SessionID != sessionID || info.Email != "fake@example.com" {
+		t.Errorf("got session %q email %q", info.SessionID, info.Email)
+	}
+
+	c := client.NewFromAccount(&store.Account{SessionID: info.SessionID, ClientCookie: info.ClientCookie, AgentMode: "claude-opus-4.5"})
+	if got, err := collectText(t, c, "say hello"); err != nil || got != "Hi there, friend." {
+		t.Errorf("got %q (%v), want scripted text", got, err)
+	}
+	if got, err := collectText(t, c, "anything"); err != nil || got != DefaultText {
+		t.Errorf("got %q (%v), want %q", got, err, DefaultText)
+	}
+
+	// 第二次请求使用缓存的 token
+	if got := fake.Stats().TokenRequests; got != 1 {
+		t.Errorf("got %d token requests, want 1", got)
+	}
+
+	// token 被吊销后上游返回 401，客户端重新获取后重试成功
+	fake.RevokeTokens()
+	if _, err := collectText(t, c, "hello again"); err != nil {
+		t.Errorf("401 后重试失败: %v", err)
+	}
+	stats := fake.Stats()
+	if stats.Unauthorized != 1 || stats.TokenRequests != 2 {
+		t.Errorf("got %+v, want 1 unauthorized and 2 token requests", stats)
+	}
+
+	// 脚本化的错误状态码
+	_, err = collectText(t, c, "broken")
+	var upstreamErr *client.UpstreamError
+	if !errors.As(err, &upstreamErr) || upstreamErr.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("got %v, want 503 UpstreamError", err)
+	}
+
+	url, err := c.GenerateImage(context.Background(), "a cat", "1024x1024")
+	if err != nil || !strings.HasSuffix(url, "/media/fake.png") {
+		t.Errorf("GenerateImage = %q (%v)", url, err)
+	}
+	if got := fake.Requests(); len(got) != 5 || !got[4].Media || got[0].AgentMode != "claude-opus-4.5" {
+		t.Errorf("got requests %+v", got)
+	}
+}
+
+func TestGatewayAgainstFake(t *testing.T) {
+	fake, sessionID := startFake(t)
+	fake.Script(Response{Text: "offline works"})
+
+	h := handler.New(&config.Config{SessionID: sessionID, ClientCookie: "cookie", AgentMode: "claude-opus-4.5"})
+	body := `{"model":"claude-opus-4-5-20251101","max_tokens":100,"stream":true,"messages":[{"role":"user","content":"hi"}]}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	h.HandleMessages(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body.String())
+	}
+	out := rec.Body.String()
+	if !strings.Contains(out, `"text":"offline "`) || !strings.Contains(out, "message_stop") {
+		t.Errorf("unexpected stream: %s", out)
+	}
+	if got := fake.Stats().AgentRequests; got != 1 {
+		t.Errorf("got %d agent requests, want 1", got)
+	}
+}
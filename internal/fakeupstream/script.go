@@ -0,0 +1,121 @@
+package fakeupstream
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// DefaultText 没有匹配的脚本时返回的文本
+const DefaultText = "This is a response from fakeupstream."
+
+// Response 一条脚本化的上游响应，按加入顺序匹配第一条可用的
+type Response struct {
+	Match   string                   `json:"match,omitempty"`    // prompt 包含该字符串时使用，为空匹配任意请求
+	Times   int                      `json:"times,omitempty"`    // 最多使用次数，0 表示不限
+	Status  int                      `json:"status,omitempty"`   // 非 200 时返回该状态码与 Body
+	Body    string                   `json:"body,omitempty"`     // 错误响应体
+	Text    string                   `json:"text,omitempty"`     // 按词拆分为 text-delta 事件，最后发送 finish
+	Events  []map[string]interface{} `json:"events,omitempty"`   // 原样发送的 model 事件，设置后忽略 Text
+	URL     string                   `json:"url,omitempty"`      // 图片与视频请求返回的地址，默认指向本服务的 /media/
+	DelayMS int                      `json:"delay_ms,omitempty"` // 每个事件之间的间隔，图片与视频请求为返回前的等待
+	Abort   bool                     `json:"abort,omitempty"`    // 发送完事件后直接断开连接，模拟上游中途失败
+}
+
+type scriptEntry struct {
+	Response
+	used int
+}
+
+// Script 追加脚本响应
+func (s *Server) Script(list ...Response) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, resp := range list {
+		s.script = append(s.script, &scriptEntry{Response: resp})
+	}
+}
+
+// LoadScript 从 JSON 文件追加脚本响应，文件内容为 Response 数组
+func (s *Server) LoadScript(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var list []Response
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("parse script %s: %w", path, err)
+	}
+	s.Script(list...)
+	return nil
+}
+
+// nextLocked 返回 prompt 命中的第一条脚本，没有时返回 DefaultText。调用方需持有 s.mu
+func (s *Server) nextLocked(prompt string) Response {
+	for _, entry := range s.script {
+		if entry.Times > 0 && entry.used >= entry.Times {
+			continue
+		}
+		if entry.Match != "" && !strings.Contains(prompt, entry.Match) {
+			continue
+		}
+		entry.used++
+		return entry.Response
+	}
+	return Response{Text: DefaultText}
+}
+
+// writeEvents 按 coding-agent 的 SSE 格式输出 model 事件
+func writeEvents(w http.ResponseWriter, r *http.Request, resp Response) {
+	events := resp.Events
+	if len(events) == 0 {
+		events = textEvents(resp.Text)
+		if resp.Abort {
+			// 中途断开时不发送 text-end 与 finish
+			events = events[:len(events)-2]
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+
+	for i, event := range events {
+		if i > 0 && resp.DelayMS > 0 {
+			select {
+			case <-time.After(time.Duration(resp.DelayMS) * time.Millisecond):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		data, err := json.Marshal(map[string]interface{}{"type": "model", "event": event})
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	if resp.Abort {
+		// 不结束分块编码直接断开，客户端读到 unexpected EOF
+		panic(http.ErrAbortHandler)
+	}
+}
+
+func textEvents(text string) []map[string]interface{} {
+	events := []map[string]interface{}{{"type": "text-start", "id": "0"}}
+	for _, word := range strings.SplitAfter(text, " ") {
+		if word != "" {
+			events = append(events, map[string]interface{}{"type": "text-delta", "id": "0", "delta": word})
+		}
+	}
+	return append(events,
+		map[string]interface{}{"type": "text-end", "id": "0"},
+		map[string]interface{}{"type": "finish", "finishReason": "stop"},
+	)
+}
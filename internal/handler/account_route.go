@@ -0,0 +1,59 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"orchids-api/internal/accountroute"
+)
+
+// SetAccountRouter 设置账号分组规则，未设置时不限制分组
+func (h *Handler) SetAccountRouter(r *accountroute.Router) {
+	h.accountRouter = r
+}
+
+// callerKey 返回请求携带的 API Key，兼容 OpenAI、Claude 与 Gemini 的传递方式
+func callerKey(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+	}
+	if key := r.Header.Get("x-api-key"); key != "" {
+		return key
+	}
+	if key := r.Header.Get("x-goog-api-key"); key != "" {
+		return key
+	}
+	return r.URL.Query().Get("key")
+}
+
+// validKey key 是否为 OPENAI_KEY 中配置的 Key，多个 Key 以逗号分隔
+func (h *Handler) validKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, k := range strings.Split(h.config.OpenAIKey, ",") {
+		if strings.TrimSpace(k) == key {
+			return true
+		}
+	}
+	return false
+}
+
+// accountGroup 按分组规则返回请求可以使用的账号分组，没有命中时不限制
+func (h *Handler) accountGroup(r *http.Request, model string) (group string, spillover bool) {
+	if h.accountRouter == nil {
+		return "", false
+	}
+	// api_key 规则只匹配已配置的 Key，客户端不能靠随意填写的 Key 选择分组
+	key := callerKey(r)
+	if !h.validKey(key) {
+		key = ""
+	}
+	route, ok := h.accountRouter.Match(accountroute.Input{APIKey: key, Model: model, Header: r.Header})
+	if !ok {
+		return "", false
+	}
+	log.Printf("命中账号分组规则 #%d，使用分组 %s", route.ID, route.Group)
+	return route.Group, route.Spillover
+}
@@ -0,0 +1,37 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"orchids-api/internal/prompt"
+)
+
+// sessionHeader 客户端显式指定会话的请求头，同一会话的请求尽量使用同一账号
+const sessionHeader = "X-Session-ID"
+
+// sessionKey 生成会话粘滞的键：优先使用 X-Session-ID 请求头，其次 metadata.user_id，
+// 都没有时取对话开头（系统提示词与第一条消息）的哈希，多轮对话的开头不变
+func sessionKey(r *http.Request, userID string, system []prompt.SystemItem, messages []prompt.Message) string {
+	if v := strings.TrimSpace(r.Header.Get(sessionHeader)); v != "" {
+		return "header:" + v
+	}
+	if userID != "" {
+		return "user:" + userID
+	}
+	if len(messages) == 0 {
+		return ""
+	}
+
+	hash := sha256.New()
+	for _, item := range system {
+		hash.Write([]byte(item.Text))
+		hash.Write([]byte{0})
+	}
+	first, _ := json.Marshal(messages[0])
+	hash.Write(first)
+	return "prefix:" + hex.EncodeToString(hash.Sum(nil)[:16])
+}
@@ -0,0 +1,108 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"orchids-api/internal/clerk"
+	"orchids-api/internal/client"
+	"orchids-api/internal/config"
+	"orchids-api/internal/fakeupstream"
+	"orchids-api/internal/loadbalancer"
+	"orchids-api/internal/modelroute"
+	"orchids-api/internal/store"
+)
+
+// testSessionSeq client 的 token 缓存按 SessionID 全局共享，每个测试账号使用不同的会话
+var testSessionSeq atomic.Int64
+
+// testGateway 上游指向 fakeupstream 的 Handler
+type testGateway struct {
+	h     *Handler
+	fake  *fakeupstream.Server
+	store *store.Store
+	lb    *loadbalancer.LoadBalancer
+}
+
+// newTestGateway 启动 fakeupstream 并创建带负载均衡的 Handler。
+// accounts 的会话与 cookie 自动填充，未设置权重时为 1，写入后 ID 已回填
+func newTestGateway(t *testing.T, accounts ...*store.Account) *testGateway {
+	t.Helper()
+	fake := fakeupstream.New(fakeupstream.Options{})
+	srv := httptest.NewServer(fake)
+	client.SetUpstreamBaseURL(srv.URL)
+	clerk.SetBaseURL(srv.URL)
+	t.Cleanup(func() {
+		srv.Close()
+		client.SetUpstreamBaseURL("")
+		clerk.SetBaseURL("")
+	})
+
+	s, err := store.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	for _, acc := range accounts {
+		acc.SessionID = fmt.Sprintf("sess_test_%d_%d", time.Now().UnixNano(), testSessionSeq.Add(1))
+		acc.ClientCookie = "cookie"
+		acc.Enabled = true
+		if acc.Weight == 0 {
+			acc.Weight = 1
+		}
+		if err := s.CreateAccount(acc); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	lb := loadbalancer.New(s)
+	return &testGateway{h: NewWithLoadBalancer(&config.Config{}, lb), fake: fake, store: s, lb: lb}
+}
+
+// setRoutes 以 routes 替换建库时写入的默认路由
+func (g *testGateway) setRoutes(t *testing.T, routes ...store.ModelRoute) {
+	t.Helper()
+	seeded, err := g.store.ListModelRoutes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, route := range seeded {
+		if err := g.store.DeleteModelRoute(route.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := range routes {
+		if err := g.store.CreateModelRoute(&routes[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r, err := modelroute.New(g.store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.h.SetModelRouter(r)
+}
+
+// serve 以 body 调用 handler，返回响应
+func (g *testGateway) serve(handler http.HandlerFunc, method, path, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+	return rec
+}
+
+// agentModes 按顺序返回 fakeupstream 收到的对话请求所用的 agent mode，测试中用来区分账号
+func (g *testGateway) agentModes() []string {
+	var modes []string
+	for _, req := range g.fake.Requests() {
+		if !req.Media {
+			modes = append(modes, req.AgentMode)
+		}
+	}
+	return modes
+}
@@ -0,0 +1,494 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"orchids-api/internal/debug"
+	"orchids-api/internal/prompt"
+	"orchids-api/internal/tiktoken"
+)
+
+// Gemini 请求格式
+type GeminiRequest struct {
+	Contents          []GeminiContent         `json:"contents"`
+	SystemInstruction *GeminiContent          `json:"systemInstruction,omitempty"`
+	Tools             []GeminiTool            `json:"tools,omitempty"`
+	GenerationConfig  *GeminiGenerationConfig `json:"generationConfig,omitempty"`
+}
+
+type GeminiContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []GeminiPart `json:"parts"`
+}
+
+type GeminiPart struct {
+	Text             string                  `json:"text,omitempty"`
+	InlineData       *GeminiInlineData       `json:"inlineData,omitempty"`
+	FunctionCall     *GeminiFunctionCall     `json:"functionCall,omitempty"`
+	FunctionResponse *GeminiFunctionResponse `json:"functionResponse,omitempty"`
+}
+
+type GeminiInlineData struct {
+	MimeType string `json:"mimeType"`
+	Data     string `json:"data"`
+}
+
+type GeminiFunctionCall struct {
+	Name string                 `json:"name"`
+	Args map[string]interface{} `json:"args,omitempty"`
+}
+
+type GeminiFunctionResponse struct {
+	Name     string      `json:"name"`
+	Response interface{} `json:"response,omitempty"`
+}
+
+type GeminiTool struct {
+	FunctionDeclarations []GeminiFunctionDeclaration `json:"functionDeclarations,omitempty"`
+}
+
+type GeminiFunctionDeclaration struct {
+	Name        string      `json:"name"`
+	Description string      `json:"description,omitempty"`
+	Parameters  interface{} `json:"parameters,omitempty"`
+}
+
+type GeminiGenerationConfig struct {
+	CandidateCount  int      `json:"candidateCount,omitempty"`
+	MaxOutputTokens int      `json:"maxOutputTokens,omitempty"`
+	StopSequences   []string `json:"stopSequences,omitempty"`
+	Temperature     *float64 `json:"temperature,omitempty"`
+}
+
+// GeminiCountTokensRequest countTokens 请求，兼容直接传 contents 或包一层 generateContentRequest
+type GeminiCountTokensRequest struct {
+	GeminiRequest
+	GenerateContentRequest *GeminiRequest `json:"generateContentRequest,omitempty"`
+}
+
+// Gemini 响应格式
+type GeminiResponse struct {
+	Candidates    []GeminiCandidate    `json:"candidates"`
+	UsageMetadata *GeminiUsageMetadata `json:"usageMetadata,omitempty"`
+	ModelVersion  string               `json:"modelVersion,omitempty"`
+}
+
+type GeminiCandidate struct {
+	Content      GeminiContent `json:"content"`
+	FinishReason string        `json:"finishReason,omitempty"`
+	Index        int           `json:"index"`
+}
+
+type GeminiUsageMetadata struct {
+	PromptTokenCount     int `json:"promptTokenCount"`
+	CandidatesTokenCount int `json:"candidatesTokenCount"`
+	TotalTokenCount      int `json:"totalTokenCount"`
+}
+
+type GeminiCountTokensResponse struct {
+	TotalTokens int `json:"totalTokens"`
+}
+
+// GeminiErrorResponse Gemini 错误响应格式
+type GeminiErrorResponse struct {
+	Error GeminiErrorDetail `json:"error"`
+}
+
+type GeminiErrorDetail struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Status  string `json:"status"`
+}
+
+// HandleGemini 处理 /v1beta/models/{model}:{action} 请求
+func (h *Handler) HandleGemini(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, "/v1beta/models/")
+	model, action, ok := strings.Cut(rest, ":")
+	if !ok || model == "" {
+		writeGeminiError(w, newOpenAIError(http.StatusNotFound, "invalid_request_error", "Unknown Gemini endpoint: "+r.URL.Path, "", ""))
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		writeGeminiError(w, errMethodNotAllowed())
+		return
+	}
+
+	switch action {
+	case "generateContent", "streamGenerateContent":
+		var req GeminiRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeGeminiError(w, errInvalidRequest("Invalid JSON payload: "+err.Error(), ""))
+			return
+		}
+		h.handleGeminiGenerate(w, r, model, &req, action == "streamGenerateContent")
+
+	case "countTokens":
+		var req GeminiCountTokensRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeGeminiError(w, errInvalidRequest("Invalid JSON payload: "+err.Error(), ""))
+			return
+		}
+		genReq := &req.GeminiRequest
+		if req.GenerateContentRequest != nil {
+			genReq = req.GenerateContentRequest
+		}
+		messages, system, tools := convertGeminiRequest(genReq)
+		builtPrompt := prompt.BuildPromptV2(prompt.ClaudeAPIRequest{
+			Model:    model,
+			Messages: messages,
+			System:   system,
+			Tools:    tools,
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(GeminiCountTokensResponse{TotalTokens: tiktoken.EstimateTextTokens(builtPrompt)})
+
+	default:
+		writeGeminiError(w, newOpenAIError(http.StatusNotFound, "invalid_request_error", "Unsupported Gemini method: "+action, "", ""))
+	}
+}
+
+// handleGeminiGenerate 处理 generateContent / streamGenerateContent
+func (h *Handler) handleGeminiGenerate(w http.ResponseWriter, r *http.Request, model string, req *GeminiRequest, stream bool) {
+	startTime := time.Now()
+
+	n := 1
+	limits := generationLimits{}
+	if cfg := req.GenerationConfig; cfg != nil {
+		if cfg.CandidateCount != 0 {
+			n = cfg.CandidateCount
+		}
+		limits.maxTokens = cfg.MaxOutputTokens
+		limits.stop = cfg.StopSequences
+	}
+	if n < 1 || n > maxChoices {
+		writeGeminiError(w, errInvalidRequest(fmt.Sprintf("candidateCount must be between 1 and %d", maxChoices), "candidateCount"))
+		return
+	}
+	if limits.maxTokens < 0 {
+		writeGeminiError(w, errInvalidRequest("maxOutputTokens must be positive", "maxOutputTokens"))
+		return
+	}
+
+	messages, system, tools := convertGeminiRequest(req)
+	if len(messages) == 0 {
+		writeGeminiError(w, errInvalidRequest("contents is not specified", "contents"))
+		return
+	}
+
+	// 初始化调试日志
+	logger := debug.New(h.config.DebugEnabled)
+	defer logger.Close()
+	logger.LogIncomingRequest(req)
+
+	target, err := h.resolveModel(model)
+	if err != nil {
+		writeGeminiError(w, err)
+		return
+	}
+
+	set, err := h.newChoiceSet(n, h.modelChain(target), h.newAccountSelector(w, r, model, sessionKey(r, "", system, messages)), logger)
+	if err != nil {
+		writeGeminiError(w, err)
+		return
+	}
+	defer set.release()
+
+	builtPrompt := prompt.BuildPromptV2(prompt.ClaudeAPIRequest{
+		Model:    model,
+		Messages: messages,
+		System:   system,
+		Tools:    tools,
+		Stream:   stream,
+	})
+	logger.LogConvertedPrompt(builtPrompt)
+
+	inputTokens := tiktoken.EstimateTextTokens(builtPrompt)
+	usage := func() *GeminiUsageMetadata {
+		output := set.outputTokens()
+		return &GeminiUsageMetadata{
+			PromptTokenCount:     inputTokens,
+			CandidatesTokenCount: output,
+			TotalTokenCount:      inputTokens + output,
+		}
+	}
+
+	if stream {
+		// alt=sse 时使用 SSE，否则按 Gemini 默认行为输出 JSON 数组
+		sse := r.URL.Query().Get("alt") == "sse"
+		if sse {
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Header().Set("Cache-Control", "no-cache")
+			w.Header().Set("Connection", "keep-alive")
+		} else {
+			w.Header().Set("Content-Type", "application/json")
+		}
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			writeGeminiError(w, newOpenAIError(http.StatusInternalServerError, "server_error", "Streaming not supported", "", ""))
+			return
+		}
+
+		var mu sync.Mutex
+		var hasReturn bool
+		var written int
+		var finished int
+
+		writeRaw := func(data string) {
+			if written == 0 {
+				w.Header().Set(servedModelHeader, set.servedModel(model))
+			}
+			if sse {
+				fmt.Fprintf(w, "data: %s\n\n", data)
+			} else if written == 0 {
+				fmt.Fprintf(w, "[%s", data)
+			} else {
+				fmt.Fprintf(w, ",\r\n%s", data)
+			}
+			written++
+			flusher.Flush()
+		}
+
+		writeChunk := func(resp GeminiResponse) {
+			mu.Lock()
+			defer mu.Unlock()
+			if hasReturn {
+				return
+			}
+			resp.ModelVersion = set.servedModel(model)
+			data, _ := json.Marshal(resp)
+			writeRaw(string(data))
+		}
+
+		log.Printf("新请求进入 (Gemini格式, n=%d)", n)
+
+		err := set.run(r.Context(), builtPrompt, limits, true, func(c *chatChoice, delta string) {
+			writeChunk(GeminiResponse{Candidates: []GeminiCandidate{{
+				Content: GeminiContent{Role: "model", Parts: []GeminiPart{{Text: delta}}},
+				Index:   c.index,
+			}}})
+		}, func(c *chatChoice) {
+			candidate := geminiCandidate(c, false)
+			resp := GeminiResponse{Candidates: []GeminiCandidate{candidate}}
+
+			// 最后一个完成的 candidate 携带整体用量
+			mu.Lock()
+			finished++
+			last := finished == n
+			mu.Unlock()
+			if last {
+				resp.UsageMetadata = usage()
+			}
+			writeChunk(resp)
+		})
+
+		if r.Context().Err() != nil {
+			return
+		}
+
+		mu.Lock()
+		hasReturn = true
+		if err != nil && written == 0 {
+			// 尚未输出任何内容时仍可返回普通错误响应
+			mu.Unlock()
+			writeGeminiError(w, err)
+			return
+		}
+		if err != nil {
+			data, _ := json.Marshal(geminiErrorBody(err))
+			writeRaw(string(data))
+		}
+		if !sse {
+			if written == 0 {
+				fmt.Fprint(w, "[")
+			}
+			fmt.Fprint(w, "]")
+			flusher.Flush()
+		}
+		mu.Unlock()
+
+	} else {
+		log.Printf("新请求进入 (Gemini格式，非流式, n=%d)", n)
+
+		err := set.run(r.Context(), builtPrompt, limits, false, func(c *chatChoice, delta string) {}, func(c *chatChoice) {})
+		if err != nil {
+			if r.Context().Err() == nil {
+				writeGeminiError(w, err)
+			}
+			return
+		}
+
+		response := GeminiResponse{
+			Candidates:    make([]GeminiCandidate, 0, n),
+			UsageMetadata: usage(),
+			ModelVersion:  set.servedModel(model),
+		}
+		for _, c := range set.choices {
+			response.Candidates = append(response.Candidates, geminiCandidate(c, true))
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set(servedModelHeader, response.ModelVersion)
+		json.NewEncoder(w).Encode(response)
+	}
+
+	u := usage()
+	logger.LogSummary(u.PromptTokenCount, u.CandidatesTokenCount, time.Since(startTime), set.choices[0].finishReason)
+	log.Printf("请求完成: 输入=%d tokens, 输出=%d tokens, 耗时=%v", u.PromptTokenCount, u.CandidatesTokenCount, time.Since(startTime))
+}
+
+// geminiCandidate 构建 candidate；withText 为 false 时只包含工具调用（流式已逐块输出文本）
+func geminiCandidate(c *chatChoice, withText bool) GeminiCandidate {
+	parts := []GeminiPart{}
+	if withText && c.content.Len() > 0 {
+		parts = append(parts, GeminiPart{Text: c.content.String()})
+	}
+	for _, call := range c.toolCalls {
+		var args map[string]interface{}
+		json.Unmarshal([]byte(call.Input), &args)
+		parts = append(parts, GeminiPart{FunctionCall: &GeminiFunctionCall{Name: call.Name, Args: args}})
+	}
+
+	finishReason := "STOP"
+	if c.finishReason == "length" {
+		finishReason = "MAX_TOKENS"
+	}
+
+	return GeminiCandidate{
+		Content:      GeminiContent{Role: "model", Parts: parts},
+		FinishReason: finishReason,
+		Index:        c.index,
+	}
+}
+
+// convertGeminiRequest 将 Gemini 请求转换为 Claude messages、system 和 tools
+func convertGeminiRequest(req *GeminiRequest) ([]prompt.Message, []prompt.SystemItem, []interface{}) {
+	var system []prompt.SystemItem
+	if req.SystemInstruction != nil {
+		var texts []string
+		for _, part := range req.SystemInstruction.Parts {
+			if part.Text != "" {
+				texts = append(texts, part.Text)
+			}
+		}
+		if len(texts) > 0 {
+			system = []prompt.SystemItem{{Type: "text", Text: strings.Join(texts, "\n")}}
+		}
+	}
+
+	var tools []interface{}
+	for _, tool := range req.Tools {
+		for _, decl := range tool.FunctionDeclarations {
+			tools = append(tools, map[string]interface{}{
+				"name":         decl.Name,
+				"description":  decl.Description,
+				"input_schema": decl.Parameters,
+			})
+		}
+	}
+
+	// functionResponse 只带函数名，按名称匹配最近一次 functionCall 的 ID
+	callIDs := make(map[string]string)
+	callCount := 0
+
+	messages := make([]prompt.Message, 0, len(req.Contents))
+	for _, content := range req.Contents {
+		role := "user"
+		if content.Role == "model" {
+			role = "assistant"
+		}
+
+		var blocks []prompt.ContentBlock
+		for _, part := range content.Parts {
+			switch {
+			case part.Text != "":
+				blocks = append(blocks, prompt.ContentBlock{Type: "text", Text: part.Text})
+
+			case part.InlineData != nil:
+				if strings.HasPrefix(part.InlineData.MimeType, "image/") {
+					blocks = append(blocks, prompt.ContentBlock{
+						Type: "image",
+						Source: &prompt.ImageSource{
+							Type:      "base64",
+							MediaType: part.InlineData.MimeType,
+							Data:      part.InlineData.Data,
+						},
+					})
+				} else {
+					log.Printf("警告: 不支持的 inlineData 类型 %s", part.InlineData.MimeType)
+				}
+
+			case part.FunctionCall != nil:
+				callCount++
+				id := fmt.Sprintf("call_%d_%s", callCount, part.FunctionCall.Name)
+				callIDs[part.FunctionCall.Name] = id
+				blocks = append(blocks, prompt.ContentBlock{
+					Type:  "tool_use",
+					ID:    id,
+					Name:  part.FunctionCall.Name,
+					Input: part.FunctionCall.Args,
+				})
+
+			case part.FunctionResponse != nil:
+				id := callIDs[part.FunctionResponse.Name]
+				if id == "" {
+					id = part.FunctionResponse.Name
+				}
+				result, _ := json.Marshal(part.FunctionResponse.Response)
+				blocks = append(blocks, prompt.ContentBlock{
+					Type:      "tool_result",
+					ToolUseID: id,
+					Content:   string(result),
+				})
+			}
+		}
+
+		if len(blocks) == 0 {
+			continue
+		}
+		msg := prompt.Message{Role: role}
+		msg.Content.Blocks = blocks
+		messages = append(messages, msg)
+	}
+
+	return messages, system, tools
+}
+
+// geminiErrorBody 将内部错误映射为 Gemini 错误
+func geminiErrorBody(err error) GeminiErrorResponse {
+	oe := toOpenAIError(err)
+	status := "INTERNAL"
+	switch oe.Status {
+	case http.StatusBadRequest:
+		status = "INVALID_ARGUMENT"
+	case http.StatusUnauthorized:
+		status = "UNAUTHENTICATED"
+	case http.StatusForbidden:
+		status = "PERMISSION_DENIED"
+	case http.StatusNotFound:
+		status = "NOT_FOUND"
+	case http.StatusMethodNotAllowed:
+		status = "UNIMPLEMENTED"
+	case http.StatusTooManyRequests:
+		status = "RESOURCE_EXHAUSTED"
+	case http.StatusServiceUnavailable:
+		status = "UNAVAILABLE"
+	}
+	return GeminiErrorResponse{Error: GeminiErrorDetail{Code: oe.Status, Message: oe.Detail.Message, Status: status}}
+}
+
+// writeGeminiError 写出 Gemini 格式的错误响应
+func writeGeminiError(w http.ResponseWriter, err error) {
+	body := geminiErrorBody(err)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(body.Error.Code)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,206 @@
+package handler
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"orchids-api/internal/fakeupstream"
+	"orchids-api/internal/prompt"
+	"orchids-api/internal/store"
+)
+
+func message(role string, blocks ...prompt.ContentBlock) prompt.Message {
+	msg := prompt.Message{Role: role}
+	msg.Content.Blocks = blocks
+	return msg
+}
+
+func TestConvertGeminiRequest(t *testing.T) {
+	text := func(s string) prompt.ContentBlock { return prompt.ContentBlock{Type: "text", Text: s} }
+
+	tests := []struct {
+		name         string
+		body         string
+		wantMessages []prompt.Message
+		wantSystem   []prompt.SystemItem
+		wantTools    []interface{}
+	}{
+		{
+			name: "contents 与 parts",
+			body: `{"contents":[
+				{"role":"user","parts":[{"text":"hi"},{"text":"there"}]},
+				{"role":"model","parts":[{"text":"hello"}]},
+				{"parts":[{"text":"no role"}]},
+				{"role":"user","parts":[{}]}
+			]}`,
+			wantMessages: []prompt.Message{
+				message("user", text("hi"), text("there")),
+				message("assistant", text("hello")),
+				message("user", text("no role")),
+			},
+		},
+		{
+			name: "systemInstruction 多段合并",
+			body: `{"systemInstruction":{"parts":[{"text":"be brief"},{"text":""},{"text":"be kind"}]},
+				"contents":[{"role":"user","parts":[{"text":"hi"}]}]}`,
+			wantMessages: []prompt.Message{message("user", text("hi"))},
+			wantSystem:   []prompt.SystemItem{{Type: "text", Text: "be brief\nbe kind"}},
+		},
+		{
+			name: "functionDeclarations 转为 tools",
+			body: `{"tools":[{"functionDeclarations":[
+				{"name":"get_weather","description":"Get weather","parameters":{"type":"object"}},
+				{"name":"noop"}
+			]}],"contents":[{"role":"user","parts":[{"text":"weather?"}]}]}`,
+			wantMessages: []prompt.Message{message("user", text("weather?"))},
+			wantTools: []interface{}{
+				map[string]interface{}{"name": "get_weather", "description": "Get weather", "input_schema": map[string]interface{}{"type": "object"}},
+				map[string]interface{}{"name": "noop", "description": "", "input_schema": nil},
+			},
+		},
+		{
+			name: "inlineData 只接受图片",
+			body: `{"contents":[{"role":"user","parts":[
+				{"text":"what is this"},
+				{"inlineData":{"mimeType":"image/png","data":"aGVsbG8="}},
+				{"inlineData":{"mimeType":"application/pdf","data":"JVBERi0="}}
+			]}]}`,
+			wantMessages: []prompt.Message{message("user",
+				text("what is this"),
+				prompt.ContentBlock{Type: "image", Source: &prompt.ImageSource{Type: "base64", MediaType: "image/png", Data: "aGVsbG8="}},
+			)},
+		},
+		{
+			name: "functionResponse 按名称匹配最近的 functionCall",
+			body: `{"contents":[
+				{"role":"user","parts":[{"text":"weather?"}]},
+				{"role":"model","parts":[{"functionCall":{"name":"get_weather","args":{"city":"Paris"}}}]},
+				{"role":"user","parts":[
+					{"functionResponse":{"name":"get_weather","response":{"temp":20}}},
+					{"functionResponse":{"name":"unknown","response":"ok"}}
+				]}
+			]}`,
+			wantMessages: []prompt.Message{
+				message("user", text("weather?")),
+				message("assistant", prompt.ContentBlock{Type: "tool_use", ID: "call_1_get_weather", Name: "get_weather", Input: map[string]interface{}{"city": "Paris"}}),
+				message("user",
+					prompt.ContentBlock{Type: "tool_result", ToolUseID: "call_1_get_weather", Content: `{"temp":20}`},
+					prompt.ContentBlock{Type: "tool_result", ToolUseID: "unknown", Content: `"ok"`},
+				),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req GeminiRequest
+			if err := json.Unmarshal([]byte(tt.body), &req); err != nil {
+				t.Fatal(err)
+			}
+			messages, system, tools := convertGeminiRequest(&req)
+			if !reflect.DeepEqual(messages, tt.wantMessages) {
+				t.Errorf("messages = %+v, want %+v", messages, tt.wantMessages)
+			}
+			if !reflect.DeepEqual(system, tt.wantSystem) {
+				t.Errorf("system = %+v, want %+v", system, tt.wantSystem)
+			}
+			if !reflect.DeepEqual(tools, tt.wantTools) {
+				t.Errorf("tools = %+v, want %+v", tools, tt.wantTools)
+			}
+		})
+	}
+}
+
+func TestGeminiResponseFormats(t *testing.T) {
+	const body = `{"contents":[{"role":"user","parts":[{"text":"hi"}]}]}`
+
+	tests := []struct {
+		name        string
+		path        string
+		contentType string
+		// parse 从响应体中取出各个 GeminiResponse
+		parse func(t *testing.T, body string) []GeminiResponse
+	}{
+		{
+			name:        "非流式返回单个对象",
+			path:        "/v1beta/models/claude-opus-4-5:generateContent",
+			contentType: "application/json",
+			parse: func(t *testing.T, body string) []GeminiResponse {
+				var resp GeminiResponse
+				if err := json.Unmarshal([]byte(body), &resp); err != nil {
+					t.Fatalf("invalid object %s: %v", body, err)
+				}
+				return []GeminiResponse{resp}
+			},
+		},
+		{
+			name:        "alt=sse 使用 SSE",
+			path:        "/v1beta/models/claude-opus-4-5:streamGenerateContent?alt=sse",
+			contentType: "text/event-stream",
+			parse: func(t *testing.T, body string) []GeminiResponse {
+				var list []GeminiResponse
+				scanner := bufio.NewScanner(strings.NewReader(body))
+				for scanner.Scan() {
+					line := scanner.Text()
+					if line == "" {
+						continue
+					}
+					data, ok := strings.CutPrefix(line, "data: ")
+					if !ok {
+						t.Fatalf("unexpected line %q", line)
+					}
+					var resp GeminiResponse
+					if err := json.Unmarshal([]byte(data), &resp); err != nil {
+						t.Fatalf("invalid event %s: %v", data, err)
+					}
+					list = append(list, resp)
+				}
+				return list
+			},
+		},
+		{
+			name:        "未指定 alt 时返回 JSON 数组",
+			path:        "/v1beta/models/claude-opus-4-5:streamGenerateContent",
+			contentType: "application/json",
+			parse: func(t *testing.T, body string) []GeminiResponse {
+				var list []GeminiResponse
+				if err := json.Unmarshal([]byte(body), &list); err != nil {
+					t.Fatalf("invalid array %s: %v", body, err)
+				}
+				return list
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newTestGateway(t, &store.Account{Name: "a"})
+			rec := g.serve(g.h.HandleGemini, http.MethodPost, tt.path, body)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+			}
+			if got := rec.Header().Get("Content-Type"); got != tt.contentType {
+				t.Errorf("Content-Type = %q, want %q", got, tt.contentType)
+			}
+
+			var text strings.Builder
+			list := tt.parse(t, rec.Body.String())
+			for _, resp := range list {
+				for _, c := range resp.Candidates {
+					for _, part := range c.Content.Parts {
+						text.WriteString(part.Text)
+					}
+				}
+			}
+			if text.String() != fakeupstream.DefaultText {
+				t.Errorf("text = %q, want %q", text.String(), fakeupstream.DefaultText)
+			}
+			last := list[len(list)-1]
+			if last.UsageMetadata == nil || last.Candidates[0].FinishReason != "STOP" || last.ModelVersion != "claude-opus-4-5" {
+				t.Errorf("last response = %+v, want usage and STOP", last)
+			}
+		})
+	}
+}
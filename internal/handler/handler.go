@@ -11,19 +11,27 @@ import (
 	"sync"
 	"time"
 
+	"orchids-api/internal/accountroute"
 	"orchids-api/internal/client"
 	"orchids-api/internal/config"
 	"orchids-api/internal/debug"
 	"orchids-api/internal/loadbalancer"
+	"orchids-api/internal/mediacache"
+	"orchids-api/internal/modelroute"
 	"orchids-api/internal/prompt"
 	"orchids-api/internal/store"
 	"orchids-api/internal/tiktoken"
+	"orchids-api/internal/videojob"
 )
 
 type Handler struct {
-	config       *config.Config
-	client       *client.Client
-	loadBalancer *loadbalancer.LoadBalancer
+	config        *config.Config
+	client        *client.Client
+	loadBalancer  *loadbalancer.LoadBalancer
+	router        *modelroute.Router
+	accountRouter *accountroute.Router
+	videoJobs     *videojob.Manager
+	media         *mediacache.Cache
 }
 
 type ClaudeRequest struct {
@@ -32,12 +40,19 @@ type ClaudeRequest struct {
 	System   []prompt.SystemItem `json:"system"`
 	Tools    []interface{}       `json:"tools"`
 	Stream   bool                `json:"stream"`
+	Metadata ClaudeMetadata      `json:"metadata"`
+}
+
+// ClaudeMetadata 请求元数据，user_id 用于会话粘滞
+type ClaudeMetadata struct {
+	UserID string `json:"user_id,omitempty"`
 }
 
 func New(cfg *config.Config) *Handler {
 	return &Handler{
 		config: cfg,
 		client: client.New(cfg),
+		router: defaultRouter(),
 	}
 }
 
@@ -46,19 +61,90 @@ func NewWithLoadBalancer(cfg *config.Config, lb *loadbalancer.LoadBalancer) *Han
 		config:       cfg,
 		client:       client.New(cfg),
 		loadBalancer: lb,
+		router:       defaultRouter(),
+	}
+}
+
+// SetModelRouter 设置模型路由表，未设置时使用内置默认路由
+func (h *Handler) SetModelRouter(r *modelroute.Router) {
+	h.router = r
+}
+
+// resolveModel 按路由表将请求的 model 映射到上游模型
+func (h *Handler) resolveModel(model string) (modelroute.Target, error) {
+	target, err := h.router.Resolve(model)
+	if err != nil {
+		return target, newOpenAIError(http.StatusNotFound, "invalid_request_error", fmt.Sprintf("The model '%s' does not exist", model), "model", "model_not_found")
+	}
+	if target.AgentMode != "" {
+		log.Printf("模型映射: %s -> %s (agentMode=%s)", model, target.Model, target.AgentMode)
+	} else {
+		log.Printf("模型映射: %s -> %s", model, target.Model)
+	}
+	return target, nil
+}
+
+// reportAccount 回报账号请求结果，供负载均衡判断熔断
+func (h *Handler) reportAccount(account *store.Account, err error) {
+	if h.loadBalancer != nil {
+		h.loadBalancer.ReportResult(account, err)
+	}
+}
+
+// releaseAccount 结束对账号的占用，与负载均衡的选择一一对应
+func (h *Handler) releaseAccount(account *store.Account) {
+	if h.loadBalancer != nil {
+		h.loadBalancer.Release(account)
 	}
 }
 
-// mapModel 根据请求的 model 名称映射到实际使用的模型
-func mapModel(requestModel string) string {
-	lowerModel := strings.ToLower(requestModel)
-	if strings.Contains(lowerModel, "opus") {
-		return "claude-opus-4.5"
+// observeLatency 记录账号的首个上游事件耗时，供 latency_ewma 策略使用
+func (h *Handler) observeLatency(account *store.Account, d time.Duration) {
+	if h.loadBalancer != nil {
+		h.loadBalancer.ObserveLatency(account, d)
 	}
-	if strings.Contains(lowerModel, "haiku") {
-		return "gemini-3-flash"
+}
+
+// recordTokens 累加账号消耗的 token，计入配额
+func (h *Handler) recordTokens(account *store.Account, tokens int) {
+	if h.loadBalancer != nil {
+		h.loadBalancer.RecordTokens(account, tokens)
 	}
-	return "claude-sonnet-4-5"
+}
+
+// servedModelHeader 响应头，标明实际服务请求的模型（发生降级时与请求的 model 不同）
+const servedModelHeader = "X-Served-Model"
+
+// modelChain 返回请求模型及其降级模型的路由结果，无法路由或重复的降级模型会被跳过
+func (h *Handler) modelChain(target modelroute.Target) []modelroute.Target {
+	chain := []modelroute.Target{target}
+	seen := map[string]bool{strings.ToLower(target.RequestModel): true}
+	for _, name := range target.Fallbacks {
+		if seen[strings.ToLower(name)] {
+			continue
+		}
+		seen[strings.ToLower(name)] = true
+
+		next, err := h.router.Resolve(name)
+		if err != nil {
+			log.Printf("降级模型 %s 无法路由，已跳过", name)
+			continue
+		}
+		chain = append(chain, next)
+	}
+	return chain
+}
+
+// logFallback 记录一次模型降级
+func logFallback(logger *debug.Logger, from, to modelroute.Target, reason error) {
+	log.Printf("模型降级: %s -> %s (原因: %v)", from.RequestModel, to.RequestModel, reason)
+	logger.LogModelFallback(from.RequestModel, to.RequestModel, reason)
+}
+
+// defaultRouter 内置默认路由，与数据库初始路由一致
+func defaultRouter() *modelroute.Router {
+	r, _ := modelroute.New(nil)
+	return r
 }
 
 // fixToolInput 修复工具输入中的类型问题
@@ -142,16 +228,29 @@ func (h *Handler) HandleMessages(w http.ResponseWriter, r *http.Request) {
 	// 1. 记录进入的 Claude 请求
 	logger.LogIncomingRequest(req)
 
-	// 选择账号
+	// 路由模型
+	target, err := h.resolveModel(req.Model)
+	if err != nil {
+		writeAnthropicError(w, http.StatusNotFound, "not_found_error", "model: "+req.Model)
+		return
+	}
+
+	// 选择账号，当前模型的账号全部失败后按降级链切换模型
+	chain := h.modelChain(target)
+	current := 0
+	sel := h.newAccountSelector(w, r, req.Model, sessionKey(r, req.Metadata.UserID, req.System, req.Messages))
 	var apiClient *client.Client
 	var currentAccount *store.Account
 	var failedAccountIDs []int64
 
+	// selectAccount 释放上一个账号并选择新账号
 	selectAccount := func() error {
+		h.releaseAccount(currentAccount)
+		currentAccount = nil
 		if h.loadBalancer != nil {
-			account, err := h.loadBalancer.GetNextAccountExcluding(failedAccountIDs)
+			account, err := sel.acquire(chain[current].Model, failedAccountIDs, true)
 			if err != nil {
-				if h.client != nil {
+				if h.client != nil && sel.canUseDefault(err) {
 					apiClient = h.client
 					currentAccount = nil
 					log.Println("负载均衡无可用账号，使用默认配置")
@@ -159,7 +258,7 @@ func (h *Handler) HandleMessages(w http.ResponseWriter, r *http.Request) {
 				}
 				return err
 			}
-			log.Printf("使用账号: %s (%s)", account.Name, account.Email)
+			log.Printf("使用账号: %s (%s) [优先级 %d]", account.Name, account.Email, account.Priority)
 			apiClient = client.NewFromAccount(account)
 			currentAccount = account
 			return nil
@@ -171,8 +270,32 @@ func (h *Handler) HandleMessages(w http.ResponseWriter, r *http.Request) {
 		return errors.New("no client configured")
 	}
 
-	if err := selectAccount(); err != nil {
-		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	// nextModel 切换到下一个能分配到账号的降级模型，没有更多模型时返回 false
+	nextModel := func(reason error) bool {
+		for current+1 < len(chain) {
+			current++
+			logFallback(logger, chain[current-1], chain[current], reason)
+			failedAccountIDs = nil
+			err := selectAccount()
+			if err == nil {
+				return true
+			}
+			reason = err
+		}
+		return false
+	}
+
+	// servedModel 实际服务请求的模型名称
+	servedModel := func() string {
+		if current == 0 {
+			return req.Model
+		}
+		return chain[current].RequestModel
+	}
+
+	defer func() { h.releaseAccount(currentAccount) }()
+	if err := selectAccount(); err != nil && (r.Context().Err() != nil || !nextModel(err)) {
+		http.Error(w, err.Error(), toOpenAIError(err).Status)
 		return
 	}
 
@@ -188,10 +311,6 @@ func (h *Handler) HandleMessages(w http.ResponseWriter, r *http.Request) {
 	// 2. 记录转换后的 prompt
 	logger.LogConvertedPrompt(builtPrompt)
 
-	// 映射模型
-	mappedModel := mapModel(req.Model)
-	log.Printf("模型映射: %s -> %s", req.Model, mappedModel)
-
 	// 设置 SSE 响应头
 	w.Header().Set("Content-Type", "text/event-stream")
 	w.Header().Set("Cache-Control", "no-cache")
@@ -206,7 +325,7 @@ func (h *Handler) HandleMessages(w http.ResponseWriter, r *http.Request) {
 	// 状态管理
 	msgID := fmt.Sprintf("msg_%d", time.Now().UnixMilli())
 	blockIndex := -1
-	var hasReturn bool
+	var hasReturn, started bool
 	var mu sync.Mutex
 	var finalStopReason string
 	toolBlocks := make(map[string]int)
@@ -226,6 +345,28 @@ func (h *Handler) HandleMessages(w http.ResponseWriter, r *http.Request) {
 		outputMu.Unlock()
 	}
 
+	// startLocked 发送 message_start；推迟到首个事件输出前，以便此前仍可降级模型
+	startLocked := func() {
+		if started {
+			return
+		}
+		started = true
+		w.Header().Set(servedModelHeader, servedModel())
+		startData, _ := json.Marshal(map[string]interface{}{
+			"type": "message_start",
+			"message": map[string]interface{}{
+				"id":      msgID,
+				"type":    "message",
+				"role":    "assistant",
+				"content": []interface{}{},
+				"model":   servedModel(),
+				"usage":   map[string]int{"input_tokens": inputTokens, "output_tokens": 0},
+			},
+		})
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", "message_start", string(startData))
+		logger.LogOutputSSE("message_start", string(startData))
+	}
+
 	// SSE 写入函数
 	writeSSE := func(event, data string) {
 		mu.Lock()
@@ -233,6 +374,7 @@ func (h *Handler) HandleMessages(w http.ResponseWriter, r *http.Request) {
 		if hasReturn {
 			return
 		}
+		startLocked()
 		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
 		flusher.Flush()
 
@@ -248,6 +390,7 @@ func (h *Handler) HandleMessages(w http.ResponseWriter, r *http.Request) {
 		}
 		hasReturn = true
 		finalStopReason = stopReason
+		startLocked()
 		mu.Unlock()
 
 		deltaData, _ := json.Marshal(map[string]interface{}{
@@ -270,22 +413,9 @@ func (h *Handler) HandleMessages(w http.ResponseWriter, r *http.Request) {
 		// 6. 记录摘要
 		logger.LogSummary(inputTokens, outputTokens, time.Since(startTime), stopReason)
 		log.Printf("请求完成: 输入=%d tokens, 输出=%d tokens, 耗时=%v", inputTokens, outputTokens, time.Since(startTime))
+		h.recordTokens(currentAccount, inputTokens+outputTokens)
 	}
 
-	// 发送 message_start
-	startData, _ := json.Marshal(map[string]interface{}{
-		"type": "message_start",
-		"message": map[string]interface{}{
-			"id":      msgID,
-			"type":    "message",
-			"role":    "assistant",
-			"content": []interface{}{},
-			"model":   req.Model,
-			"usage":   map[string]int{"input_tokens": inputTokens, "output_tokens": 0},
-		},
-	})
-	writeSSE("message_start", string(startData))
-
 	log.Println("新请求进入")
 
 	done := make(chan struct{})
@@ -293,7 +423,13 @@ func (h *Handler) HandleMessages(w http.ResponseWriter, r *http.Request) {
 	go func() {
 		defer close(done)
 		for {
-			err := apiClient.SendRequest(r.Context(), builtPrompt, []interface{}{}, mappedModel, func(msg client.SSEMessage) {
+			attemptStart := time.Now()
+			firstEvent := true
+			err := apiClient.WithAgentMode(chain[current].AgentMode).SendRequest(r.Context(), builtPrompt, []interface{}{}, chain[current].Model, func(msg client.SSEMessage) {
+				if firstEvent {
+					firstEvent = false
+					h.observeLatency(currentAccount, time.Since(attemptStart))
+				}
 				mu.Lock()
 				if hasReturn {
 					mu.Unlock()
@@ -460,6 +596,7 @@ func (h *Handler) HandleMessages(w http.ResponseWriter, r *http.Request) {
 					finishResponse(stopReason)
 				}
 			}, logger)
+			h.reportAccount(currentAccount, err)
 
 			if err != nil {
 				log.Printf("Error: %v", err)
@@ -473,6 +610,18 @@ func (h *Handler) HandleMessages(w http.ResponseWriter, r *http.Request) {
 						log.Printf("无更多可用账号: %v", retryErr)
 					}
 				}
+				// 尚未向客户端输出内容时降级到下一个模型
+				mu.Lock()
+				canFallback := !started && r.Context().Err() == nil
+				if canFallback {
+					blockIndex = -1
+					toolBlocks = make(map[string]int)
+				}
+				mu.Unlock()
+				if canFallback && nextModel(err) {
+					log.Printf("使用降级模型 %s 重新发送请求", chain[current].RequestModel)
+					continue
+				}
 				finishResponse("end_turn")
 			}
 			break
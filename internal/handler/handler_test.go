@@ -0,0 +1,71 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"orchids-api/internal/store"
+)
+
+func TestModelChain(t *testing.T) {
+	g := newTestGateway(t)
+	g.setRoutes(t,
+		store.ModelRoute{MatchType: store.MatchExact, Pattern: "primary", UpstreamModel: "up-primary", Fallbacks: []string{"third", "PRIMARY", "missing", "second", "Third"}, Priority: 10, Enabled: true},
+		store.ModelRoute{MatchType: store.MatchExact, Pattern: "second", UpstreamModel: "up-second", Priority: 10, Enabled: true},
+		store.ModelRoute{MatchType: store.MatchPrefix, Pattern: "third", UpstreamModel: "up-third", Fallbacks: []string{"second"}, Priority: 10, Enabled: true},
+	)
+
+	target, err := g.h.resolveModel("primary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 按配置顺序展开，跳过请求模型自身、重复（不区分大小写）与无法路由的降级模型；
+	// 只展开一层，降级模型自己的降级配置不参与
+	var got [][2]string
+	for _, next := range g.h.modelChain(target) {
+		got = append(got, [2]string{next.RequestModel, next.Model})
+	}
+	want := [][2]string{{"primary", "up-primary"}, {"third", "up-third"}, {"second", "up-second"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("chain = %v, want %v", got, want)
+	}
+
+	target, _ = g.h.resolveModel("second")
+	if chain := g.h.modelChain(target); len(chain) != 1 {
+		t.Errorf("chain without fallbacks = %+v, want only the requested model", chain)
+	}
+}
+
+func TestMessagesModelNotFound(t *testing.T) {
+	g := newTestGateway(t, &store.Account{Name: "a"})
+	g.setRoutes(t, store.ModelRoute{MatchType: store.MatchExact, Pattern: "claude-opus-4-5", UpstreamModel: "claude-opus-4.5", Priority: 10, Enabled: true})
+
+	rec := g.serve(g.h.HandleMessages, http.MethodPost, "/v1/messages",
+		`{"model":"no-such-model","max_tokens":16,"messages":[{"role":"user","content":"hi"}]}`)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("status = %d, want 404: %s", rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", got)
+	}
+
+	// Claude 格式的错误，客户端据 error.type 判断
+	var body struct {
+		Type  string `json:"type"`
+		Error struct {
+			Type    string `json:"type"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("invalid body %s: %v", rec.Body.String(), err)
+	}
+	if body.Type != "error" || body.Error.Type != "not_found_error" || body.Error.Message != "model: no-such-model" {
+		t.Errorf("body = %s", rec.Body.String())
+	}
+	if got := len(g.fake.Requests()); got != 0 {
+		t.Errorf("got %d upstream requests, want 0", got)
+	}
+}
@@ -0,0 +1,54 @@
+package handler
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+
+	"orchids-api/internal/mediacache"
+)
+
+// mediaPathPrefix 本地媒体缓存的访问路径
+const mediaPathPrefix = "/v1/media/"
+
+// SetMediaCache 设置本地媒体缓存，生成的图片会下载到本地并以网关 URL 返回
+func (h *Handler) SetMediaCache(c *mediacache.Cache) {
+	h.media = c
+}
+
+// cacheMedia 下载上游媒体，返回对外 URL 与内容。
+// 未配置缓存时 URL 仍为上游地址，needData 为 false 时不下载。
+func (h *Handler) cacheMedia(ctx context.Context, r *http.Request, upstreamURL string, needData bool) (string, []byte, error) {
+	if h.media == nil {
+		if !needData {
+			return upstreamURL, nil, nil
+		}
+		data, _, err := mediacache.Download(ctx, upstreamURL)
+		return upstreamURL, data, err
+	}
+
+	key, data, err := h.media.Fetch(ctx, upstreamURL)
+	if err != nil {
+		if needData {
+			return "", nil, err
+		}
+		// 只需要 URL 时退回上游地址
+		log.Printf("媒体缓存失败，返回上游地址: %v", err)
+		return upstreamURL, nil, nil
+	}
+	return h.publicBaseURL(r) + mediaPathPrefix + key, data, nil
+}
+
+// publicBaseURL 网关对外地址，未配置 PUBLIC_BASE_URL 时按请求的 Host 推断。
+// 不信任 X-Forwarded-* 头，部署在反向代理后时需配置 PUBLIC_BASE_URL
+func (h *Handler) publicBaseURL(r *http.Request) string {
+	if h.config != nil && h.config.PublicBaseURL != "" {
+		return strings.TrimRight(h.config.PublicBaseURL, "/")
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
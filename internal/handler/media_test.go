@@ -0,0 +1,200 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"orchids-api/internal/config"
+	"orchids-api/internal/mediacache"
+	"orchids-api/internal/store"
+)
+
+// pngMagic PNG 文件头，fakeupstream 的媒体请求返回 1x1 PNG
+var pngMagic = []byte("\x89PNG\r\n\x1a\n")
+
+func TestPublicBaseURL(t *testing.T) {
+	tests := []struct {
+		name   string
+		public string
+		tls    bool
+		want   string
+	}{
+		{"按 Host 推断", "", false, "http://gateway.local:3002"},
+		{"TLS 连接", "", true, "https://gateway.local:3002"},
+		{"配置 PUBLIC_BASE_URL", "https://gw.example.com/", false, "https://gw.example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &Handler{config: &config.Config{PublicBaseURL: tt.public}}
+			r := httptest.NewRequest(http.MethodPost, "/v1/images/generations", nil)
+			r.Host = "gateway.local:3002"
+			if tt.tls {
+				r.TLS = &tls.ConnectionState{}
+			}
+			// 不信任客户端可伪造的 X-Forwarded-* 头
+			r.Header.Set("X-Forwarded-Proto", "https")
+			r.Header.Set("X-Forwarded-Host", "evil.example.com")
+			if got := h.publicBaseURL(r); got != tt.want {
+				t.Errorf("publicBaseURL() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// generateImages 以 body 请求 /v1/images/generations，返回图片列表
+func generateImages(t *testing.T, g *testGateway, body string) []OpenAIImageData {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodPost, "/v1/images/generations", strings.NewReader(body))
+	r.Host = "gateway.local"
+	r.Header.Set("X-Forwarded-Host", "evil.example.com")
+	rec := httptest.NewRecorder()
+	g.h.HandleOpenAIImages(rec, r)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp OpenAIImageResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp.Data
+}
+
+func TestImagesResponseFormat(t *testing.T) {
+	tests := []struct {
+		name   string
+		cache  bool
+		public string
+		body   string
+		// wantPrefix 为 url 格式的地址前缀，为空时返回 b64_json
+		wantPrefix string
+		wantN      int
+	}{
+		{
+			name:       "未配置缓存时返回上游地址",
+			body:       `{"prompt":"a cat"}`,
+			wantPrefix: "http://127.0.0.1",
+			wantN:      1,
+		},
+		{
+			name:       "缓存后按 Host 构建网关地址",
+			cache:      true,
+			body:       `{"prompt":"a cat","n":2}`,
+			wantPrefix: "http://gateway.local/v1/media/",
+			wantN:      2,
+		},
+		{
+			name:       "缓存后使用 PUBLIC_BASE_URL",
+			cache:      true,
+			public:     "https://gw.example.com",
+			body:       `{"prompt":"a cat"}`,
+			wantPrefix: "https://gw.example.com/v1/media/",
+			wantN:      1,
+		},
+		{
+			name:  "b64_json 未配置缓存",
+			body:  `{"prompt":"a cat","n":2,"response_format":"b64_json"}`,
+			wantN: 2,
+		},
+		{
+			name:  "b64_json 配置缓存",
+			cache: true,
+			body:  `{"prompt":"a cat","response_format":"b64_json"}`,
+			wantN: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newTestGateway(t, &store.Account{Name: "a"})
+			g.h.config.PublicBaseURL = tt.public
+			if tt.cache {
+				cache, err := mediacache.New(t.TempDir(), time.Hour)
+				if err != nil {
+					t.Fatal(err)
+				}
+				g.h.SetMediaCache(cache)
+			}
+
+			data := generateImages(t, g, tt.body)
+			if len(data) != tt.wantN {
+				t.Fatalf("got %d images, want %d", len(data), tt.wantN)
+			}
+			if got := len(g.fake.Requests()); got != tt.wantN {
+				t.Errorf("got %d upstream requests, want %d", got, tt.wantN)
+			}
+			for i, img := range data {
+				if tt.wantPrefix != "" {
+					if img.B64JSON != "" || !strings.HasPrefix(img.URL, tt.wantPrefix) {
+						t.Errorf("image %d = %+v, want url with prefix %q", i, img, tt.wantPrefix)
+					}
+					continue
+				}
+				raw, err := base64.StdEncoding.DecodeString(img.B64JSON)
+				if err != nil || img.URL != "" || !bytes.HasPrefix(raw, pngMagic) {
+					t.Errorf("image %d = %+v (%v), want base64 png", i, img, err)
+				}
+			}
+		})
+	}
+}
+
+func TestMediaCacheLookup(t *testing.T) {
+	g := newTestGateway(t, &store.Account{Name: "a"})
+	dir := t.TempDir()
+	cache, err := mediacache.New(dir, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.h.SetMediaCache(cache)
+	media := http.StripPrefix("/v1/media", cache)
+
+	data := generateImages(t, g, `{"prompt":"a cat"}`)
+	path := strings.TrimPrefix(data[0].URL, "http://gateway.local")
+	key := strings.TrimPrefix(path, mediaPathPrefix)
+	if !strings.HasSuffix(key, ".png") {
+		t.Fatalf("url = %q, want cached png", data[0].URL)
+	}
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		media.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	rec := get(path)
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "image/png" || !bytes.HasPrefix(rec.Body.Bytes(), pngMagic) {
+		t.Fatalf("GET %s = %d %q", path, rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if rec.Header().Get("Expires") == "" {
+		t.Error("missing Expires header")
+	}
+
+	// 相同内容只保存一份
+	again := generateImages(t, g, `{"prompt":"a cat"}`)
+	if again[0].URL != data[0].URL {
+		t.Errorf("second url = %q, want %q", again[0].URL, data[0].URL)
+	}
+
+	for _, bad := range []string{mediaPathPrefix + "missing.png", mediaPathPrefix + "../test.db"} {
+		if rec := get(bad); rec.Code != http.StatusNotFound {
+			t.Errorf("GET %s = %d, want 404", bad, rec.Code)
+		}
+	}
+
+	// 超过 TTL 的文件不再提供
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, key), old, old); err != nil {
+		t.Fatal(err)
+	}
+	if rec := get(path); rec.Code != http.StatusNotFound {
+		t.Errorf("expired GET %s = %d, want 404", path, rec.Code)
+	}
+}
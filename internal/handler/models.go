@@ -0,0 +1,301 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"orchids-api/internal/store"
+)
+
+// serverStartedAt 内置默认路由没有创建时间，使用进程启动时间代替
+var serverStartedAt = time.Now()
+
+// modelInfo 对外公开的模型
+type modelInfo struct {
+	ID            string
+	DisplayName   string
+	OwnedBy       string
+	UpstreamModel string
+	CreatedAt     time.Time
+}
+
+// listModels 根据路由表生成对外模型列表：exact 规则的 pattern 及所有规则的别名。
+// prefix/regex 规则没有具体名称，不会列出；只保留至少一个启用账号能服务的模型。
+func (h *Handler) listModels() []modelInfo {
+	accounts := h.servingAccounts()
+
+	var models []modelInfo
+	seen := make(map[string]bool)
+	for _, route := range h.router.Routes() {
+		var names []string
+		if route.MatchType == store.MatchExact {
+			names = append(names, route.Pattern)
+		}
+		names = append(names, route.Aliases...)
+
+		for _, name := range names {
+			key := strings.ToLower(name)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			// 名称可能被更高优先级的规则截获，以实际命中的规则为准
+			if m, ok := h.modelFor(name, accounts); ok {
+				models = append(models, m)
+			}
+		}
+	}
+	return models
+}
+
+// lookupModel 查找单个模型，任何能被路由且有账号服务的名称都视为存在
+func (h *Handler) lookupModel(id string) (modelInfo, bool) {
+	if id == "" {
+		return modelInfo{}, false
+	}
+	return h.modelFor(id, h.servingAccounts())
+}
+
+func (h *Handler) modelFor(id string, accounts []*store.Account) (modelInfo, bool) {
+	route, ok := h.router.Match(id)
+	if !ok {
+		return modelInfo{}, false
+	}
+	if accounts != nil && !anySupports(accounts, route.UpstreamModel) {
+		return modelInfo{}, false
+	}
+
+	createdAt := route.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = serverStartedAt
+	}
+	return modelInfo{
+		ID:            id,
+		DisplayName:   modelDisplayName(id),
+		OwnedBy:       modelOwner(route.UpstreamModel),
+		UpstreamModel: route.UpstreamModel,
+		CreatedAt:     createdAt,
+	}, true
+}
+
+// servingAccounts 返回启用的账号；未使用负载均衡时返回 nil，表示由默认配置服务全部模型
+func (h *Handler) servingAccounts() []*store.Account {
+	if h.loadBalancer == nil {
+		return nil
+	}
+	accounts, err := h.loadBalancer.EnabledAccounts()
+	if err != nil || accounts == nil {
+		return []*store.Account{}
+	}
+	return accounts
+}
+
+func anySupports(accounts []*store.Account, model string) bool {
+	for _, acc := range accounts {
+		if acc.Supports(model) {
+			return true
+		}
+	}
+	return false
+}
+
+// modelOwner 根据上游模型推断提供方
+func modelOwner(upstream string) string {
+	lower := strings.ToLower(upstream)
+	switch {
+	case strings.HasPrefix(lower, "claude"):
+		return "anthropic"
+	case strings.HasPrefix(lower, "gemini"), strings.HasPrefix(lower, "veo"):
+		return "google"
+	case strings.HasPrefix(lower, "gpt"), strings.HasPrefix(lower, "sora"),
+		strings.HasPrefix(lower, "o1"), strings.HasPrefix(lower, "o3"), strings.HasPrefix(lower, "o4"):
+		return "openai"
+	}
+	return "orchids"
+}
+
+// modelDisplayName 生成可读名称，如 claude-opus-4-5-thinking -> Claude Opus 4.5 Thinking
+func modelDisplayName(id string) string {
+	var words []string
+	var version []string
+	flush := func() {
+		if len(version) > 0 {
+			words = append(words, strings.Join(version, "."))
+			version = nil
+		}
+	}
+	for _, part := range strings.FieldsFunc(id, func(r rune) bool { return r == '-' || r == '_' }) {
+		if _, err := strconv.Atoi(part); err == nil && len(part) <= 2 {
+			version = append(version, part)
+			continue
+		}
+		flush()
+		words = append(words, strings.ToUpper(part[:1])+part[1:])
+	}
+	flush()
+	return strings.Join(words, " ")
+}
+
+// isAnthropicRequest Anthropic SDK 总会带 anthropic-version 头
+func isAnthropicRequest(r *http.Request) bool {
+	return r.Header.Get("anthropic-version") != ""
+}
+
+// AnthropicModel Anthropic 模型对象
+type AnthropicModel struct {
+	Type        string `json:"type"`
+	ID          string `json:"id"`
+	DisplayName string `json:"display_name"`
+	CreatedAt   string `json:"created_at"`
+}
+
+func toAnthropicModel(m modelInfo) AnthropicModel {
+	return AnthropicModel{
+		Type:        "model",
+		ID:          m.ID,
+		DisplayName: m.DisplayName,
+		CreatedAt:   m.CreatedAt.UTC().Format(time.RFC3339),
+	}
+}
+
+func toOpenAIModel(m modelInfo) map[string]interface{} {
+	return map[string]interface{}{
+		"id":       m.ID,
+		"object":   "model",
+		"created":  m.CreatedAt.Unix(),
+		"owned_by": m.OwnedBy,
+	}
+}
+
+// HandleOpenAIModels 处理 /v1/models 请求，带 anthropic-version 头时返回 Anthropic 格式
+func (h *Handler) HandleOpenAIModels(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeOpenAIError(w, errMethodNotAllowed())
+		return
+	}
+
+	models := h.listModels()
+	if isAnthropicRequest(r) {
+		h.writeAnthropicModels(w, r, models)
+		return
+	}
+
+	data := make([]map[string]interface{}, 0, len(models))
+	for _, m := range models {
+		data = append(data, toOpenAIModel(m))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"object": "list",
+		"data":   data,
+	})
+}
+
+// HandleOpenAIModel 处理 /v1/models/{id} 请求
+func (h *Handler) HandleOpenAIModel(w http.ResponseWriter, r *http.Request) {
+	anthropic := isAnthropicRequest(r)
+	if r.Method != http.MethodGet {
+		if anthropic {
+			writeAnthropicError(w, http.StatusMethodNotAllowed, "invalid_request_error", "Method not allowed")
+			return
+		}
+		writeOpenAIError(w, errMethodNotAllowed())
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/v1/models/")
+	m, ok := h.lookupModel(id)
+	if !ok {
+		if anthropic {
+			writeAnthropicError(w, http.StatusNotFound, "not_found_error", "model: "+id)
+			return
+		}
+		writeOpenAIError(w, newOpenAIError(http.StatusNotFound, "invalid_request_error", fmt.Sprintf("The model '%s' does not exist", id), "model", "model_not_found"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if anthropic {
+		json.NewEncoder(w).Encode(toAnthropicModel(m))
+		return
+	}
+	json.NewEncoder(w).Encode(toOpenAIModel(m))
+}
+
+// writeAnthropicModels 按 Anthropic 分页参数 limit / after_id / before_id 输出
+func (h *Handler) writeAnthropicModels(w http.ResponseWriter, r *http.Request, models []modelInfo) {
+	query := r.URL.Query()
+	limit := 20
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 1000 {
+			writeAnthropicError(w, http.StatusBadRequest, "invalid_request_error", "limit: must be between 1 and 1000")
+			return
+		}
+		limit = n
+	}
+
+	start, end := 0, len(models)
+	var hasMore bool
+	if before := query.Get("before_id"); before != "" {
+		// 向前翻页：取 before_id 之前的最后 limit 个
+		end = indexOfModel(models, before)
+		if end < 0 {
+			end = 0
+		}
+		if end > limit {
+			start = end - limit
+		}
+		hasMore = start > 0
+	} else {
+		if after := query.Get("after_id"); after != "" {
+			start = indexOfModel(models, after) + 1
+		}
+		if end-start > limit {
+			end = start + limit
+			hasMore = true
+		}
+	}
+
+	data := make([]AnthropicModel, 0, end-start)
+	for _, m := range models[start:end] {
+		data = append(data, toAnthropicModel(m))
+	}
+	resp := map[string]interface{}{
+		"data":     data,
+		"has_more": hasMore,
+		"first_id": nil,
+		"last_id":  nil,
+	}
+	if len(data) > 0 {
+		resp["first_id"] = data[0].ID
+		resp["last_id"] = data[len(data)-1].ID
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// indexOfModel 未找到时返回 -1
+func indexOfModel(models []modelInfo, id string) int {
+	for i, m := range models {
+		if strings.EqualFold(m.ID, id) {
+			return i
+		}
+	}
+	return -1
+}
+
+// writeAnthropicError 输出 Anthropic 错误格式
+func writeAnthropicError(w http.ResponseWriter, status int, errType, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"type":  "error",
+		"error": map[string]string{"type": errType, "message": message},
+	})
+}
@@ -0,0 +1,490 @@
+package handler
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"orchids-api/internal/debug"
+	"orchids-api/internal/prompt"
+	"orchids-api/internal/tiktoken"
+)
+
+// ollamaVersion /api/version 返回的版本号，部分客户端会据此判断功能支持
+const ollamaVersion = "0.6.0"
+
+// Ollama 请求格式
+type OllamaChatRequest struct {
+	Model    string          `json:"model"`
+	Messages []OllamaMessage `json:"messages"`
+	Tools    []OllamaTool    `json:"tools,omitempty"`
+	Stream   *bool           `json:"stream,omitempty"`
+	Options  *OllamaOptions  `json:"options,omitempty"`
+}
+
+type OllamaGenerateRequest struct {
+	Model   string         `json:"model"`
+	Prompt  string         `json:"prompt"`
+	System  string         `json:"system,omitempty"`
+	Images  []string       `json:"images,omitempty"`
+	Stream  *bool          `json:"stream,omitempty"`
+	Options *OllamaOptions `json:"options,omitempty"`
+}
+
+type OllamaMessage struct {
+	Role      string           `json:"role"`
+	Content   string           `json:"content"`
+	Images    []string         `json:"images,omitempty"`
+	ToolCalls []OllamaToolCall `json:"tool_calls,omitempty"`
+}
+
+type OllamaToolCall struct {
+	Function OllamaFunctionCall `json:"function"`
+}
+
+type OllamaFunctionCall struct {
+	Name      string                 `json:"name"`
+	Arguments map[string]interface{} `json:"arguments"`
+}
+
+type OllamaTool struct {
+	Type     string `json:"type"`
+	Function struct {
+		Name        string      `json:"name"`
+		Description string      `json:"description,omitempty"`
+		Parameters  interface{} `json:"parameters,omitempty"`
+	} `json:"function"`
+}
+
+type OllamaOptions struct {
+	NumPredict  int      `json:"num_predict,omitempty"`
+	Stop        []string `json:"stop,omitempty"`
+	Temperature *float64 `json:"temperature,omitempty"`
+}
+
+// OllamaResponse /api/chat 与 /api/generate 共用的响应格式
+type OllamaResponse struct {
+	Model              string         `json:"model"`
+	CreatedAt          string         `json:"created_at"`
+	Message            *OllamaMessage `json:"message,omitempty"`
+	Response           *string        `json:"response,omitempty"`
+	Done               bool           `json:"done"`
+	DoneReason         string         `json:"done_reason,omitempty"`
+	TotalDuration      int64          `json:"total_duration,omitempty"`
+	PromptEvalCount    int            `json:"prompt_eval_count,omitempty"`
+	PromptEvalDuration int64          `json:"prompt_eval_duration,omitempty"`
+	EvalCount          int            `json:"eval_count,omitempty"`
+	EvalDuration       int64          `json:"eval_duration,omitempty"`
+}
+
+type OllamaModel struct {
+	Name       string             `json:"name"`
+	Model      string             `json:"model"`
+	ModifiedAt string             `json:"modified_at"`
+	Size       int64              `json:"size"`
+	Digest     string             `json:"digest"`
+	Details    OllamaModelDetails `json:"details"`
+}
+
+type OllamaModelDetails struct {
+	Format            string   `json:"format"`
+	Family            string   `json:"family"`
+	Families          []string `json:"families"`
+	ParameterSize     string   `json:"parameter_size"`
+	QuantizationLevel string   `json:"quantization_level"`
+}
+
+// ollamaTurn 一次 Ollama 请求转换后的内容
+type ollamaTurn struct {
+	model    string
+	messages []prompt.Message
+	system   []prompt.SystemItem
+	tools    []interface{}
+	stream   bool
+	options  *OllamaOptions
+	chat     bool
+}
+
+// HandleOllamaChat 处理 /api/chat 请求
+func (h *Handler) HandleOllamaChat(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeOllamaError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var req OllamaChatRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeOllamaError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	if req.Model == "" {
+		writeOllamaError(w, http.StatusBadRequest, "model is required")
+		return
+	}
+
+	turn := &ollamaTurn{
+		model:   req.Model,
+		stream:  req.Stream == nil || *req.Stream,
+		options: req.Options,
+		chat:    true,
+	}
+
+	for _, tool := range req.Tools {
+		turn.tools = append(turn.tools, map[string]interface{}{
+			"name":         tool.Function.Name,
+			"description":  tool.Function.Description,
+			"input_schema": tool.Function.Parameters,
+		})
+	}
+
+	// tool 消息不带调用 ID，按顺序对应此前未匹配的工具调用
+	callCount := 0
+	var pendingCalls []string
+	for _, msg := range req.Messages {
+		switch msg.Role {
+		case "system":
+			turn.system = append(turn.system, prompt.SystemItem{Type: "text", Text: msg.Content})
+
+		case "assistant":
+			var blocks []prompt.ContentBlock
+			if msg.Content != "" {
+				blocks = append(blocks, prompt.ContentBlock{Type: "text", Text: msg.Content})
+			}
+			for _, call := range msg.ToolCalls {
+				callCount++
+				id := fmt.Sprintf("call_%d_%s", callCount, call.Function.Name)
+				pendingCalls = append(pendingCalls, id)
+				blocks = append(blocks, prompt.ContentBlock{
+					Type:  "tool_use",
+					ID:    id,
+					Name:  call.Function.Name,
+					Input: call.Function.Arguments,
+				})
+			}
+			claudeMsg := prompt.Message{Role: "assistant"}
+			claudeMsg.Content.Blocks = blocks
+			turn.messages = append(turn.messages, claudeMsg)
+
+		case "tool":
+			id := "call_unknown"
+			if len(pendingCalls) > 0 {
+				id, pendingCalls = pendingCalls[0], pendingCalls[1:]
+			}
+			claudeMsg := prompt.Message{Role: "user"}
+			claudeMsg.Content.Blocks = []prompt.ContentBlock{{
+				Type:      "tool_result",
+				ToolUseID: id,
+				Content:   msg.Content,
+			}}
+			turn.messages = append(turn.messages, claudeMsg)
+
+		default:
+			turn.messages = append(turn.messages, ollamaUserMessage(msg.Content, msg.Images))
+		}
+	}
+
+	if len(turn.messages) == 0 {
+		writeOllamaError(w, http.StatusBadRequest, "messages is required")
+		return
+	}
+
+	h.serveOllama(w, r, turn)
+}
+
+// HandleOllamaGenerate 处理 /api/generate 请求
+func (h *Handler) HandleOllamaGenerate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeOllamaError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var req OllamaGenerateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeOllamaError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	if req.Model == "" {
+		writeOllamaError(w, http.StatusBadRequest, "model is required")
+		return
+	}
+
+	// 空 prompt 在 Ollama 中表示加载模型，直接返回完成
+	if req.Prompt == "" && len(req.Images) == 0 {
+		empty := ""
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(OllamaResponse{
+			Model:      req.Model,
+			CreatedAt:  time.Now().UTC().Format(time.RFC3339Nano),
+			Response:   &empty,
+			Done:       true,
+			DoneReason: "load",
+		})
+		return
+	}
+
+	turn := &ollamaTurn{
+		model:    req.Model,
+		messages: []prompt.Message{ollamaUserMessage(req.Prompt, req.Images)},
+		stream:   req.Stream == nil || *req.Stream,
+		options:  req.Options,
+	}
+	if req.System != "" {
+		turn.system = []prompt.SystemItem{{Type: "text", Text: req.System}}
+	}
+
+	h.serveOllama(w, r, turn)
+}
+
+// serveOllama 执行请求并按 Ollama 格式输出（流式为 NDJSON）
+func (h *Handler) serveOllama(w http.ResponseWriter, r *http.Request, turn *ollamaTurn) {
+	startTime := time.Now()
+
+	limits := generationLimits{}
+	if turn.options != nil {
+		limits.maxTokens = turn.options.NumPredict
+		limits.stop = turn.options.Stop
+	}
+	// Ollama 中 num_predict 为 -1 表示不限制
+	if limits.maxTokens < 0 {
+		limits.maxTokens = 0
+	}
+
+	// 初始化调试日志
+	logger := debug.New(h.config.DebugEnabled)
+	defer logger.Close()
+
+	// 路由模型（Ollama 客户端会带上 :latest 标签）
+	target, err := h.resolveModel(strings.TrimSuffix(turn.model, ":latest"))
+	if err != nil {
+		writeOllamaError(w, http.StatusNotFound, fmt.Sprintf("model '%s' not found", turn.model))
+		return
+	}
+
+	set, err := h.newChoiceSet(1, h.modelChain(target), h.newAccountSelector(w, r, turn.model, sessionKey(r, "", turn.system, turn.messages)), logger)
+	if err != nil {
+		writeOllamaError(w, toOpenAIError(err).Status, err.Error())
+		return
+	}
+	defer set.release()
+
+	builtPrompt := prompt.BuildPromptV2(prompt.ClaudeAPIRequest{
+		Model:    turn.model,
+		Messages: turn.messages,
+		System:   turn.system,
+		Tools:    turn.tools,
+		Stream:   turn.stream,
+	})
+	logger.LogConvertedPrompt(builtPrompt)
+
+	inputTokens := tiktoken.EstimateTextTokens(builtPrompt)
+
+	// response 构建一条 Ollama 响应，chat 与 generate 的正文字段不同
+	response := func(content string, toolCalls []OllamaToolCall) OllamaResponse {
+		resp := OllamaResponse{
+			Model:     set.servedModel(turn.model),
+			CreatedAt: time.Now().UTC().Format(time.RFC3339Nano),
+		}
+		if turn.chat {
+			resp.Message = &OllamaMessage{Role: "assistant", Content: content, ToolCalls: toolCalls}
+		} else {
+			resp.Response = &content
+		}
+		return resp
+	}
+
+	final := func(c *chatChoice, content string) OllamaResponse {
+		resp := response(content, ollamaToolCalls(c))
+		resp.Done = true
+		resp.DoneReason = c.finishReason
+		resp.TotalDuration = time.Since(startTime).Nanoseconds()
+		resp.PromptEvalCount = inputTokens
+		resp.EvalCount = c.outputTokens
+		resp.EvalDuration = resp.TotalDuration
+		return resp
+	}
+
+	if turn.stream {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			writeOllamaError(w, http.StatusInternalServerError, "streaming not supported")
+			return
+		}
+
+		var mu sync.Mutex
+		var started bool
+		writeLine := func(v interface{}) {
+			mu.Lock()
+			defer mu.Unlock()
+			if !started {
+				started = true
+				w.Header().Set(servedModelHeader, set.servedModel(turn.model))
+			}
+			data, _ := json.Marshal(v)
+			w.Write(data)
+			w.Write([]byte("\n"))
+			flusher.Flush()
+		}
+
+		log.Println("新请求进入 (Ollama格式)")
+
+		err := set.run(r.Context(), builtPrompt, limits, true, func(c *chatChoice, delta string) {
+			writeLine(response(delta, nil))
+		}, func(c *chatChoice) {
+			writeLine(final(c, ""))
+		})
+		if err != nil && !started && r.Context().Err() == nil {
+			// 尚未输出任何内容时仍可返回普通错误响应
+			writeOllamaError(w, toOpenAIError(err).Status, err.Error())
+			return
+		}
+		if err != nil && r.Context().Err() == nil {
+			// 流已开始，Ollama 以一行 {"error": ...} 结束
+			writeLine(map[string]string{"error": err.Error()})
+		}
+
+	} else {
+		log.Println("新请求进入 (Ollama格式，非流式)")
+
+		err := set.run(r.Context(), builtPrompt, limits, false, func(c *chatChoice, delta string) {}, func(c *chatChoice) {})
+		if err != nil {
+			if r.Context().Err() == nil {
+				writeOllamaError(w, toOpenAIError(err).Status, err.Error())
+			}
+			return
+		}
+
+		c := set.choices[0]
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set(servedModelHeader, set.servedModel(turn.model))
+		json.NewEncoder(w).Encode(final(c, c.content.String()))
+	}
+
+	c := set.choices[0]
+	logger.LogSummary(inputTokens, c.outputTokens, time.Since(startTime), c.finishReason)
+	log.Printf("请求完成: 输入=%d tokens, 输出=%d tokens, 耗时=%v", inputTokens, c.outputTokens, time.Since(startTime))
+}
+
+// HandleOllamaTags 处理 /api/tags 请求
+func (h *Handler) HandleOllamaTags(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeOllamaError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	models := make([]OllamaModel, 0)
+	for _, m := range h.listModels() {
+		models = append(models, ollamaModel(m))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"models": models})
+}
+
+// HandleOllamaShow 处理 /api/show 请求
+func (h *Handler) HandleOllamaShow(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeOllamaError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var req struct {
+		Model string `json:"model"`
+		Name  string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeOllamaError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	name := req.Model
+	if name == "" {
+		name = req.Name
+	}
+
+	if m, ok := h.lookupModel(strings.TrimSuffix(name, ":latest")); ok {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"modelfile":    "FROM " + m.ID,
+			"parameters":   "",
+			"template":     "{{ .Prompt }}",
+			"details":      ollamaModel(m).Details,
+			"model_info":   map[string]interface{}{"general.architecture": m.OwnedBy},
+			"capabilities": []string{"completion", "tools", "vision"},
+			"modified_at":  m.CreatedAt.UTC().Format(time.RFC3339Nano),
+		})
+		return
+	}
+
+	writeOllamaError(w, http.StatusNotFound, fmt.Sprintf("model '%s' not found", name))
+}
+
+// HandleOllamaVersion 处理 /api/version 请求
+func (h *Handler) HandleOllamaVersion(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"version": ollamaVersion})
+}
+
+// ollamaModel 将对外模型转换为 Ollama 模型描述
+func ollamaModel(m modelInfo) OllamaModel {
+	return OllamaModel{
+		Name:       m.ID + ":latest",
+		Model:      m.ID + ":latest",
+		ModifiedAt: m.CreatedAt.UTC().Format(time.RFC3339Nano),
+		Digest:     fmt.Sprintf("%x", m.ID),
+		Details: OllamaModelDetails{
+			Format:   "remote",
+			Family:   m.OwnedBy,
+			Families: []string{m.OwnedBy},
+		},
+	}
+}
+
+// ollamaUserMessage 构建带可选图片的用户消息，Ollama 图片为不带 data URL 前缀的 base64
+func ollamaUserMessage(content string, images []string) prompt.Message {
+	msg := prompt.Message{Role: "user"}
+	if len(images) == 0 {
+		msg.Content.Text = content
+		return msg
+	}
+
+	var blocks []prompt.ContentBlock
+	for _, img := range images {
+		mediaType := "image/png"
+		if raw, err := base64.StdEncoding.DecodeString(img); err == nil {
+			mediaType = http.DetectContentType(raw)
+		}
+		blocks = append(blocks, prompt.ContentBlock{
+			Type:   "image",
+			Source: &prompt.ImageSource{Type: "base64", MediaType: mediaType, Data: img},
+		})
+	}
+	if content != "" {
+		blocks = append(blocks, prompt.ContentBlock{Type: "text", Text: content})
+	}
+	msg.Content.Blocks = blocks
+	return msg
+}
+
+// ollamaToolCalls 将 choice 的工具调用转换为 Ollama 格式
+func ollamaToolCalls(c *chatChoice) []OllamaToolCall {
+	var calls []OllamaToolCall
+	for _, call := range c.toolCalls {
+		var args map[string]interface{}
+		json.Unmarshal([]byte(call.Input), &args)
+		calls = append(calls, OllamaToolCall{Function: OllamaFunctionCall{Name: call.Name, Arguments: args}})
+	}
+	return calls
+}
+
+// writeOllamaError 写出 Ollama 格式的错误响应
+func writeOllamaError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
@@ -0,0 +1,212 @@
+package handler
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"orchids-api/internal/fakeupstream"
+	"orchids-api/internal/store"
+)
+
+func TestOllamaChatStream(t *testing.T) {
+	g := newTestGateway(t, &store.Account{Name: "a"})
+
+	rec := g.serve(g.h.HandleOllamaChat, http.MethodPost, "/api/chat",
+		`{"model":"claude-opus-4-5:latest","messages":[{"role":"user","content":"hi"}]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get("Content-Type"); got != "application/x-ndjson" {
+		t.Errorf("Content-Type = %q, want application/x-ndjson", got)
+	}
+
+	// 每行一个完整的 JSON 对象，只有最后一行 done 为 true
+	var lines []OllamaResponse
+	scanner := bufio.NewScanner(rec.Body)
+	for scanner.Scan() {
+		var resp OllamaResponse
+		if err := json.Unmarshal(scanner.Bytes(), &resp); err != nil {
+			t.Fatalf("invalid line %q: %v", scanner.Text(), err)
+		}
+		lines = append(lines, resp)
+	}
+	if len(lines) < 2 {
+		t.Fatalf("got %d lines, want content and a final line", len(lines))
+	}
+
+	var content strings.Builder
+	for i, resp := range lines {
+		if resp.Message == nil || resp.Message.Role != "assistant" || resp.Response != nil {
+			t.Fatalf("line %d = %+v, want chat message", i, resp)
+		}
+		if last := i == len(lines)-1; resp.Done != last {
+			t.Errorf("line %d: done = %v, want %v", i, resp.Done, last)
+		}
+		content.WriteString(resp.Message.Content)
+	}
+	if content.String() != fakeupstream.DefaultText {
+		t.Errorf("content = %q, want %q", content.String(), fakeupstream.DefaultText)
+	}
+	final := lines[len(lines)-1]
+	if final.DoneReason != "stop" || final.EvalCount <= 0 || final.PromptEvalCount <= 0 {
+		t.Errorf("final line = %+v, want done_reason stop and token counts", final)
+	}
+
+	// :latest 在路由前去掉
+	if reqs := g.fake.Requests(); len(reqs) != 1 || reqs[0].Model != "claude-opus-4.5" {
+		t.Errorf("upstream requests = %+v, want model claude-opus-4.5", reqs)
+	}
+}
+
+func TestOllamaGenerate(t *testing.T) {
+	g := newTestGateway(t, &store.Account{Name: "a"})
+
+	tests := []struct {
+		name string
+		body string
+		// wantResponse 非流式响应的 response 字段，wantReason 为 done_reason
+		wantResponse string
+		wantReason   string
+	}{
+		{
+			name:         "非流式返回单个对象",
+			body:         `{"model":"claude-opus-4-5","prompt":"hi","stream":false}`,
+			wantResponse: fakeupstream.DefaultText,
+			wantReason:   "stop",
+		},
+		{
+			name:         "带 :latest 标签",
+			body:         `{"model":"claude-opus-4-5:latest","prompt":"hi","stream":false}`,
+			wantResponse: fakeupstream.DefaultText,
+			wantReason:   "stop",
+		},
+		{
+			name:       "空 prompt 只加载模型",
+			body:       `{"model":"claude-opus-4-5"}`,
+			wantReason: "load",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := g.serve(g.h.HandleOllamaGenerate, http.MethodPost, "/api/generate", tt.body)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+			}
+			var resp OllamaResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("invalid body %s: %v", rec.Body.String(), err)
+			}
+			if resp.Message != nil || resp.Response == nil || *resp.Response != tt.wantResponse {
+				t.Errorf("response = %s, want %q", rec.Body.String(), tt.wantResponse)
+			}
+			if !resp.Done || resp.DoneReason != tt.wantReason {
+				t.Errorf("done = %v done_reason = %q, want %q", resp.Done, resp.DoneReason, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestOllamaModelNotFound(t *testing.T) {
+	g := newTestGateway(t, &store.Account{Name: "a"})
+	g.setRoutes(t, store.ModelRoute{MatchType: store.MatchExact, Pattern: "claude-opus-4-5", UpstreamModel: "claude-opus-4.5", Priority: 10, Enabled: true})
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		path    string
+		body    string
+	}{
+		{"chat", g.h.HandleOllamaChat, "/api/chat", `{"model":"no-such-model:latest","messages":[{"role":"user","content":"hi"}]}`},
+		{"generate", g.h.HandleOllamaGenerate, "/api/generate", `{"model":"no-such-model:latest","prompt":"hi"}`},
+		{"show", g.h.HandleOllamaShow, "/api/show", `{"model":"no-such-model:latest"}`},
+	}
+	for _, tt := range tests {
+		rec := g.serve(tt.handler, http.MethodPost, tt.path, tt.body)
+		var body struct {
+			Error string `json:"error"`
+		}
+		json.Unmarshal(rec.Body.Bytes(), &body)
+		if rec.Code != http.StatusNotFound || body.Error != "model 'no-such-model:latest' not found" {
+			t.Errorf("%s: status %d body %s", tt.name, rec.Code, rec.Body.String())
+		}
+	}
+	if got := len(g.fake.Requests()); got != 0 {
+		t.Errorf("got %d upstream requests, want 0", got)
+	}
+}
+
+func TestOllamaTags(t *testing.T) {
+	g := newTestGateway(t, &store.Account{Name: "a"})
+
+	rec := g.serve(g.h.HandleOllamaTags, http.MethodGet, "/api/tags", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	var body struct {
+		Models []OllamaModel `json:"models"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+
+	found := false
+	for _, m := range body.Models {
+		if !strings.HasSuffix(m.Name, ":latest") || m.Model != m.Name || m.Digest == "" || m.Details.Format != "remote" {
+			t.Errorf("model = %+v, want tagged name and remote details", m)
+		}
+		if m.Name == "claude-opus-4-5:latest" {
+			found = true
+			if m.Details.Family != "anthropic" || len(m.Details.Families) != 1 {
+				t.Errorf("details = %+v, want family anthropic", m.Details)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("models = %+v, want claude-opus-4-5:latest", body.Models)
+	}
+}
+
+func TestOllamaShow(t *testing.T) {
+	g := newTestGateway(t, &store.Account{Name: "a"})
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+	}{
+		{"model 字段带标签", `{"model":"claude-opus-4-5:latest"}`, http.StatusOK},
+		{"旧版 name 字段", `{"name":"claude-opus-4-5"}`, http.StatusOK},
+		{"缺少模型名", `{}`, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := g.serve(g.h.HandleOllamaShow, http.MethodPost, "/api/show", tt.body)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var body struct {
+				Modelfile    string                 `json:"modelfile"`
+				Details      OllamaModelDetails     `json:"details"`
+				ModelInfo    map[string]interface{} `json:"model_info"`
+				Capabilities []string               `json:"capabilities"`
+				ModifiedAt   string                 `json:"modified_at"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Modelfile != "FROM claude-opus-4-5" || body.Details.Format != "remote" || body.ModifiedAt == "" {
+				t.Errorf("body = %s", rec.Body.String())
+			}
+			if body.ModelInfo["general.architecture"] != body.Details.Family || len(body.Capabilities) == 0 {
+				t.Errorf("model_info = %v capabilities = %v", body.ModelInfo, body.Capabilities)
+			}
+		})
+	}
+}
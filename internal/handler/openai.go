@@ -1,6 +1,8 @@
 package handler
 
 import (
+	"context"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -11,17 +13,48 @@ import (
 
 	"orchids-api/internal/client"
 	"orchids-api/internal/debug"
+	"orchids-api/internal/loadbalancer"
 	"orchids-api/internal/prompt"
+	"orchids-api/internal/store"
 	"orchids-api/internal/tiktoken"
 )
 
 // OpenAI 请求格式
 type OpenAIRequest struct {
-	Model       string          `json:"model"`
-	Messages    []OpenAIMessage `json:"messages"`
-	Stream      bool            `json:"stream"`
-	MaxTokens   int             `json:"max_tokens,omitempty"`
-	Temperature float64         `json:"temperature,omitempty"`
+	Model         string               `json:"model"`
+	Messages      []OpenAIMessage      `json:"messages"`
+	Stream        bool                 `json:"stream"`
+	StreamOptions *OpenAIStreamOptions `json:"stream_options,omitempty"`
+	N             int                  `json:"n,omitempty"`
+	MaxTokens     int                  `json:"max_tokens,omitempty"`
+	// MaxCompletionTokens 新版 SDK 使用的字段，优先于 max_tokens
+	MaxCompletionTokens int        `json:"max_completion_tokens,omitempty"`
+	Stop                OpenAIStop `json:"stop,omitempty"`
+	Temperature         float64    `json:"temperature,omitempty"`
+	// Metadata 其中的 user_id 用于会话粘滞
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// OpenAIStop stop 参数，可以是 string 或 []string
+type OpenAIStop []string
+
+func (s *OpenAIStop) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*s = OpenAIStop{single}
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("stop must be a string or an array of strings")
+	}
+	*s = list
+	return nil
+}
+
+type OpenAIStreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
 }
 
 type OpenAIMessage struct {
@@ -76,6 +109,7 @@ type OpenAIStreamChunk struct {
 	Created int64          `json:"created"`
 	Model   string         `json:"model"`
 	Choices []OpenAIChoice `json:"choices"`
+	Usage   *OpenAIUsage   `json:"usage,omitempty"`
 }
 
 // HandleOpenAIChat 处理 OpenAI 格式的 /v1/chat/completions 请求
@@ -83,21 +117,241 @@ func (h *Handler) HandleOpenAIChat(w http.ResponseWriter, r *http.Request) {
 	startTime := time.Now()
 
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeOpenAIError(w, errMethodNotAllowed())
 		return
 	}
 
 	var req OpenAIRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		writeOpenAIError(w, errInvalidRequest("Invalid request body: "+err.Error(), ""))
+		return
+	}
+	if len(req.Messages) == 0 {
+		writeOpenAIError(w, errInvalidRequest("'messages' is a required property", "messages"))
+		return
+	}
+
+	n := req.N
+	if n == 0 {
+		n = 1
+	}
+	if n < 1 || n > maxChoices {
+		writeOpenAIError(w, errInvalidRequest(fmt.Sprintf("'n' must be between 1 and %d", maxChoices), "n"))
+		return
+	}
+
+	limits := generationLimits{maxTokens: req.MaxTokens, stop: req.Stop}
+	if req.MaxCompletionTokens != 0 {
+		limits.maxTokens = req.MaxCompletionTokens
+	}
+	if limits.maxTokens < 0 {
+		writeOpenAIError(w, errInvalidRequest("'max_tokens' must be a positive integer", "max_tokens"))
+		return
+	}
+	if len(limits.stop) > 4 {
+		writeOpenAIError(w, errInvalidRequest("'stop' may contain at most 4 sequences", "stop"))
 		return
 	}
 
 	// 转换 OpenAI messages 到 Claude messages
-	claudeMessages := make([]prompt.Message, 0, len(req.Messages))
+	claudeMessages, systemContent := convertOpenAIMessages(req.Messages)
+
+	// 初始化调试日志
+	logger := debug.New(h.config.DebugEnabled)
+	defer logger.Close()
+	logger.LogIncomingRequest(req)
+
+	// 路由模型
+	target, err := h.resolveModel(req.Model)
+	if err != nil {
+		writeOpenAIError(w, err)
+		return
+	}
+
+	// 为每个 choice 选择账号，尽量分散到不同账号
+	var systemItems []prompt.SystemItem
+	if systemContent != "" {
+		systemItems = []prompt.SystemItem{{Type: "text", Text: systemContent}}
+	}
+
+	sel := h.newAccountSelector(w, r, req.Model, sessionKey(r, req.Metadata["user_id"], systemItems, claudeMessages))
+	set, err := h.newChoiceSet(n, h.modelChain(target), sel, logger)
+	if err != nil {
+		writeOpenAIError(w, err)
+		return
+	}
+	defer set.release()
+
+	// 构建 prompt
+	builtPrompt := prompt.BuildPromptV2(prompt.ClaudeAPIRequest{
+		Model:    req.Model,
+		Messages: claudeMessages,
+		System:   systemItems,
+		Tools:    nil,
+		Stream:   req.Stream,
+	})
+	logger.LogConvertedPrompt(builtPrompt)
+
+	// Token 计数
+	inputTokens := tiktoken.EstimateTextTokens(builtPrompt)
+
+	msgID := fmt.Sprintf("chatcmpl-%d", time.Now().UnixMilli())
+	created := time.Now().Unix()
+
+	runAll := func(onDelta func(c *chatChoice, delta string), onFinish func(c *chatChoice)) error {
+		return set.run(r.Context(), builtPrompt, limits, req.Stream, onDelta, onFinish)
+	}
+
+	usage := func() OpenAIUsage {
+		// 与 OpenAI 一致：prompt 只计一次，completion 为所有 choice 之和
+		completion := set.outputTokens()
+		return OpenAIUsage{
+			PromptTokens:     inputTokens,
+			CompletionTokens: completion,
+			TotalTokens:      inputTokens + completion,
+		}
+	}
+
+	if req.Stream {
+		// 流式响应
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			writeOpenAIError(w, newOpenAIError(http.StatusInternalServerError, "server_error", "Streaming not supported", "", ""))
+			return
+		}
+
+		var mu sync.Mutex
+		var hasReturn, started bool
+
+		chunk := func(choice OpenAIChoice) string {
+			data, _ := json.Marshal(OpenAIStreamChunk{
+				ID:      msgID,
+				Object:  "chat.completion.chunk",
+				Created: created,
+				Model:   set.servedModel(req.Model),
+				Choices: []OpenAIChoice{choice},
+			})
+			return string(data)
+		}
+
+		// 首个数据块到达时才开始输出，此前仍可降级模型
+		startLocked := func() {
+			if started {
+				return
+			}
+			started = true
+			w.Header().Set(servedModelHeader, set.servedModel(req.Model))
+			for i := 0; i < n; i++ {
+				fmt.Fprintf(w, "data: %s\n\n", chunk(OpenAIChoice{Index: i, Delta: &OpenAIDelta{Role: "assistant"}}))
+			}
+		}
+
+		writeChunk := func(choice OpenAIChoice) {
+			mu.Lock()
+			defer mu.Unlock()
+			if hasReturn {
+				return
+			}
+			startLocked()
+			fmt.Fprintf(w, "data: %s\n\n", chunk(choice))
+			flusher.Flush()
+		}
+
+		log.Printf("新请求进入 (OpenAI格式, n=%d)", n)
+
+		firstErr := runAll(func(c *chatChoice, delta string) {
+			writeChunk(OpenAIChoice{Index: c.index, Delta: &OpenAIDelta{Content: delta}})
+		}, func(c *chatChoice) {
+			finishReason := c.finishReason
+			writeChunk(OpenAIChoice{Index: c.index, Delta: &OpenAIDelta{}, FinishReason: &finishReason})
+		})
+
+		if r.Context().Err() != nil {
+			return
+		}
+
+		mu.Lock()
+		hasReturn = true
+		mu.Unlock()
+
+		// 尚未输出任何内容时仍可返回普通错误响应
+		if firstErr != nil && !started {
+			writeOpenAIError(w, firstErr)
+			return
+		}
+		startLocked()
+
+		// 流已开始，错误只能以数据块形式下发
+		if firstErr != nil {
+			fmt.Fprintf(w, "data: %s\n\n", openAIErrorChunk(firstErr))
+		} else if req.StreamOptions != nil && req.StreamOptions.IncludeUsage {
+			u := usage()
+			data, _ := json.Marshal(OpenAIStreamChunk{
+				ID:      msgID,
+				Object:  "chat.completion.chunk",
+				Created: created,
+				Model:   set.servedModel(req.Model),
+				Choices: []OpenAIChoice{},
+				Usage:   &u,
+			})
+			fmt.Fprintf(w, "data: %s\n\n", string(data))
+		}
+		fmt.Fprintf(w, "data: [DONE]\n\n")
+		flusher.Flush()
+
+	} else {
+		// 非流式响应
+		log.Printf("新请求进入 (OpenAI格式，非流式, n=%d)", n)
+
+		firstErr := runAll(func(c *chatChoice, delta string) {}, func(c *chatChoice) {})
+
+		if firstErr != nil {
+			if r.Context().Err() == nil {
+				writeOpenAIError(w, firstErr)
+			}
+			return
+		}
+
+		response := OpenAIResponse{
+			ID:      msgID,
+			Object:  "chat.completion",
+			Created: created,
+			Model:   set.servedModel(req.Model),
+			Choices: make([]OpenAIChoice, 0, n),
+			Usage:   usage(),
+		}
+		for _, c := range set.choices {
+			finishReason := c.finishReason
+			response.Choices = append(response.Choices, OpenAIChoice{
+				Index: c.index,
+				Message: &OpenAIMessage{
+					Role:    "assistant",
+					Content: c.content.String(),
+				},
+				FinishReason: &finishReason,
+			})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set(servedModelHeader, response.Model)
+		json.NewEncoder(w).Encode(response)
+	}
+
+	u := usage()
+	logger.LogSummary(u.PromptTokens, u.CompletionTokens, time.Since(startTime), set.choices[0].finishReason)
+	log.Printf("请求完成: 输入=%d tokens, 输出=%d tokens, 耗时=%v", u.PromptTokens, u.CompletionTokens, time.Since(startTime))
+}
+
+// convertOpenAIMessages 将 OpenAI messages 转换为 Claude messages，并提取 system 内容
+func convertOpenAIMessages(messages []OpenAIMessage) ([]prompt.Message, string) {
+	claudeMessages := make([]prompt.Message, 0, len(messages))
 	var systemContent string
 
-	for _, msg := range req.Messages {
+	for _, msg := range messages {
 		// 解析 content（可能是 string 或 array）
 		var textContent string
 		var contentBlocks []prompt.ContentBlock
@@ -177,302 +431,18 @@ func (h *Handler) HandleOpenAIChat(w http.ResponseWriter, r *http.Request) {
 		claudeMessages = append(claudeMessages, claudeMsg)
 	}
 
-	// 初始化调试日志
-	logger := debug.New(h.config.DebugEnabled)
-	defer logger.Close()
-
-	// 选择账号
-	var apiClient *client.Client
-	var failedAccountIDs []int64
-
-	selectAccount := func() error {
-		if h.loadBalancer != nil {
-			account, err := h.loadBalancer.GetNextAccountExcluding(failedAccountIDs)
-			if err != nil {
-				if h.client != nil {
-					apiClient = h.client
-					return nil
-				}
-				return err
-			}
-			log.Printf("使用账号: %s (%s)", account.Name, account.Email)
-			apiClient = client.NewFromAccount(account)
-			return nil
-		} else if h.client != nil {
-			apiClient = h.client
-			return nil
-		}
-		return fmt.Errorf("no client configured")
-	}
-
-	if err := selectAccount(); err != nil {
-		http.Error(w, err.Error(), http.StatusServiceUnavailable)
-		return
-	}
-
-	// 构建 prompt
-	var systemItems []prompt.SystemItem
-	if systemContent != "" {
-		systemItems = []prompt.SystemItem{{Type: "text", Text: systemContent}}
-	}
-
-	builtPrompt := prompt.BuildPromptV2(prompt.ClaudeAPIRequest{
-		Model:    req.Model,
-		Messages: claudeMessages,
-		System:   systemItems,
-		Tools:    nil,
-		Stream:   req.Stream,
-	})
-
-	// 映射模型
-	mappedModel := mapModel(req.Model)
-	log.Printf("模型映射: %s -> %s", req.Model, mappedModel)
-
-	// Token 计数
-	inputTokens := tiktoken.EstimateTextTokens(builtPrompt)
-	var outputTokens int
-	var outputMu sync.Mutex
-
-	addOutputTokens := func(text string) {
-		if text == "" {
-			return
-		}
-		tokens := tiktoken.EstimateTextTokens(text)
-		outputMu.Lock()
-		outputTokens += tokens
-		outputMu.Unlock()
-	}
-
-	msgID := fmt.Sprintf("chatcmpl-%d", time.Now().UnixMilli())
-
-	if req.Stream {
-		// 流式响应
-		w.Header().Set("Content-Type", "text/event-stream")
-		w.Header().Set("Cache-Control", "no-cache")
-		w.Header().Set("Connection", "keep-alive")
-
-		flusher, ok := w.(http.Flusher)
-		if !ok {
-			http.Error(w, "Streaming not supported", http.StatusInternalServerError)
-			return
-		}
-
-		var mu sync.Mutex
-		var hasReturn bool
-		var fullContent strings.Builder
-
-		writeSSE := func(data string) {
-			mu.Lock()
-			defer mu.Unlock()
-			if hasReturn {
-				return
-			}
-			fmt.Fprintf(w, "data: %s\n\n", data)
-			flusher.Flush()
-		}
-
-		// 发送初始 role delta
-		initChunk := OpenAIStreamChunk{
-			ID:      msgID,
-			Object:  "chat.completion.chunk",
-			Created: time.Now().Unix(),
-			Model:   req.Model,
-			Choices: []OpenAIChoice{{
-				Index: 0,
-				Delta: &OpenAIDelta{Role: "assistant"},
-			}},
-		}
-		initData, _ := json.Marshal(initChunk)
-		writeSSE(string(initData))
-
-		log.Println("新请求进入 (OpenAI格式)")
-
-		done := make(chan struct{})
-
-		go func() {
-			defer close(done)
-
-			err := apiClient.SendRequest(r.Context(), builtPrompt, []interface{}{}, mappedModel, func(msg client.SSEMessage) {
-				mu.Lock()
-				if hasReturn {
-					mu.Unlock()
-					return
-				}
-				mu.Unlock()
-
-				eventKey := msg.Type
-				if msg.Type == "model" && msg.Event != nil {
-					if evtType, ok := msg.Event["type"].(string); ok {
-						eventKey = "model." + evtType
-					}
-				}
-
-				switch eventKey {
-				case "model.text-delta":
-					delta, _ := msg.Event["delta"].(string)
-					if delta != "" {
-						addOutputTokens(delta)
-						fullContent.WriteString(delta)
-
-						chunk := OpenAIStreamChunk{
-							ID:      msgID,
-							Object:  "chat.completion.chunk",
-							Created: time.Now().Unix(),
-							Model:   req.Model,
-							Choices: []OpenAIChoice{{
-								Index: 0,
-								Delta: &OpenAIDelta{Content: delta},
-							}},
-						}
-						data, _ := json.Marshal(chunk)
-						writeSSE(string(data))
-					}
-
-				case "model.finish":
-					finishReason := "stop"
-					chunk := OpenAIStreamChunk{
-						ID:      msgID,
-						Object:  "chat.completion.chunk",
-						Created: time.Now().Unix(),
-						Model:   req.Model,
-						Choices: []OpenAIChoice{{
-							Index:        0,
-							Delta:        &OpenAIDelta{},
-							FinishReason: &finishReason,
-						}},
-					}
-					data, _ := json.Marshal(chunk)
-
-					mu.Lock()
-					if !hasReturn {
-						hasReturn = true
-						mu.Unlock()
-						fmt.Fprintf(w, "data: %s\n\n", string(data))
-						fmt.Fprintf(w, "data: [DONE]\n\n")
-						flusher.Flush()
-						log.Printf("请求完成: 输入=%d tokens, 输出=%d tokens, 耗时=%v", inputTokens, outputTokens, time.Since(startTime))
-					} else {
-						mu.Unlock()
-					}
-				}
-			}, logger)
-
-			if err != nil {
-				log.Printf("Error: %v", err)
-			}
-
-			// 确保发送结束信号
-			mu.Lock()
-			if !hasReturn {
-				hasReturn = true
-				mu.Unlock()
-
-				finishReason := "stop"
-				chunk := OpenAIStreamChunk{
-					ID:      msgID,
-					Object:  "chat.completion.chunk",
-					Created: time.Now().Unix(),
-					Model:   req.Model,
-					Choices: []OpenAIChoice{{
-						Index:        0,
-						Delta:        &OpenAIDelta{},
-						FinishReason: &finishReason,
-					}},
-				}
-				data, _ := json.Marshal(chunk)
-
-				fmt.Fprintf(w, "data: %s\n\n", string(data))
-				fmt.Fprintf(w, "data: [DONE]\n\n")
-				flusher.Flush()
-
-				log.Printf("请求完成: 输入=%d tokens, 输出=%d tokens, 耗时=%v", inputTokens, outputTokens, time.Since(startTime))
-			} else {
-				mu.Unlock()
-			}
-		}()
-
-		<-done
-
-	} else {
-		// 非流式响应
-		var fullContent strings.Builder
-
-		log.Println("新请求进入 (OpenAI格式，非流式)")
-
-		err := apiClient.SendRequest(r.Context(), builtPrompt, []interface{}{}, mappedModel, func(msg client.SSEMessage) {
-			eventKey := msg.Type
-			if msg.Type == "model" && msg.Event != nil {
-				if evtType, ok := msg.Event["type"].(string); ok {
-					eventKey = "model." + evtType
-				}
-			}
-
-			if eventKey == "model.text-delta" {
-				delta, _ := msg.Event["delta"].(string)
-				if delta != "" {
-					addOutputTokens(delta)
-					fullContent.WriteString(delta)
-				}
-			}
-		}, logger)
-
-		if err != nil {
-			log.Printf("Error: %v", err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-
-		finishReason := "stop"
-		response := OpenAIResponse{
-			ID:      msgID,
-			Object:  "chat.completion",
-			Created: time.Now().Unix(),
-			Model:   req.Model,
-			Choices: []OpenAIChoice{{
-				Index: 0,
-				Message: &OpenAIMessage{
-					Role:    "assistant",
-					Content: fullContent.String(),
-				},
-				FinishReason: &finishReason,
-			}},
-			Usage: OpenAIUsage{
-				PromptTokens:     inputTokens,
-				CompletionTokens: outputTokens,
-				TotalTokens:      inputTokens + outputTokens,
-			},
-		}
-
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
-
-		log.Printf("请求完成: 输入=%d tokens, 输出=%d tokens, 耗时=%v", inputTokens, outputTokens, time.Since(startTime))
-	}
+	return claudeMessages, systemContent
 }
 
-// HandleOpenAIModels 处理 /v1/models 请求
-func (h *Handler) HandleOpenAIModels(w http.ResponseWriter, r *http.Request) {
-	models := map[string]interface{}{
-		"object": "list",
-		"data": []map[string]interface{}{
-			{"id": "claude-opus-4-5", "object": "model", "owned_by": "anthropic"},
-			{"id": "claude-opus-4-5-thinking", "object": "model", "owned_by": "anthropic"},
-			{"id": "claude-sonnet-4-20250514", "object": "model", "owned_by": "anthropic"},
-			{"id": "claude-sonnet-4-5", "object": "model", "owned_by": "anthropic"},
-			{"id": "gpt-4", "object": "model", "owned_by": "openai"},
-			{"id": "gpt-4o", "object": "model", "owned_by": "openai"},
-		},
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(models)
-}
+// maxImages 单次请求最多生成的图片数
+const maxImages = 10
 
 // OpenAIImageRequest OpenAI 图像生成请求格式
 type OpenAIImageRequest struct {
-	Prompt string `json:"prompt"`
-	N      int    `json:"n,omitempty"`
-	Size   string `json:"size,omitempty"`
+	Prompt         string `json:"prompt"`
+	N              int    `json:"n,omitempty"`
+	Size           string `json:"size,omitempty"`
+	ResponseFormat string `json:"response_format,omitempty"`
 }
 
 // OpenAIImageResponse OpenAI 图像生成响应格式
@@ -482,95 +452,165 @@ type OpenAIImageResponse struct {
 }
 
 type OpenAIImageData struct {
-	URL string `json:"url"`
+	URL     string `json:"url,omitempty"`
+	B64JSON string `json:"b64_json,omitempty"`
 }
 
 // HandleOpenAIImages 处理 /v1/images/generations 请求
 func (h *Handler) HandleOpenAIImages(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeOpenAIError(w, errMethodNotAllowed())
 		return
 	}
 
 	var req OpenAIImageRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		writeOpenAIError(w, errInvalidRequest("Invalid request body: "+err.Error(), ""))
 		return
 	}
 
 	if req.Prompt == "" {
-		http.Error(w, "Prompt is required", http.StatusBadRequest)
+		writeOpenAIError(w, errInvalidRequest("'prompt' is a required property", "prompt"))
 		return
 	}
 
-	// 选择账号
+	// 设置默认值
+	n := req.N
+	if n == 0 {
+		n = 1
+	}
+	if n < 1 || n > maxImages {
+		writeOpenAIError(w, errInvalidRequest(fmt.Sprintf("%d is not a valid value for 'n' - must be between 1 and %d", req.N, maxImages), "n"))
+		return
+	}
+	format := req.ResponseFormat
+	if format == "" {
+		format = "url"
+	}
+	if format != "url" && format != "b64_json" {
+		writeOpenAIError(w, errInvalidRequest("'response_format' must be 'url' or 'b64_json'", "response_format"))
+		return
+	}
+	size := req.Size
+	if size == "" {
+		size = "1024x1024"
+	}
+
+	data, err := h.generateImages(w, r, n, format, func(ctx context.Context, apiClient *client.Client) (string, error) {
+		return apiClient.GenerateImage(ctx, req.Prompt, size)
+	})
+	if err != nil {
+		writeOpenAIError(w, err)
+		return
+	}
+
+	// 构建响应
+	response := OpenAIImageResponse{
+		Created: time.Now().Unix(),
+		Data:    data,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+
+	log.Printf("Image generated: %d (%s)", len(data), format)
+}
+
+// generateImages 并行调用 n 次上游，每次独立选择账号；任一失败则整体失败
+func (h *Handler) generateImages(w http.ResponseWriter, r *http.Request, n int, format string, generate func(context.Context, *client.Client) (string, error)) ([]OpenAIImageData, error) {
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	// 任一图片失败时其余图片也停止排队
+	sel := h.newAccountSelector(w, r, "", "")
+	sel.ctx = ctx
+
+	data := make([]OpenAIImageData, n)
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			imageURL, err := h.generateImageWithFailover(ctx, sel, generate)
+			if err == nil {
+				var url string
+				var raw []byte
+				url, raw, err = h.cacheMedia(ctx, r, imageURL, format == "b64_json")
+				if format == "b64_json" {
+					data[i].B64JSON = base64.StdEncoding.EncodeToString(raw)
+				} else {
+					data[i].URL = url
+				}
+			}
+			if err != nil {
+				once.Do(func() {
+					firstErr = err
+					cancel()
+				})
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return data, nil
+}
+
+// generateImageWithFailover 生成单张图片，失败时换一个账号重试一次
+func (h *Handler) generateImageWithFailover(ctx context.Context, sel *accountSelector, generate func(context.Context, *client.Client) (string, error)) (string, error) {
 	var apiClient *client.Client
 	var failedAccountIDs []int64
+	var currentAccount *store.Account
 
+	// selectAccount 释放上一个账号并选择新账号
 	selectAccount := func() error {
+		h.releaseAccount(currentAccount)
+		currentAccount = nil
 		if h.loadBalancer != nil {
-			account, err := h.loadBalancer.GetNextAccountExcluding(failedAccountIDs)
+			account, err := sel.acquire("", failedAccountIDs, false)
 			if err != nil {
-				if h.client != nil {
+				if h.client != nil && len(failedAccountIDs) == 0 && sel.canUseDefault(err) {
 					apiClient = h.client
+					currentAccount = nil
 					return nil
 				}
 				return err
 			}
-			log.Printf("使用账号: %s (%s)", account.Name, account.Email)
+			log.Printf("使用账号: %s (%s) [优先级 %d]", account.Name, account.Email, account.Priority)
 			apiClient = client.NewFromAccount(account)
+			currentAccount = account
 			return nil
 		} else if h.client != nil {
 			apiClient = h.client
 			return nil
 		}
-		return fmt.Errorf("no client configured")
+		return loadbalancer.ErrNoAccounts
 	}
 
+	defer func() { h.releaseAccount(currentAccount) }()
 	if err := selectAccount(); err != nil {
-		http.Error(w, err.Error(), http.StatusServiceUnavailable)
-		return
-	}
-
-	// 设置默认值
-	size := req.Size
-	if size == "" {
-		size = "1024x1024"
+		return "", err
 	}
 
-	// 生成图像
-	imageURL, err := apiClient.GenerateImage(r.Context(), req.Prompt, size)
-	if err != nil {
+	imageURL, err := generate(ctx, apiClient)
+	h.reportAccount(currentAccount, err)
+	if err != nil && ctx.Err() == nil {
 		log.Printf("Error generating image: %v", err)
 		// 尝试使用其他账号
-		if h.loadBalancer != nil {
-			if err := selectAccount(); err == nil {
-				imageURL, err = apiClient.GenerateImage(r.Context(), req.Prompt, size)
+		if currentAccount != nil {
+			failedAccountIDs = append(failedAccountIDs, currentAccount.ID)
+			if selectErr := selectAccount(); selectErr == nil {
+				imageURL, err = generate(ctx, apiClient)
+				h.reportAccount(currentAccount, err)
 			}
 		}
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-	}
-
-	// 构建响应
-	response := OpenAIImageResponse{
-		Created: time.Now().Unix(),
-		Data:    []OpenAIImageData{{URL: imageURL}},
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
-
-	log.Printf("Image generated: %s", imageURL)
-}
-
-// OpenAIVideoRequest OpenAI 视频生成请求格式
-type OpenAIVideoRequest struct {
-	Prompt string `json:"prompt"`
-	N      int    `json:"n,omitempty"`
-	Size   string `json:"size,omitempty"`
+	return imageURL, err
 }
 
 // OpenAIVideoResponse OpenAI 视频生成响应格式
@@ -586,68 +626,68 @@ type OpenAIVideoData struct {
 // HandleOpenAIVideos 处理 /v1/videos/generations 请求
 func (h *Handler) HandleOpenAIVideos(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
-	var req OpenAIVideoRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		writeOpenAIError(w, errMethodNotAllowed())
 		return
 	}
 
-	if req.Prompt == "" {
-		http.Error(w, "Prompt is required", http.StatusBadRequest)
+	prompt, opts, err := parseVideoRequest(w, r)
+	if err != nil {
+		writeOpenAIError(w, err)
 		return
 	}
 
 	// 选择账号
+	sel := h.newAccountSelector(w, r, "", "")
 	var apiClient *client.Client
+	var currentAccount *store.Account
 	var failedAccountIDs []int64
 
+	// selectAccount 释放上一个账号并选择新账号
 	selectAccount := func() error {
+		h.releaseAccount(currentAccount)
+		currentAccount = nil
 		if h.loadBalancer != nil {
-			account, err := h.loadBalancer.GetNextAccountExcluding(failedAccountIDs)
+			account, err := sel.acquire("", failedAccountIDs, false)
 			if err != nil {
-				if h.client != nil {
+				if h.client != nil && sel.canUseDefault(err) {
 					apiClient = h.client
+					currentAccount = nil
 					return nil
 				}
 				return err
 			}
-			log.Printf("使用账号: %s (%s)", account.Name, account.Email)
+			log.Printf("使用账号: %s (%s) [优先级 %d]", account.Name, account.Email, account.Priority)
 			apiClient = client.NewFromAccount(account)
+			currentAccount = account
 			return nil
 		} else if h.client != nil {
 			apiClient = h.client
 			return nil
 		}
-		return fmt.Errorf("no client configured")
+		return loadbalancer.ErrNoAccounts
 	}
 
+	defer func() { h.releaseAccount(currentAccount) }()
 	if err := selectAccount(); err != nil {
-		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		writeOpenAIError(w, err)
 		return
 	}
 
-	// 设置默认值
-	size := req.Size
-	if size == "" {
-		size = "1024x1024"
-	}
-
 	// 生成视频
-	videoURL, err := apiClient.GenerateVideo(r.Context(), req.Prompt, size)
+	videoURL, err := apiClient.GenerateVideo(r.Context(), prompt, opts)
+	h.reportAccount(currentAccount, err)
 	if err != nil {
 		log.Printf("Error generating video: %v", err)
 		// 尝试使用其他账号
-		if h.loadBalancer != nil {
-			if err := selectAccount(); err == nil {
-				videoURL, err = apiClient.GenerateVideo(r.Context(), req.Prompt, size)
+		if currentAccount != nil && r.Context().Err() == nil {
+			failedAccountIDs = append(failedAccountIDs, currentAccount.ID)
+			if selectErr := selectAccount(); selectErr == nil {
+				videoURL, err = apiClient.GenerateVideo(r.Context(), prompt, opts)
+				h.reportAccount(currentAccount, err)
 			}
 		}
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeOpenAIError(w, err)
 			return
 		}
 	}
@@ -0,0 +1,451 @@
+package handler
+
+import (
+	"context"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"orchids-api/internal/client"
+	"orchids-api/internal/debug"
+	"orchids-api/internal/loadbalancer"
+	"orchids-api/internal/modelroute"
+	"orchids-api/internal/store"
+	"orchids-api/internal/tiktoken"
+)
+
+// maxChoices 单个请求允许的最大 n
+const maxChoices = 8
+
+// chatChoice 单个 choice 的上游执行状态
+type chatChoice struct {
+	index        int
+	apiClient    *client.Client
+	account      *store.Account
+	failedIDs    []int64
+	content      strings.Builder
+	counter      tiktoken.TextCounter // content 的 token 估算，按增量累计
+	outputTokens int
+	emitted      bool
+	pending      string
+	toolCalls    []choiceToolCall
+	finishReason string
+}
+
+// choiceToolCall 上游返回的工具调用
+type choiceToolCall struct {
+	ID    string
+	Name  string
+	Input string
+}
+
+// generationLimits 生成截断条件
+type generationLimits struct {
+	maxTokens int
+	stop      []string
+}
+
+// accountPicker 为同一请求的多个 choice 分配账号，优先让不同 choice 使用不同账号
+type accountPicker struct {
+	h     *Handler
+	model string // 上游模型，只分配能服务该模型的账号
+	sel   *accountSelector
+	mu    sync.Mutex
+	inUse map[int64]int
+}
+
+func newAccountPicker(h *Handler, model string, sel *accountSelector) *accountPicker {
+	return &accountPicker{h: h, model: model, sel: sel, inUse: make(map[int64]int)}
+}
+
+// assign 为 choice 选择账号，排除其已失败的账号；账号不足时允许与其他 choice 共用
+func (p *accountPicker) assign(c *chatChoice) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.h.loadBalancer == nil {
+		if p.h.client == nil {
+			return loadbalancer.ErrNoAccounts
+		}
+		c.apiClient, c.account = p.h.client, nil
+		return nil
+	}
+
+	// 优先使用其他 choice 未占用的账号，不足时允许共用。
+	// 其余 choice 会避开第一个 choice 的账号，不能参与会话绑定
+	sticky := c.index == 0
+	var account *store.Account
+	err := loadbalancer.ErrAccountsBusy
+	if len(p.inUse) > 0 {
+		exclude := append([]int64{}, c.failedIDs...)
+		for id := range p.inUse {
+			exclude = append(exclude, id)
+		}
+		account, err = p.h.loadBalancer.Select(p.sel.request(p.model, exclude, false))
+	}
+	if err != nil {
+		account, err = p.sel.acquire(p.model, c.failedIDs, sticky)
+	}
+	if err != nil {
+		if p.h.client != nil && p.sel.canUseDefault(err) {
+			log.Println("负载均衡无可用账号，使用默认配置")
+			c.apiClient, c.account = p.h.client, nil
+			return nil
+		}
+		return err
+	}
+
+	log.Printf("使用账号: %s (%s) [优先级 %d] [choice %d]", account.Name, account.Email, account.Priority, c.index)
+	p.inUse[account.ID]++
+	c.apiClient, c.account = client.NewFromAccount(account), account
+	return nil
+}
+
+// release 释放 choice 对账号的占用
+func (p *accountPicker) release(account *store.Account) {
+	if account == nil {
+		return
+	}
+	p.h.releaseAccount(account)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.inUse[account.ID] <= 1 {
+		delete(p.inUse, account.ID)
+	} else {
+		p.inUse[account.ID]--
+	}
+}
+
+// choiceSet 同一请求的全部 choice
+type choiceSet struct {
+	h       *Handler
+	picker  *accountPicker
+	choices []*chatChoice
+	chain   []modelroute.Target // 请求模型及降级模型
+	current int                 // chain 中正在使用的模型
+	sel     *accountSelector
+	logger  *debug.Logger
+}
+
+// newChoiceSet 创建 n 个 choice 并为每个 choice 选择可服务当前模型的账号；
+// 请求模型分配不到账号时按 chain 依次降级
+func (h *Handler) newChoiceSet(n int, chain []modelroute.Target, sel *accountSelector, logger *debug.Logger) (*choiceSet, error) {
+	set := &choiceSet{h: h, chain: chain, sel: sel, logger: logger}
+	for i := range chain {
+		err := set.assign(n)
+		if err == nil {
+			return set, nil
+		}
+		if i+1 == len(chain) || sel.ctx.Err() != nil {
+			return nil, err
+		}
+		set.current++
+		logFallback(logger, chain[i], chain[i+1], err)
+	}
+	return nil, loadbalancer.ErrNoAccounts
+}
+
+// assign 为当前模型重新创建 n 个 choice 并分配账号，失败时保留原有 choice
+func (s *choiceSet) assign(n int) error {
+	picker := newAccountPicker(s.h, s.target().Model, s.sel)
+	choices := make([]*chatChoice, 0, n)
+	for i := 0; i < n; i++ {
+		c := &chatChoice{index: i}
+		if err := picker.assign(c); err != nil {
+			for _, c := range choices {
+				picker.release(c.account)
+			}
+			return err
+		}
+		choices = append(choices, c)
+	}
+	s.picker, s.choices = picker, choices
+	return nil
+}
+
+// target 当前使用的模型
+func (s *choiceSet) target() modelroute.Target {
+	return s.chain[s.current]
+}
+
+// servedModel 实际服务请求的模型名称；未降级时返回客户端请求的原始名称
+func (s *choiceSet) servedModel(requested string) string {
+	if s.current == 0 {
+		return requested
+	}
+	return s.target().RequestModel
+}
+
+// release 释放所有 choice 占用的账号
+func (s *choiceSet) release() {
+	for _, c := range s.choices {
+		s.picker.release(c.account)
+		c.account = nil
+	}
+}
+
+// committed 流式请求是否已向客户端输出过内容
+func (s *choiceSet) committed() bool {
+	for _, c := range s.choices {
+		if c.emitted || c.finishReason != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// run 并发执行所有 choice；任一 choice 最终失败则取消其余 choice。
+// 当前模型的账号全部失败、且流式请求尚未输出内容时，降级到下一个模型重新执行。
+func (s *choiceSet) run(ctx context.Context, builtPrompt string, limits generationLimits, stream bool, onDelta func(c *chatChoice, delta string), onFinish func(c *chatChoice)) error {
+	// choice 并发输出，之后重新排队的情况不能再写响应头
+	s.sel.closeHeader()
+	for {
+		err := s.runTarget(ctx, builtPrompt, limits, stream, onDelta, onFinish)
+		if err == nil || ctx.Err() != nil || (stream && s.committed()) {
+			return err
+		}
+		if !s.fallback(err) {
+			return err
+		}
+	}
+}
+
+// fallback 释放当前账号并切换到下一个能分配到账号的模型，没有更多模型时返回 false
+func (s *choiceSet) fallback(reason error) bool {
+	n := len(s.choices)
+	s.release()
+	for s.current+1 < len(s.chain) {
+		from := s.target()
+		s.current++
+		logFallback(s.logger, from, s.target(), reason)
+		err := s.assign(n)
+		if err == nil {
+			return true
+		}
+		reason = err
+	}
+	return false
+}
+
+func (s *choiceSet) runTarget(ctx context.Context, builtPrompt string, limits generationLimits, stream bool, onDelta func(c *chatChoice, delta string), onFinish func(c *chatChoice)) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	target := s.target()
+	var mu sync.Mutex
+	var firstErr error
+	var wg sync.WaitGroup
+	for _, c := range s.choices {
+		wg.Add(1)
+		go func(c *chatChoice) {
+			defer wg.Done()
+			err := s.h.runChatChoice(ctx, c, s.picker, builtPrompt, target, limits, s.logger, stream, func(delta string) {
+				onDelta(c, delta)
+			})
+			if err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+				cancel()
+				return
+			}
+			onFinish(c)
+		}(c)
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// outputTokens 所有 choice 的输出 token 之和
+func (s *choiceSet) outputTokens() int {
+	total := 0
+	for _, c := range s.choices {
+		total += c.outputTokens
+	}
+	return total
+}
+
+// runChatChoice 执行单个 choice 的上游请求，失败时排除当前账号并切换重试。
+// 流式请求一旦已向客户端输出内容就不再重试。
+// 达到 max_tokens 或命中 stop 时主动取消上游请求，避免继续占用账号。
+func (h *Handler) runChatChoice(ctx context.Context, c *chatChoice, picker *accountPicker, builtPrompt string, target modelroute.Target, limits generationLimits, logger *debug.Logger, stream bool, onDelta func(string)) error {
+	defer func() {
+		c.outputTokens = c.counter.Tokens()
+		h.recordTokens(c.account, tiktoken.EstimateTextTokens(builtPrompt)+c.outputTokens)
+	}()
+
+	for {
+		attemptCtx, cancelAttempt := context.WithCancel(ctx)
+		attemptStart := time.Now()
+		firstEvent := true
+		err := c.apiClient.WithAgentMode(target.AgentMode).SendRequest(attemptCtx, builtPrompt, []interface{}{}, target.Model, func(msg client.SSEMessage) {
+			if firstEvent {
+				firstEvent = false
+				h.observeLatency(c.account, time.Since(attemptStart))
+			}
+			if c.finishReason != "" || msg.Type != "model" || msg.Event == nil {
+				return
+			}
+			evtType, _ := msg.Event["type"].(string)
+			if evtType == "tool-call" {
+				toolID, _ := msg.Event["toolCallId"].(string)
+				toolName, _ := msg.Event["toolName"].(string)
+				inputStr, _ := msg.Event["input"].(string)
+				if toolID != "" && toolName != "" {
+					c.toolCalls = append(c.toolCalls, choiceToolCall{ID: toolID, Name: toolName, Input: fixToolInput(inputStr)})
+				}
+				return
+			}
+			if evtType != "text-delta" {
+				return
+			}
+			delta, _ := msg.Event["delta"].(string)
+			if delta == "" {
+				return
+			}
+			if c.feed(delta, limits, onDelta) {
+				cancelAttempt()
+			}
+		}, logger)
+		cancelAttempt()
+
+		if c.finishReason != "" {
+			h.reportAccount(c.account, nil)
+			log.Printf("choice %d 达到截断条件 (%s)，已取消上游请求", c.index, c.finishReason)
+			return nil
+		}
+		h.reportAccount(c.account, err)
+		if err == nil {
+			c.emit(c.pending, limits, onDelta)
+			c.pending = ""
+			if c.finishReason == "" {
+				c.finishReason = "stop"
+			}
+			return nil
+		}
+		if ctx.Err() != nil {
+			return err
+		}
+		log.Printf("Error: %v", err)
+
+		if c.account == nil || h.loadBalancer == nil {
+			return err
+		}
+		if stream && c.emitted {
+			log.Printf("账号 %s 请求失败，已向客户端输出内容，不再切换账号", c.account.Name)
+			return err
+		}
+
+		// 丢弃部分结果后切换账号重试
+		c.content.Reset()
+		c.counter.Reset()
+		c.pending = ""
+		c.toolCalls = nil
+		c.emitted = false
+		c.failedIDs = append(c.failedIDs, c.account.ID)
+		log.Printf("账号 %s 请求失败，尝试切换账号 (已排除 %d 个)", c.account.Name, len(c.failedIDs))
+		picker.release(c.account)
+		c.account = nil
+		if retryErr := picker.assign(c); retryErr != nil {
+			log.Printf("无更多可用账号: %v", retryErr)
+			return err
+		}
+		if c.account != nil {
+			log.Printf("切换到账号: %s，重新发送请求", c.account.Name)
+		}
+	}
+}
+
+// feed 处理一段上游增量，返回 true 表示已达到截断条件
+func (c *chatChoice) feed(delta string, limits generationLimits, onDelta func(string)) bool {
+	text := c.pending + delta
+	c.pending = ""
+
+	if idx := indexOfStop(text, limits.stop); idx >= 0 {
+		if !c.emit(text[:idx], limits, onDelta) {
+			c.finishReason = "stop"
+		}
+		return true
+	}
+
+	// 末尾可能是 stop 的前缀，暂不输出
+	keep := stopPrefixLen(text, limits.stop)
+	c.pending = text[len(text)-keep:]
+	return c.emit(text[:len(text)-keep], limits, onDelta)
+}
+
+// emit 输出文本并检查 max_tokens，返回 true 表示已达到长度上限
+func (c *chatChoice) emit(text string, limits generationLimits, onDelta func(string)) bool {
+	if text == "" {
+		return false
+	}
+
+	reached := false
+	if limits.maxTokens > 0 && c.counter.With(text) >= limits.maxTokens {
+		// 只有越过上限的这一段需要截断
+		text = truncateToTokens(&c.counter, text, limits.maxTokens)
+		reached = true
+	}
+
+	if text != "" {
+		c.emitted = true
+		c.content.WriteString(text)
+		c.counter.Add(text)
+		onDelta(text)
+	}
+	if reached {
+		c.finishReason = "length"
+	}
+	return reached
+}
+
+// truncateToTokens 返回 text 的最长前缀，使已输出内容加前缀不超过 maxTokens
+func truncateToTokens(counter *tiktoken.TextCounter, text string, maxTokens int) string {
+	boundaries := make([]int, 0, len(text)+1)
+	for i := range text {
+		boundaries = append(boundaries, i)
+	}
+	boundaries = append(boundaries, len(text))
+
+	lo, hi := 0, len(boundaries)-1
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		if counter.With(text[:boundaries[mid]]) <= maxTokens {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+	return text[:boundaries[lo]]
+}
+
+// indexOfStop 返回 text 中最早出现的 stop 位置，未命中返回 -1
+func indexOfStop(text string, stops []string) int {
+	first := -1
+	for _, stop := range stops {
+		if stop == "" {
+			continue
+		}
+		if idx := strings.Index(text, stop); idx >= 0 && (first < 0 || idx < first) {
+			first = idx
+		}
+	}
+	return first
+}
+
+// stopPrefixLen 返回 text 末尾与某个 stop 前缀重合的最大长度
+func stopPrefixLen(text string, stops []string) int {
+	longest := 0
+	for _, stop := range stops {
+		for k := len(stop) - 1; k > longest; k-- {
+			if strings.HasSuffix(text, stop[:k]) {
+				longest = k
+				break
+			}
+		}
+	}
+	return longest
+}
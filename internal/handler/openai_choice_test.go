@@ -0,0 +1,347 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"orchids-api/internal/fakeupstream"
+	"orchids-api/internal/store"
+)
+
+// feedAll 按 runChatChoice 的方式依次输入上游增量：达到截断条件后停止，
+// 正常结束时输出暂存的 stop 前缀
+func feedAll(c *chatChoice, chunks []string, limits generationLimits) []string {
+	var deltas []string
+	onDelta := func(s string) { deltas = append(deltas, s) }
+	for _, chunk := range chunks {
+		if c.feed(chunk, limits, onDelta) {
+			return deltas
+		}
+	}
+	c.emit(c.pending, limits, onDelta)
+	c.pending = ""
+	if c.finishReason == "" {
+		c.finishReason = "stop"
+	}
+	return deltas
+}
+
+func TestChoiceFeed(t *testing.T) {
+	tests := []struct {
+		name       string
+		chunks     []string
+		limits     generationLimits
+		wantDeltas []string
+		wantFinish string
+	}{
+		{
+			name:       "无截断条件",
+			chunks:     []string{"Hello ", "world"},
+			wantDeltas: []string{"Hello ", "world"},
+			wantFinish: "stop",
+		},
+		{
+			name:       "stop 跨数据块",
+			chunks:     []string{"abc E", "N", "D tail"},
+			limits:     generationLimits{stop: []string{"END"}},
+			wantDeltas: []string{"abc "},
+			wantFinish: "stop",
+		},
+		{
+			name:       "暂存的前缀不是 stop 时继续输出",
+			chunks:     []string{"abc E", "Nx"},
+			limits:     generationLimits{stop: []string{"END"}},
+			wantDeltas: []string{"abc ", "ENx"},
+			wantFinish: "stop",
+		},
+		{
+			name:       "多个 stop 取最早出现的",
+			chunks:     []string{"one ##", "# two STOP\n\nthree"},
+			limits:     generationLimits{stop: []string{"\n\n", "STOP", "###"}},
+			wantDeltas: []string{"one "},
+			wantFinish: "stop",
+		},
+		{
+			name:       "任一 stop 的前缀都会暂存",
+			chunks:     []string{"x ST", "OP"},
+			limits:     generationLimits{stop: []string{"S!", "STOP"}},
+			wantDeltas: []string{"x "},
+			wantFinish: "stop",
+		},
+		{
+			name:       "正常结束时输出暂存的前缀",
+			chunks:     []string{"abc EN"},
+			limits:     generationLimits{stop: []string{"END"}},
+			wantDeltas: []string{"abc ", "EN"},
+			wantFinish: "stop",
+		},
+		{
+			name:       "max_tokens 在数据块中间达到",
+			chunks:     []string{"abcdef", "ghijklmno", "pqr"},
+			limits:     generationLimits{maxTokens: 3},
+			wantDeltas: []string{"abcdef", "ghijk"},
+			wantFinish: "length",
+		},
+		{
+			name:       "max_tokens 按字符截断多字节文本",
+			chunks:     []string{"你好世界再见朋友"},
+			limits:     generationLimits{maxTokens: 1},
+			wantDeltas: []string{"你好世界再"},
+			wantFinish: "length",
+		},
+		{
+			name:       "stop 之前的内容已超过 max_tokens",
+			chunks:     []string{"abcdefghijkEND"},
+			limits:     generationLimits{maxTokens: 2, stop: []string{"END"}},
+			wantDeltas: []string{"abcdefgh"},
+			wantFinish: "length",
+		},
+		{
+			name:       "暂存的前缀在结束时也受 max_tokens 限制",
+			chunks:     []string{"abcd", "efgEN"},
+			limits:     generationLimits{maxTokens: 3, stop: []string{"END"}},
+			wantDeltas: []string{"abcd", "efg", "EN"},
+			wantFinish: "length",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &chatChoice{}
+			deltas := feedAll(c, tt.chunks, tt.limits)
+			if !reflect.DeepEqual(deltas, tt.wantDeltas) {
+				t.Errorf("deltas = %q, want %q", deltas, tt.wantDeltas)
+			}
+			if c.finishReason != tt.wantFinish {
+				t.Errorf("finish_reason = %q, want %q", c.finishReason, tt.wantFinish)
+			}
+			if got, want := c.content.String(), strings.Join(tt.wantDeltas, ""); got != want {
+				t.Errorf("content = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestIndexOfStop(t *testing.T) {
+	tests := []struct {
+		text  string
+		stops []string
+		want  int
+	}{
+		{"hello world", nil, -1},
+		{"hello world", []string{""}, -1},
+		{"hello world", []string{"world", "o"}, 4},
+		{"hello world", []string{"xyz"}, -1},
+	}
+	for _, tt := range tests {
+		if got := indexOfStop(tt.text, tt.stops); got != tt.want {
+			t.Errorf("indexOfStop(%q, %q) = %d, want %d", tt.text, tt.stops, got, tt.want)
+		}
+	}
+}
+
+func TestStopPrefixLen(t *testing.T) {
+	tests := []struct {
+		text  string
+		stops []string
+		want  int
+	}{
+		{"abc", []string{"END"}, 0},
+		{"abcE", []string{"END"}, 1},
+		{"abcEN", []string{"END"}, 2},
+		{"abcEN", []string{"N!", "END"}, 2},
+		// 完整的 stop 由 indexOfStop 处理，这里只看严格前缀
+		{"abcEND", []string{"END"}, 0},
+		{"", []string{"END"}, 0},
+	}
+	for _, tt := range tests {
+		if got := stopPrefixLen(tt.text, tt.stops); got != tt.want {
+			t.Errorf("stopPrefixLen(%q, %q) = %d, want %d", tt.text, tt.stops, got, tt.want)
+		}
+	}
+}
+
+func TestChatFailover(t *testing.T) {
+	tests := []struct {
+		name   string
+		script fakeupstream.Response
+		stream bool
+		// wantSwitch 是否切换到另一个账号，wantRequests 为上游收到的对话请求数
+		wantSwitch   bool
+		wantRequests int
+		wantStatus   int
+		wantBody     string
+	}{
+		{
+			name:         "429 后切换账号",
+			script:       fakeupstream.Response{Times: 1, Status: http.StatusTooManyRequests, Body: "rate limited"},
+			wantSwitch:   true,
+			wantRequests: 2,
+			wantStatus:   http.StatusOK,
+			wantBody:     fakeupstream.DefaultText,
+		},
+		{
+			// client 遇到 401 会重新获取 token 重试一次，仍然 401 时切换账号
+			name:         "401 后切换账号",
+			script:       fakeupstream.Response{Times: 2, Status: http.StatusUnauthorized, Body: "unauthorized"},
+			wantSwitch:   true,
+			wantRequests: 3,
+			wantStatus:   http.StatusOK,
+			wantBody:     fakeupstream.DefaultText,
+		},
+		{
+			name:         "流式请求在输出前失败时切换账号",
+			script:       fakeupstream.Response{Times: 1, Status: http.StatusServiceUnavailable, Body: "overloaded"},
+			stream:       true,
+			wantSwitch:   true,
+			wantRequests: 2,
+			wantStatus:   http.StatusOK,
+			wantBody:     "fakeupstream.",
+		},
+		{
+			name:         "流式请求已输出内容后不再切换账号",
+			script:       fakeupstream.Response{Times: 1, Text: "partial output", Abort: true},
+			stream:       true,
+			wantRequests: 1,
+			wantStatus:   http.StatusOK,
+			wantBody:     `"content":"partial "`,
+		},
+		{
+			name:         "非流式请求中途失败时丢弃部分结果并切换账号",
+			script:       fakeupstream.Response{Times: 1, Text: "partial output", Abort: true},
+			wantSwitch:   true,
+			wantRequests: 2,
+			wantStatus:   http.StatusOK,
+			wantBody:     fakeupstream.DefaultText,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newTestGateway(t, &store.Account{Name: "a", AgentMode: "agent-a"}, &store.Account{Name: "b", AgentMode: "agent-b"})
+			g.fake.Script(tt.script)
+
+			body := fmt.Sprintf(`{"model":"claude-opus-4-5","stream":%v,"messages":[{"role":"user","content":"hi"}]}`, tt.stream)
+			rec := g.serve(g.h.HandleOpenAIChat, http.MethodPost, "/v1/chat/completions", body)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantBody) {
+				t.Errorf("body 缺少 %q: %s", tt.wantBody, rec.Body.String())
+			}
+
+			modes := g.agentModes()
+			if len(modes) != tt.wantRequests {
+				t.Fatalf("upstream requests = %v, want %d", modes, tt.wantRequests)
+			}
+			if switched := modes[0] != modes[len(modes)-1]; switched != tt.wantSwitch {
+				t.Errorf("upstream requests = %v, switched = %v, want %v", modes, switched, tt.wantSwitch)
+			}
+		})
+	}
+}
+
+func TestChatFailoverStreamErrorAfterOutput(t *testing.T) {
+	// 已输出内容后上游失败，错误以数据块下发，随后结束流
+	g := newTestGateway(t, &store.Account{Name: "a"}, &store.Account{Name: "b"})
+	g.fake.Script(fakeupstream.Response{Times: 1, Text: "partial output", Abort: true})
+
+	rec := g.serve(g.h.HandleOpenAIChat, http.MethodPost, "/v1/chat/completions",
+		`{"model":"claude-opus-4-5","stream":true,"messages":[{"role":"user","content":"hi"}]}`)
+	out := rec.Body.String()
+	errAt, doneAt := strings.Index(out, `"error"`), strings.Index(out, "data: [DONE]")
+	if errAt < 0 || doneAt < errAt || strings.Contains(out, `"finish_reason":"stop"`) {
+		t.Errorf("unexpected stream: %s", out)
+	}
+}
+
+func TestChatModelFallback(t *testing.T) {
+	routes := []store.ModelRoute{
+		{MatchType: store.MatchExact, Pattern: "primary", UpstreamModel: "up-primary", Fallbacks: []string{"second", "third"}, Priority: 10, Enabled: true},
+		{MatchType: store.MatchExact, Pattern: "second", UpstreamModel: "up-second", Priority: 10, Enabled: true},
+		{MatchType: store.MatchExact, Pattern: "third", UpstreamModel: "up-third", Priority: 10, Enabled: true},
+	}
+	tests := []struct {
+		name   string
+		script []fakeupstream.Response
+		models []string // 账号可服务的上游模型，为空表示全部
+		stream bool
+		// wantModels 为上游依次收到的模型，wantServed 为 X-Served-Model
+		wantModels []string
+		wantStatus int
+		wantServed string
+	}{
+		{
+			name:       "未降级",
+			wantModels: []string{"up-primary"},
+			wantStatus: http.StatusOK,
+			wantServed: "primary",
+		},
+		{
+			name:       "请求模型失败后按顺序降级",
+			script:     []fakeupstream.Response{{Times: 2, Status: http.StatusInternalServerError, Body: "boom"}},
+			wantModels: []string{"up-primary", "up-second", "up-third"},
+			wantStatus: http.StatusOK,
+			wantServed: "third",
+		},
+		{
+			name:       "流式请求在输出前失败时降级",
+			script:     []fakeupstream.Response{{Times: 1, Status: http.StatusServiceUnavailable, Body: "overloaded"}},
+			stream:     true,
+			wantModels: []string{"up-primary", "up-second"},
+			wantStatus: http.StatusOK,
+			wantServed: "second",
+		},
+		{
+			name:       "流式请求已输出内容后不再降级",
+			script:     []fakeupstream.Response{{Times: 1, Text: "partial output", Abort: true}},
+			stream:     true,
+			wantModels: []string{"up-primary"},
+			wantStatus: http.StatusOK,
+			wantServed: "primary",
+		},
+		{
+			name:       "没有账号能服务请求模型时直接降级",
+			models:     []string{"up-third"},
+			wantModels: []string{"up-third"},
+			wantStatus: http.StatusOK,
+			wantServed: "third",
+		},
+		{
+			name:       "所有模型都失败",
+			script:     []fakeupstream.Response{{Status: http.StatusInternalServerError, Body: "boom"}},
+			wantModels: []string{"up-primary", "up-second", "up-third"},
+			wantStatus: http.StatusServiceUnavailable,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newTestGateway(t, &store.Account{Name: "a", Models: tt.models})
+			g.setRoutes(t, routes...)
+			// 不使用默认配置，账号用尽即降级
+			g.h.client = nil
+			g.fake.Script(tt.script...)
+
+			body := fmt.Sprintf(`{"model":"primary","stream":%v,"messages":[{"role":"user","content":"hi"}]}`, tt.stream)
+			rec := g.serve(g.h.HandleOpenAIChat, http.MethodPost, "/v1/chat/completions", body)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+
+			var models []string
+			for _, req := range g.fake.Requests() {
+				models = append(models, req.Model)
+			}
+			if !reflect.DeepEqual(models, tt.wantModels) {
+				t.Errorf("upstream models = %v, want %v", models, tt.wantModels)
+			}
+			if got := rec.Header().Get(servedModelHeader); got != tt.wantServed {
+				t.Errorf("%s = %q, want %q", servedModelHeader, got, tt.wantServed)
+			}
+			if tt.wantServed != "" && !strings.Contains(rec.Body.String(), fmt.Sprintf(`"model":%q`, tt.wantServed)) {
+				t.Errorf("body model != %q: %s", tt.wantServed, rec.Body.String())
+			}
+		})
+	}
+}
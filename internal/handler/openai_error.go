@@ -0,0 +1,121 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"orchids-api/internal/client"
+	"orchids-api/internal/loadbalancer"
+)
+
+// OpenAIErrorDetail OpenAI 错误详情
+type OpenAIErrorDetail struct {
+	Message string  `json:"message"`
+	Type    string  `json:"type"`
+	Param   *string `json:"param"`
+	Code    *string `json:"code"`
+}
+
+// OpenAIErrorResponse OpenAI 错误响应格式
+type OpenAIErrorResponse struct {
+	Error OpenAIErrorDetail `json:"error"`
+}
+
+// openAIError 带 HTTP 状态码的 OpenAI 错误
+type openAIError struct {
+	Status int
+	Detail OpenAIErrorDetail
+}
+
+func (e *openAIError) Error() string {
+	return e.Detail.Message
+}
+
+func newOpenAIError(status int, errType, message, param, code string) *openAIError {
+	detail := OpenAIErrorDetail{Message: message, Type: errType}
+	if param != "" {
+		detail.Param = &param
+	}
+	if code != "" {
+		detail.Code = &code
+	}
+	return &openAIError{Status: status, Detail: detail}
+}
+
+func errInvalidRequest(message, param string) *openAIError {
+	return newOpenAIError(http.StatusBadRequest, "invalid_request_error", message, param, "")
+}
+
+func errMethodNotAllowed() *openAIError {
+	return newOpenAIError(http.StatusMethodNotAllowed, "invalid_request_error", "Method not allowed", "", "method_not_allowed")
+}
+
+// toOpenAIError 将内部错误映射为 OpenAI 错误
+func toOpenAIError(err error) *openAIError {
+	var oe *openAIError
+	if errors.As(err, &oe) {
+		return oe
+	}
+
+	if errors.Is(err, loadbalancer.ErrNoAccounts) {
+		return newOpenAIError(http.StatusServiceUnavailable, "server_error", "No upstream account is currently available", "", "service_unavailable")
+	}
+	if errors.Is(err, loadbalancer.ErrAccountsBusy) {
+		return newOpenAIError(http.StatusTooManyRequests, "rate_limit_error", "All upstream accounts are at their concurrency limit", "", "concurrency_limit_exceeded")
+	}
+	if errors.Is(err, loadbalancer.ErrQuotaExhausted) {
+		return newOpenAIError(http.StatusTooManyRequests, "rate_limit_error", "All upstream accounts have exhausted their quota", "", "insufficient_quota")
+	}
+	if errors.Is(err, loadbalancer.ErrQueueFull) {
+		return newOpenAIError(http.StatusTooManyRequests, "rate_limit_error", "Too many requests are waiting for an upstream account", "", "queue_full")
+	}
+	if errors.Is(err, loadbalancer.ErrQueueTimeout) {
+		return newOpenAIError(http.StatusTooManyRequests, "rate_limit_error", "Timed out waiting for an available upstream account", "", "queue_timeout")
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return newOpenAIError(http.StatusServiceUnavailable, "server_error", "Upstream request timed out", "", "timeout")
+	}
+
+	var ue *client.UpstreamError
+	if errors.As(err, &ue) {
+		message := fmt.Sprintf("Upstream request failed with status %d", ue.StatusCode)
+		if ue.Op == "token" {
+			message = fmt.Sprintf("Upstream token request failed with status %d", ue.StatusCode)
+		}
+		if body := strings.TrimSpace(ue.Body); body != "" {
+			message += ": " + body
+		}
+		switch {
+		case ue.StatusCode == http.StatusTooManyRequests:
+			return newOpenAIError(http.StatusTooManyRequests, "rate_limit_error", message, "", "rate_limit_exceeded")
+		case ue.StatusCode == http.StatusBadRequest:
+			return newOpenAIError(http.StatusBadRequest, "invalid_request_error", message, "", "")
+		case ue.StatusCode == http.StatusUnauthorized || ue.StatusCode == http.StatusForbidden:
+			// 账号凭据失效属于网关侧问题，不应让客户端以为自己的 key 错误
+			return newOpenAIError(http.StatusServiceUnavailable, "server_error", message, "", "upstream_auth_failed")
+		default:
+			return newOpenAIError(http.StatusServiceUnavailable, "server_error", message, "", "upstream_error")
+		}
+	}
+
+	return newOpenAIError(http.StatusInternalServerError, "server_error", err.Error(), "", "")
+}
+
+// writeOpenAIError 写出 OpenAI 格式的错误响应
+func writeOpenAIError(w http.ResponseWriter, err error) {
+	oe := toOpenAIError(err)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(oe.Status)
+	json.NewEncoder(w).Encode(OpenAIErrorResponse{Error: oe.Detail})
+}
+
+// openAIErrorChunk 流已开始后使用的错误数据块
+func openAIErrorChunk(err error) string {
+	data, _ := json.Marshal(OpenAIErrorResponse{Error: toOpenAIError(err).Detail})
+	return string(data)
+}
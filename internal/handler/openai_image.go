@@ -0,0 +1,194 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"time"
+
+	"orchids-api/internal/client"
+	"orchids-api/internal/imageutil"
+)
+
+// maxImageUpload 单个上传文件的大小上限
+const maxImageUpload = 25 << 20
+
+// maxImageDimension size 参数允许的最大边长
+const maxImageDimension = 4096
+
+// imageUploadForm edits / variations 的 multipart 表单
+type imageUploadForm struct {
+	Prompt         string
+	N              int
+	Size           string
+	ResponseFormat string
+	Image          []byte
+	Mask           []byte
+}
+
+// HandleOpenAIImageEdits 处理 /v1/images/edits 请求
+func (h *Handler) HandleOpenAIImageEdits(w http.ResponseWriter, r *http.Request) {
+	h.serveImageUpload(w, r, true)
+}
+
+// HandleOpenAIImageVariations 处理 /v1/images/variations 请求
+func (h *Handler) HandleOpenAIImageVariations(w http.ResponseWriter, r *http.Request) {
+	h.serveImageUpload(w, r, false)
+}
+
+func (h *Handler) serveImageUpload(w http.ResponseWriter, r *http.Request, edit bool) {
+	if r.Method != http.MethodPost {
+		writeOpenAIError(w, errMethodNotAllowed())
+		return
+	}
+
+	form, err := parseImageUploadForm(w, r, edit)
+	if err != nil {
+		writeOpenAIError(w, err)
+		return
+	}
+
+	var generate func(context.Context, *client.Client) (string, error)
+	if edit {
+		generate = func(ctx context.Context, apiClient *client.Client) (string, error) {
+			return apiClient.EditImage(ctx, form.Prompt, form.Size, form.Image, form.Mask)
+		}
+	} else {
+		generate = func(ctx context.Context, apiClient *client.Client) (string, error) {
+			return apiClient.CreateImageVariation(ctx, form.Size, form.Image)
+		}
+	}
+
+	data, err := h.generateImages(w, r, form.N, form.ResponseFormat, generate)
+	if err != nil {
+		writeOpenAIError(w, err)
+		return
+	}
+
+	response := OpenAIImageResponse{
+		Created: time.Now().Unix(),
+		Data:    data,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+
+	if edit {
+		log.Printf("Image edited: %d (%s)", len(data), form.ResponseFormat)
+	} else {
+		log.Printf("Image variations generated: %d (%s)", len(data), form.ResponseFormat)
+	}
+}
+
+// parseImageUploadForm 解析并校验表单，图片统一解码、缩放并转为 PNG
+func parseImageUploadForm(w http.ResponseWriter, r *http.Request, edit bool) (*imageUploadForm, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, 3*maxImageUpload)
+	if err := r.ParseMultipartForm(maxImageUpload); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return nil, newOpenAIError(http.StatusRequestEntityTooLarge, "invalid_request_error", "Request body is too large", "", "request_too_large")
+		}
+		return nil, errInvalidRequest("Expected a multipart/form-data body: "+err.Error(), "")
+	}
+
+	form := &imageUploadForm{
+		Prompt:         r.FormValue("prompt"),
+		Size:           r.FormValue("size"),
+		ResponseFormat: r.FormValue("response_format"),
+		N:              1,
+	}
+
+	if edit && form.Prompt == "" {
+		return nil, errInvalidRequest("'prompt' is a required property", "prompt")
+	}
+	if v := r.FormValue("n"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxImages {
+			return nil, errInvalidRequest(fmt.Sprintf("%s is not a valid value for 'n' - must be between 1 and %d", v, maxImages), "n")
+		}
+		form.N = n
+	}
+	if form.ResponseFormat == "" {
+		form.ResponseFormat = "url"
+	}
+	if form.ResponseFormat != "url" && form.ResponseFormat != "b64_json" {
+		return nil, errInvalidRequest("'response_format' must be 'url' or 'b64_json'", "response_format")
+	}
+	if form.Size == "" {
+		form.Size = "1024x1024"
+	}
+	width, height, err := imageutil.ParseSize(form.Size)
+	if err != nil || width > maxImageDimension || height > maxImageDimension {
+		return nil, errInvalidRequest(fmt.Sprintf("Invalid 'size' %q, expected WIDTHxHEIGHT up to %dx%d", form.Size, maxImageDimension, maxImageDimension), "size")
+	}
+
+	// gpt-image 客户端会以 image[] 上传
+	raw, err := readFormFile(r.MultipartForm, "image", "image[]")
+	if err != nil {
+		return nil, errInvalidRequest(err.Error(), "image")
+	}
+	if raw == nil {
+		return nil, errInvalidRequest("'image' is a required property", "image")
+	}
+	img, _, err := imageutil.Decode(raw)
+	if err != nil {
+		return nil, errInvalidRequest("Invalid 'image': "+err.Error(), "image")
+	}
+	img = imageutil.Fit(img, width, height)
+	if form.Image, err = imageutil.EncodePNG(img); err != nil {
+		return nil, err
+	}
+
+	if !edit {
+		return form, nil
+	}
+
+	rawMask, err := readFormFile(r.MultipartForm, "mask")
+	if err != nil {
+		return nil, errInvalidRequest(err.Error(), "mask")
+	}
+	if rawMask == nil {
+		return form, nil
+	}
+	mask, _, err := imageutil.Decode(rawMask)
+	if err != nil {
+		return nil, errInvalidRequest("Invalid 'mask': "+err.Error(), "mask")
+	}
+	// 遮罩与图片尺寸保持一致
+	if mask, err = imageutil.PrepareMask(mask, img.Bounds().Dx(), img.Bounds().Dy()); err != nil {
+		return nil, errInvalidRequest("Invalid 'mask': "+err.Error(), "mask")
+	}
+	if form.Mask, err = imageutil.EncodePNG(mask); err != nil {
+		return nil, err
+	}
+	return form, nil
+}
+
+// readFormFile 读取第一个存在的文件字段，字段都不存在时返回 nil
+func readFormFile(form *multipart.Form, names ...string) ([]byte, error) {
+	if form == nil {
+		return nil, nil
+	}
+	for _, name := range names {
+		files := form.File[name]
+		if len(files) == 0 {
+			continue
+		}
+		if files[0].Size > maxImageUpload {
+			return nil, fmt.Errorf("'%s' exceeds the %d MB limit", name, maxImageUpload>>20)
+		}
+		f, err := files[0].Open()
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return io.ReadAll(f)
+	}
+	return nil, nil
+}
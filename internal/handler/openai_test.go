@@ -0,0 +1,144 @@
+package handler
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+
+	"orchids-api/internal/fakeupstream"
+	"orchids-api/internal/store"
+	"orchids-api/internal/tiktoken"
+)
+
+func TestChatChoices(t *testing.T) {
+	g := newTestGateway(t, &store.Account{Name: "a", AgentMode: "agent-a"}, &store.Account{Name: "b", AgentMode: "agent-b"})
+	g.fake.Script(fakeupstream.Response{Times: 1, Text: "first reply text"})
+
+	rec := g.serve(g.h.HandleOpenAIChat, http.MethodPost, "/v1/chat/completions",
+		`{"model":"claude-opus-4-5","n":2,"messages":[{"role":"user","content":"hi"}]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp OpenAIResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(resp.Choices) != 2 {
+		t.Fatalf("got %d choices, want 2", len(resp.Choices))
+	}
+	var contents []string
+	completion := 0
+	for i, choice := range resp.Choices {
+		if choice.Index != i || *choice.FinishReason != "stop" {
+			t.Errorf("choice %d: index %d finish_reason %s", i, choice.Index, *choice.FinishReason)
+		}
+		content, _ := choice.Message.Content.(string)
+		contents = append(contents, content)
+		completion += tiktoken.EstimateTextTokens(content)
+	}
+	sort.Strings(contents)
+	if want := []string{fakeupstream.DefaultText, "first reply text"}; contents[0] != want[0] || contents[1] != want[1] {
+		t.Errorf("contents = %q, want %q", contents, want)
+	}
+
+	// prompt 只计一次，completion 为所有 choice 之和
+	u := resp.Usage
+	if u.CompletionTokens != completion || u.PromptTokens <= 0 || u.TotalTokens != u.PromptTokens+u.CompletionTokens {
+		t.Errorf("usage = %+v, want completion %d", u, completion)
+	}
+
+	// 账号足够时每个 choice 使用不同账号
+	if modes := g.agentModes(); len(modes) != 2 || modes[0] == modes[1] {
+		t.Errorf("upstream requests = %v, want one per account", modes)
+	}
+}
+
+func TestChatChoicesStream(t *testing.T) {
+	g := newTestGateway(t, &store.Account{Name: "a"}, &store.Account{Name: "b"})
+	g.fake.Script(fakeupstream.Response{Text: "one two three four", DelayMS: 20})
+
+	rec := g.serve(g.h.HandleOpenAIChat, http.MethodPost, "/v1/chat/completions",
+		`{"model":"claude-opus-4-5","n":2,"stream":true,"stream_options":{"include_usage":true},"messages":[{"role":"user","content":"hi"}]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// 按到达顺序记录每个数据块所属的 choice
+	var order []int
+	content := map[int]string{}
+	finished := map[int]int{}
+	var usage *OpenAIUsage
+	scanner := bufio.NewScanner(rec.Body)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok || data == "[DONE]" {
+			continue
+		}
+		var chunk OpenAIStreamChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			t.Fatalf("invalid chunk %s: %v", data, err)
+		}
+		if chunk.Usage != nil {
+			usage = chunk.Usage
+		}
+		for _, choice := range chunk.Choices {
+			if choice.Delta != nil && choice.Delta.Content != "" {
+				order = append(order, choice.Index)
+				content[choice.Index] += choice.Delta.Content
+			}
+			if choice.FinishReason != nil {
+				finished[choice.Index]++
+			}
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		if content[i] != "one two three four" || finished[i] != 1 {
+			t.Errorf("choice %d: content %q, %d finish chunks", i, content[i], finished[i])
+		}
+	}
+	// 两个 choice 并发输出，数据块交错到达
+	if switches := countSwitches(order); switches < 2 {
+		t.Errorf("chunk order = %v, want interleaved choices", order)
+	}
+	if want := 2 * tiktoken.EstimateTextTokens("one two three four"); usage == nil || usage.CompletionTokens != want {
+		t.Errorf("usage = %+v, want completion %d", usage, want)
+	}
+}
+
+// countSwitches 相邻两个数据块属于不同 choice 的次数
+func countSwitches(order []int) int {
+	n := 0
+	for i := 1; i < len(order); i++ {
+		if order[i] != order[i-1] {
+			n++
+		}
+	}
+	return n
+}
+
+func TestChatChoicesLimit(t *testing.T) {
+	g := newTestGateway(t, &store.Account{Name: "a"})
+	for _, n := range []int{-1, maxChoices + 1} {
+		rec := g.serve(g.h.HandleOpenAIChat, http.MethodPost, "/v1/chat/completions",
+			fmt.Sprintf(`{"model":"claude-opus-4-5","n":%d,"messages":[{"role":"user","content":"hi"}]}`, n))
+		var body struct {
+			Error struct {
+				Type  string `json:"type"`
+				Param string `json:"param"`
+			} `json:"error"`
+		}
+		json.Unmarshal(rec.Body.Bytes(), &body)
+		if rec.Code != http.StatusBadRequest || body.Error.Param != "n" || body.Error.Type != "invalid_request_error" {
+			t.Errorf("n=%d: status %d body %s", n, rec.Code, rec.Body.String())
+		}
+	}
+	if got := len(g.fake.Requests()); got != 0 {
+		t.Errorf("got %d upstream requests, want 0", got)
+	}
+}
@@ -0,0 +1,287 @@
+package handler
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"orchids-api/internal/client"
+	"orchids-api/internal/store"
+	"orchids-api/internal/videojob"
+)
+
+// OpenAIVideoJob OpenAI 视频任务对象
+type OpenAIVideoJob struct {
+	ID          string               `json:"id"`
+	Object      string               `json:"object"`
+	Model       string               `json:"model"`
+	Status      string               `json:"status"`
+	Progress    int                  `json:"progress"`
+	CreatedAt   int64                `json:"created_at"`
+	CompletedAt *int64               `json:"completed_at"`
+	Size        string               `json:"size,omitempty"`
+	Seconds     string               `json:"seconds,omitempty"`
+	Prompt      string               `json:"prompt"`
+	Error       *OpenAIVideoJobError `json:"error,omitempty"`
+}
+
+type OpenAIVideoJobError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// SetVideoJobs 设置异步视频任务管理器
+func (h *Handler) SetVideoJobs(m *videojob.Manager) {
+	h.videoJobs = m
+}
+
+// HandleOpenAIVideoJobs 处理 POST /v1/videos，创建异步视频任务
+func (h *Handler) HandleOpenAIVideoJobs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeOpenAIError(w, errMethodNotAllowed())
+		return
+	}
+	if h.videoJobs == nil {
+		writeOpenAIError(w, newOpenAIError(http.StatusServiceUnavailable, "server_error", "Video jobs are not enabled", "", "service_unavailable"))
+		return
+	}
+
+	prompt, opts, err := parseVideoRequest(w, r)
+	if err != nil {
+		writeOpenAIError(w, err)
+		return
+	}
+
+	job, err := h.videoJobs.Submit(prompt, opts)
+	if err != nil {
+		if errors.Is(err, videojob.ErrQueueFull) {
+			writeOpenAIError(w, newOpenAIError(http.StatusTooManyRequests, "rate_limit_error", "Too many pending video jobs, please retry later", "", "rate_limit_exceeded"))
+			return
+		}
+		writeOpenAIError(w, err)
+		return
+	}
+
+	writeVideoJob(w, http.StatusOK, job)
+}
+
+// HandleOpenAIVideoJob 处理 /v1/videos/{id}、/v1/videos/{id}/content 与 /v1/videos/{id}/cancel
+func (h *Handler) HandleOpenAIVideoJob(w http.ResponseWriter, r *http.Request) {
+	if h.videoJobs == nil {
+		writeOpenAIError(w, newOpenAIError(http.StatusServiceUnavailable, "server_error", "Video jobs are not enabled", "", "service_unavailable"))
+		return
+	}
+
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/v1/videos/"), "/")
+	id, action, _ := strings.Cut(path, "/")
+	if id == "" {
+		writeOpenAIError(w, newOpenAIError(http.StatusNotFound, "invalid_request_error", "Video not found", "", "not_found"))
+		return
+	}
+
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		job, err := h.videoJobs.Get(id)
+		if err != nil {
+			writeVideoJobError(w, err)
+			return
+		}
+		writeVideoJob(w, http.StatusOK, job)
+
+	case (action == "" && r.Method == http.MethodDelete) || (action == "cancel" && r.Method == http.MethodPost):
+		job, err := h.videoJobs.Cancel(id)
+		if err != nil {
+			writeVideoJobError(w, err)
+			return
+		}
+		writeVideoJob(w, http.StatusOK, job)
+
+	case action == "content" && r.Method == http.MethodGet:
+		job, err := h.videoJobs.Get(id)
+		if err != nil {
+			writeVideoJobError(w, err)
+			return
+		}
+		h.serveVideoContent(w, r, job)
+
+	case action == "" || action == "cancel" || action == "content":
+		writeOpenAIError(w, errMethodNotAllowed())
+
+	default:
+		writeOpenAIError(w, newOpenAIError(http.StatusNotFound, "invalid_request_error", "Unknown video endpoint: "+action, "", "not_found"))
+	}
+}
+
+// serveVideoContent 代理下载已完成任务的视频
+func (h *Handler) serveVideoContent(w http.ResponseWriter, r *http.Request, job *store.VideoJob) {
+	if job.Status != store.VideoJobCompleted || job.ResultURL == "" {
+		writeOpenAIError(w, newOpenAIError(http.StatusConflict, "invalid_request_error", "Video is not ready, current status: "+job.Status, "", "video_not_ready"))
+		return
+	}
+
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, job.ResultURL, nil)
+	if err != nil {
+		writeOpenAIError(w, err)
+		return
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Printf("视频任务 %s 下载失败: %v", job.ID, err)
+		writeOpenAIError(w, newOpenAIError(http.StatusBadGateway, "server_error", "Failed to fetch video content", "", "upstream_error"))
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("视频任务 %s 下载失败: status %d", job.ID, resp.StatusCode)
+		writeOpenAIError(w, newOpenAIError(http.StatusBadGateway, "server_error", "Failed to fetch video content", "", "upstream_error"))
+		return
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "video/mp4"
+	}
+	w.Header().Set("Content-Type", contentType)
+	if length := resp.Header.Get("Content-Length"); length != "" {
+		w.Header().Set("Content-Length", length)
+	}
+	io.Copy(w, resp.Body)
+}
+
+// parseVideoRequest 解析 JSON 或 multipart/表单请求，并按模型能力校验参数
+func parseVideoRequest(w http.ResponseWriter, r *http.Request) (string, client.VideoOptions, error) {
+	var req OpenAIVideoRequest
+	var reference []byte
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	switch mediaType {
+	case "multipart/form-data", "application/x-www-form-urlencoded":
+		r.Body = http.MaxBytesReader(w, r.Body, 2*maxImageUpload)
+		if err := r.ParseMultipartForm(maxImageUpload); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+			return "", client.VideoOptions{}, errInvalidRequest("Invalid form body: "+err.Error(), "")
+		}
+		req.Model = r.FormValue("model")
+		req.Prompt = r.FormValue("prompt")
+		req.Size = r.FormValue("size")
+		req.AspectRatio = r.FormValue("aspect_ratio")
+		req.InputReference = r.FormValue("input_reference")
+		for _, field := range []struct {
+			param  string
+			target *flexInt
+		}{{"seconds", &req.Seconds}, {"duration", &req.Duration}, {"fps", &req.FPS}} {
+			if v := r.FormValue(field.param); v != "" {
+				if err := field.target.UnmarshalJSON([]byte(v)); err != nil {
+					return "", client.VideoOptions{}, errInvalidRequest(fmt.Sprintf("Invalid '%s': %v", field.param, err), field.param)
+				}
+			}
+		}
+		if v := r.FormValue("n"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return "", client.VideoOptions{}, errInvalidRequest("Invalid 'n': "+v, "n")
+			}
+			req.N = n
+		}
+		if v := r.FormValue("seed"); v != "" {
+			seed, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return "", client.VideoOptions{}, errInvalidRequest("Invalid 'seed': "+v, "seed")
+			}
+			req.Seed = &seed
+		}
+		file, err := readFormFile(r.MultipartForm, "input_reference")
+		if err != nil {
+			return "", client.VideoOptions{}, errInvalidRequest(err.Error(), "input_reference")
+		}
+		reference = file
+	default:
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return "", client.VideoOptions{}, errInvalidRequest("Invalid request body: "+err.Error(), "")
+		}
+	}
+
+	if req.Prompt == "" {
+		return "", client.VideoOptions{}, errInvalidRequest("'prompt' is a required property", "prompt")
+	}
+
+	if reference == nil && req.InputReference != "" {
+		data, err := loadReferenceImage(req.InputReference)
+		if err != nil {
+			return "", client.VideoOptions{}, err
+		}
+		reference = data
+	}
+
+	opts, err := resolveVideoOptions(&req, reference)
+	if err != nil {
+		return "", client.VideoOptions{}, err
+	}
+	return req.Prompt, opts, nil
+}
+
+// loadReferenceImage 读取 data URL 形式的参考图。不下载客户端给出的 URL，避免服务端被用来访问内网地址
+func loadReferenceImage(ref string) ([]byte, error) {
+	if !strings.HasPrefix(ref, "data:") {
+		return nil, errInvalidRequest("'input_reference' must be a multipart file or a base64 data URL", "input_reference")
+	}
+	_, encoded, ok := strings.Cut(ref, ";base64,")
+	if !ok {
+		return nil, errInvalidRequest("'input_reference' must be a multipart file or a base64 data URL", "input_reference")
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errInvalidRequest("Invalid base64 in 'input_reference'", "input_reference")
+	}
+	return data, nil
+}
+
+func toOpenAIVideoJob(job *store.VideoJob) OpenAIVideoJob {
+	out := OpenAIVideoJob{
+		ID:        job.ID,
+		Object:    "video",
+		Model:     job.Model,
+		Status:    job.Status,
+		Progress:  job.Progress,
+		CreatedAt: job.CreatedAt.Unix(),
+		Prompt:    job.Prompt,
+	}
+
+	opts := videoOptionsFromJob(job.Params)
+	out.Size = opts.Size
+	if opts.Seconds > 0 {
+		out.Seconds = strconv.Itoa(opts.Seconds)
+	}
+	if !job.CompletedAt.IsZero() {
+		completedAt := job.CompletedAt.Unix()
+		out.CompletedAt = &completedAt
+	}
+	if job.Status == store.VideoJobFailed {
+		out.Error = &OpenAIVideoJobError{Code: "video_generation_failed", Message: job.Error}
+	}
+	return out
+}
+
+func writeVideoJob(w http.ResponseWriter, status int, job *store.VideoJob) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(toOpenAIVideoJob(job))
+}
+
+func writeVideoJobError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, videojob.ErrNotFound):
+		writeOpenAIError(w, newOpenAIError(http.StatusNotFound, "invalid_request_error", "Video not found", "", "not_found"))
+	case errors.Is(err, videojob.ErrFinished):
+		writeOpenAIError(w, newOpenAIError(http.StatusConflict, "invalid_request_error", "Video job has already finished", "", "video_already_finished"))
+	default:
+		writeOpenAIError(w, err)
+	}
+}
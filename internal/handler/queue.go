@@ -0,0 +1,113 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"orchids-api/internal/loadbalancer"
+	"orchids-api/internal/store"
+)
+
+// 排队相关的请求头与响应头
+const (
+	priorityHeader     = "X-Request-Priority" // 整数，越大越先分配，默认 0
+	queueTimeoutHeader = "X-Queue-Timeout"    // 最长排队秒数，不能超过 QUEUE_TIMEOUT
+	queueDepthHeader   = "X-Queue-Depth"      // 入队时的队列长度，未排队为 0
+	queueWaitHeader    = "X-Queue-Wait-Ms"    // 排队耗时
+)
+
+// accountSelector 请求级的账号选择：携带会话、分组与排队参数，排队情况写入响应头
+type accountSelector struct {
+	h         *Handler
+	ctx       context.Context
+	session   string
+	group     string
+	spillover bool
+	priority  int
+	timeout   time.Duration
+
+	mu     sync.Mutex
+	header http.Header // 响应开始前有效，closeHeader 后不再写入
+	depth  int
+	wait   time.Duration
+}
+
+// newAccountSelector model 为客户端请求的模型，用于匹配账号分组规则
+func (h *Handler) newAccountSelector(w http.ResponseWriter, r *http.Request, model, session string) *accountSelector {
+	sel := &accountSelector{h: h, ctx: r.Context(), session: session, header: w.Header()}
+	sel.group, sel.spillover = h.accountGroup(r, model)
+	if n, err := strconv.Atoi(r.Header.Get(priorityHeader)); err == nil {
+		sel.priority = n
+	}
+	if secs, err := strconv.ParseFloat(r.Header.Get(queueTimeoutHeader), 64); err == nil && secs > 0 {
+		sel.timeout = time.Duration(secs * float64(time.Second))
+	}
+	return sel
+}
+
+// request 构造负载均衡的选择条件，sticky 为 true 时使用会话绑定
+func (s *accountSelector) request(model string, exclude []int64, sticky bool) loadbalancer.Request {
+	req := loadbalancer.Request{
+		Model:     model,
+		Exclude:   exclude,
+		Group:     s.group,
+		Spillover: s.spillover,
+		Priority:  s.priority,
+		Timeout:   s.timeout,
+	}
+	if sticky {
+		req.Session = s.session
+	}
+	return req
+}
+
+// acquire 为 model 选择账号；全部账号满载时排队等待
+func (s *accountSelector) acquire(model string, exclude []int64, sticky bool) (*store.Account, error) {
+	account, info, err := s.h.loadBalancer.Acquire(s.ctx, s.request(model, exclude, sticky))
+	if info.Depth > 0 {
+		log.Printf("排队 %v 后获取账号 (队列长度 %d): %v", info.Wait.Round(time.Millisecond), info.Depth, errOrOK(err))
+	}
+	s.record(info)
+	return account, err
+}
+
+// record 记录最长的排队情况并更新响应头
+func (s *accountSelector) record(info loadbalancer.QueueInfo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if info.Depth > s.depth {
+		s.depth = info.Depth
+	}
+	if info.Wait > s.wait {
+		s.wait = info.Wait
+	}
+	if s.header != nil {
+		s.header.Set(queueDepthHeader, strconv.Itoa(s.depth))
+		s.header.Set(queueWaitHeader, strconv.FormatInt(s.wait.Milliseconds(), 10))
+	}
+}
+
+// closeHeader 并发输出响应前调用，之后的排队不再写入响应头
+func (s *accountSelector) closeHeader() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.header = nil
+}
+
+// canUseDefault 只有完全没有可用账号时才回退到默认配置；满载、排队失败时直接返回错误。
+// 限定分组且不允许溢出的请求不使用默认配置
+func (s *accountSelector) canUseDefault(err error) bool {
+	return errors.Is(err, loadbalancer.ErrNoAccounts) && (s.group == "" || s.spillover)
+}
+
+func errOrOK(err error) interface{} {
+	if err == nil {
+		return "ok"
+	}
+	return err
+}
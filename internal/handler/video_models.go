@@ -0,0 +1,263 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"orchids-api/internal/client"
+	"orchids-api/internal/imageutil"
+)
+
+// defaultVideoModel 未指定 model 时使用的视频模型
+const defaultVideoModel = "sora-2"
+
+// videoSize 模型支持的输出尺寸及其画幅
+type videoSize struct {
+	Size        string
+	AspectRatio string
+}
+
+// videoCapability 视频模型能力，列表中第一项为默认值
+type videoCapability struct {
+	Sizes        []videoSize
+	Seconds      []int
+	FPS          []int
+	Seed         bool
+	ImageToVideo bool
+}
+
+// videoModels 各视频模型支持的参数组合
+var videoModels = map[string]videoCapability{
+	"sora-2": {
+		Sizes:        []videoSize{{"720x1280", "9:16"}, {"1280x720", "16:9"}},
+		Seconds:      []int{4, 8, 12},
+		FPS:          []int{30},
+		ImageToVideo: true,
+	},
+	"sora-2-pro": {
+		Sizes:        []videoSize{{"720x1280", "9:16"}, {"1280x720", "16:9"}, {"1024x1792", "9:16"}, {"1792x1024", "16:9"}},
+		Seconds:      []int{4, 8, 12},
+		FPS:          []int{30},
+		ImageToVideo: true,
+	},
+	"veo-3": {
+		Sizes:        []videoSize{{"1280x720", "16:9"}, {"720x1280", "9:16"}, {"1920x1080", "16:9"}, {"1080x1920", "9:16"}},
+		Seconds:      []int{8, 4, 6},
+		FPS:          []int{24},
+		Seed:         true,
+		ImageToVideo: true,
+	},
+	"veo-3-fast": {
+		Sizes:        []videoSize{{"1280x720", "16:9"}, {"720x1280", "9:16"}},
+		Seconds:      []int{8, 4, 6},
+		FPS:          []int{24},
+		Seed:         true,
+		ImageToVideo: true,
+	},
+}
+
+// flexInt 兼容数字与数字字符串（OpenAI 的 seconds 为字符串）
+type flexInt int
+
+func (f *flexInt) UnmarshalJSON(data []byte) error {
+	s := strings.Trim(string(data), `"`)
+	if s == "" || s == "null" {
+		*f = 0
+		return nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return fmt.Errorf("expected an integer, got %s", data)
+	}
+	*f = flexInt(n)
+	return nil
+}
+
+// OpenAIVideoRequest 视频生成请求，/v1/videos 与 /v1/videos/generations 共用
+type OpenAIVideoRequest struct {
+	Model          string  `json:"model,omitempty"`
+	Prompt         string  `json:"prompt"`
+	N              int     `json:"n,omitempty"`
+	Size           string  `json:"size,omitempty"`
+	Seconds        flexInt `json:"seconds,omitempty"`
+	Duration       flexInt `json:"duration,omitempty"`
+	AspectRatio    string  `json:"aspect_ratio,omitempty"`
+	FPS            flexInt `json:"fps,omitempty"`
+	Seed           *int64  `json:"seed,omitempty"`
+	InputReference string  `json:"input_reference,omitempty"` // data URL
+}
+
+// errUnsupportedVideoValue 参数值不在模型能力范围内
+func errUnsupportedVideoValue(param, message string) *openAIError {
+	return newOpenAIError(http.StatusBadRequest, "invalid_request_error", message, param, "unsupported_value")
+}
+
+// resolveVideoOptions 按模型能力校验参数并补全默认值；reference 为上传的参考图原始数据
+func resolveVideoOptions(req *OpenAIVideoRequest, reference []byte) (client.VideoOptions, error) {
+	var opts client.VideoOptions
+
+	model := req.Model
+	if model == "" {
+		model = defaultVideoModel
+	}
+	capability, ok := videoModels[model]
+	if !ok {
+		return opts, newOpenAIError(http.StatusBadRequest, "invalid_request_error",
+			fmt.Sprintf("The video model '%s' does not exist. Supported models: %s", model, strings.Join(videoModelNames(), ", ")),
+			"model", "model_not_found")
+	}
+	opts.Model = model
+
+	if req.N > 1 {
+		return opts, errUnsupportedVideoValue("n", "Only one video can be generated per request")
+	}
+
+	// seconds 与 duration 是同一参数的两种写法
+	seconds := int(req.Seconds)
+	if req.Duration != 0 {
+		if seconds != 0 && seconds != int(req.Duration) {
+			return opts, errInvalidRequest("'seconds' and 'duration' conflict, specify only one", "seconds")
+		}
+		seconds = int(req.Duration)
+	}
+	if seconds == 0 {
+		seconds = capability.Seconds[0]
+	}
+	if !containsInt(capability.Seconds, seconds) {
+		return opts, errUnsupportedVideoValue("seconds", fmt.Sprintf("Model '%s' supports durations of %s seconds, got %d", model, joinInts(capability.Seconds), seconds))
+	}
+	opts.Seconds = seconds
+
+	size, err := resolveVideoSize(model, capability, req.Size, req.AspectRatio)
+	if err != nil {
+		return opts, err
+	}
+	opts.Size = size.Size
+	opts.AspectRatio = size.AspectRatio
+
+	fps := int(req.FPS)
+	if fps == 0 {
+		fps = capability.FPS[0]
+	}
+	if !containsInt(capability.FPS, fps) {
+		return opts, errUnsupportedVideoValue("fps", fmt.Sprintf("Model '%s' supports fps of %s, got %d", model, joinInts(capability.FPS), fps))
+	}
+	opts.FPS = fps
+
+	if req.Seed != nil {
+		if !capability.Seed {
+			return opts, newOpenAIError(http.StatusBadRequest, "invalid_request_error", fmt.Sprintf("Model '%s' does not support 'seed'", model), "seed", "unsupported_parameter")
+		}
+		opts.Seed = req.Seed
+	}
+
+	if len(reference) > 0 {
+		if !capability.ImageToVideo {
+			return opts, newOpenAIError(http.StatusBadRequest, "invalid_request_error", fmt.Sprintf("Model '%s' does not support image-to-video", model), "input_reference", "unsupported_parameter")
+		}
+		if opts.ReferenceImage, err = normalizeReferenceImage(reference, opts.Size); err != nil {
+			return opts, err
+		}
+	}
+
+	return opts, nil
+}
+
+// resolveVideoSize 根据 size 与 aspect_ratio 确定输出尺寸，二者都给出时必须一致
+func resolveVideoSize(model string, capability videoCapability, size, aspectRatio string) (videoSize, error) {
+	if size != "" {
+		for _, s := range capability.Sizes {
+			if s.Size == size {
+				if aspectRatio != "" && aspectRatio != s.AspectRatio {
+					return videoSize{}, errInvalidRequest(fmt.Sprintf("'aspect_ratio' %s conflicts with 'size' %s (%s)", aspectRatio, size, s.AspectRatio), "aspect_ratio")
+				}
+				return s, nil
+			}
+		}
+		return videoSize{}, errUnsupportedVideoValue("size", fmt.Sprintf("Model '%s' supports sizes %s, got %s", model, joinSizes(capability.Sizes), size))
+	}
+
+	if aspectRatio != "" {
+		for _, s := range capability.Sizes {
+			if s.AspectRatio == aspectRatio {
+				return s, nil
+			}
+		}
+		return videoSize{}, errUnsupportedVideoValue("aspect_ratio", fmt.Sprintf("Model '%s' does not support aspect ratio %s", model, aspectRatio))
+	}
+
+	return capability.Sizes[0], nil
+}
+
+// normalizeReferenceImage 参考图画幅需与输出一致，缩放到输出尺寸并转为 PNG
+func normalizeReferenceImage(data []byte, size string) ([]byte, error) {
+	img, _, err := imageutil.Decode(data)
+	if err != nil {
+		return nil, errInvalidRequest("Invalid 'input_reference': "+err.Error(), "input_reference")
+	}
+	width, height, err := imageutil.ParseSize(size)
+	if err != nil {
+		return nil, err
+	}
+
+	b := img.Bounds()
+	got := float64(b.Dx()) / float64(b.Dy())
+	want := float64(width) / float64(height)
+	if math.Abs(got-want)/want > 0.02 {
+		return nil, errUnsupportedVideoValue("input_reference",
+			fmt.Sprintf("'input_reference' is %dx%d, its aspect ratio must match the output size %s", b.Dx(), b.Dy(), size))
+	}
+
+	if b.Dx() != width || b.Dy() != height {
+		img = imageutil.Resize(img, width, height)
+	}
+	return imageutil.EncodePNG(img)
+}
+
+// videoOptionsFromJob 解析任务中保存的参数
+func videoOptionsFromJob(params string) client.VideoOptions {
+	var opts client.VideoOptions
+	json.Unmarshal([]byte(params), &opts)
+	return opts
+}
+
+func videoModelNames() []string {
+	names := make([]string, 0, len(videoModels))
+	for name := range videoModels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func containsInt(list []int, v int) bool {
+	for _, item := range list {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
+
+func joinInts(list []int) string {
+	sorted := append([]int(nil), list...)
+	sort.Ints(sorted)
+	parts := make([]string, len(sorted))
+	for i, v := range sorted {
+		parts[i] = strconv.Itoa(v)
+	}
+	return strings.Join(parts, ", ")
+}
+
+func joinSizes(list []videoSize) string {
+	parts := make([]string, len(list))
+	for i, s := range list {
+		parts[i] = s.Size
+	}
+	return strings.Join(parts, ", ")
+}
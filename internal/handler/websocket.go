@@ -0,0 +1,361 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"orchids-api/internal/websocket"
+)
+
+// maxWSConcurrentRequests 单个 WebSocket 连接上同时进行的请求上限
+const maxWSConcurrentRequests = 16
+
+// wsPingInterval 服务端 ping 间隔，避免代理因空闲断开连接
+const wsPingInterval = 30 * time.Second
+
+// wsClientFrame 客户端发送的帧
+type wsClientFrame struct {
+	ID       string            `json:"id"`
+	Type     string            `json:"type"`               // request | cancel | ping
+	Protocol string            `json:"protocol,omitempty"` // claude | openai
+	Body     json.RawMessage   `json:"body,omitempty"`
+	Headers  map[string]string `json:"headers,omitempty"` // 覆盖升级请求中的同名 header，仅对本请求生效
+}
+
+// wsServerFrame 服务端发送的帧
+type wsServerFrame struct {
+	ID     string          `json:"id,omitempty"`
+	Type   string          `json:"type"` // event | response | error | done | cancelled | pong
+	Event  string          `json:"event,omitempty"`
+	Status int             `json:"status,omitempty"`
+	Data   json.RawMessage `json:"data,omitempty"`
+}
+
+// HandleWebSocket 处理 /v1/ws，在一个连接上按 ID 复用多个流式请求。
+// 每个请求交给 HandleMessages / HandleOpenAIChat 处理，其 SSE 事件逐条转为 WebSocket 帧。
+func (h *Handler) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
+	if !h.wsOriginAllowed(r) {
+		log.Printf("拒绝跨站 WebSocket 连接，Origin: %s", r.Header.Get("Origin"))
+		writeOpenAIError(w, newOpenAIError(http.StatusForbidden, "invalid_request_error", "Origin not allowed", "", "origin_not_allowed"))
+		return
+	}
+	conn, err := websocket.Upgrade(w, r)
+	if err != nil {
+		log.Printf("WebSocket 握手失败: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	ctx, cancelAll := context.WithCancel(r.Context())
+	defer cancelAll()
+
+	send := func(frame wsServerFrame) {
+		data, _ := json.Marshal(frame)
+		conn.WriteText(data)
+	}
+
+	var mu sync.Mutex
+	inflight := make(map[string]context.CancelFunc)
+	var wg sync.WaitGroup
+
+	go func() {
+		ticker := time.NewTicker(wsPingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-conn.Done():
+				return
+			case <-ticker.C:
+				if err := conn.Ping(); err != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	log.Println("WebSocket 连接建立")
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			break
+		}
+
+		var frame wsClientFrame
+		if err := json.Unmarshal(data, &frame); err != nil {
+			send(wsErrorFrame("", errInvalidRequest("Invalid frame: "+err.Error(), "")))
+			continue
+		}
+
+		switch frame.Type {
+		case "ping":
+			send(wsServerFrame{ID: frame.ID, Type: "pong"})
+
+		case "cancel":
+			mu.Lock()
+			cancel := inflight[frame.ID]
+			mu.Unlock()
+			if cancel != nil {
+				log.Printf("WebSocket 请求 %s 被客户端取消", frame.ID)
+				cancel()
+			}
+
+		case "request", "":
+			if frame.ID == "" {
+				send(wsErrorFrame("", errInvalidRequest("'id' is required", "id")))
+				continue
+			}
+
+			var target http.HandlerFunc
+			var path string
+			switch frame.Protocol {
+			case "claude", "anthropic":
+				target, path = h.HandleMessages, "/v1/messages"
+			case "openai":
+				target, path = h.HandleOpenAIChat, "/v1/chat/completions"
+			default:
+				send(wsErrorFrame(frame.ID, errInvalidRequest("'protocol' must be 'claude' or 'openai'", "protocol")))
+				continue
+			}
+
+			mu.Lock()
+			if _, exists := inflight[frame.ID]; exists {
+				mu.Unlock()
+				send(wsErrorFrame(frame.ID, errInvalidRequest("Request id is already in flight", "id")))
+				continue
+			}
+			if len(inflight) >= maxWSConcurrentRequests {
+				mu.Unlock()
+				send(wsErrorFrame(frame.ID, newOpenAIError(http.StatusTooManyRequests, "rate_limit_error", "Too many concurrent requests on this connection", "", "rate_limit_exceeded")))
+				continue
+			}
+			reqCtx, cancel := context.WithCancel(ctx)
+			inflight[frame.ID] = cancel
+			mu.Unlock()
+
+			wg.Add(1)
+			go func(frame wsClientFrame) {
+				defer wg.Done()
+				defer func() {
+					mu.Lock()
+					delete(inflight, frame.ID)
+					mu.Unlock()
+					cancel()
+				}()
+				h.serveWSRequest(reqCtx, r, frame, target, path, send)
+			}(frame)
+
+		default:
+			send(wsErrorFrame(frame.ID, errInvalidRequest("Unknown frame type: "+frame.Type, "type")))
+		}
+	}
+
+	cancelAll()
+	wg.Wait()
+	log.Println("WebSocket 连接关闭")
+}
+
+// wsOriginAllowed 检查浏览器发来的 Origin 是否为网关自身（请求的 Host 或 PUBLIC_BASE_URL），
+// 防止其他站点借用户的浏览器建立连接。没有 Origin 的非浏览器客户端不受限制
+func (h *Handler) wsOriginAllowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	if h.config.PublicBaseURL != "" {
+		if base, err := url.Parse(h.config.PublicBaseURL); err == nil && strings.EqualFold(u.Host, base.Host) {
+			return true
+		}
+	}
+	return false
+}
+
+// serveWSRequest 以内部 HTTP 请求的方式执行一个 WebSocket 请求
+func (h *Handler) serveWSRequest(ctx context.Context, upgrade *http.Request, frame wsClientFrame, target http.HandlerFunc, path string, send func(wsServerFrame)) {
+	req, err := newWSRequest(ctx, upgrade, frame, path)
+	if err != nil {
+		send(wsErrorFrame(frame.ID, err))
+		return
+	}
+
+	sw := &wsStreamWriter{id: frame.ID, send: send, header: make(http.Header)}
+	target(sw, req)
+
+	if ctx.Err() != nil {
+		send(wsServerFrame{ID: frame.ID, Type: "cancelled"})
+		return
+	}
+	sw.finish()
+}
+
+// newWSRequest 构造帧对应的内部请求。沿用升级请求的 Host 与 header（会话绑定、优先级、
+// 分组规则、媒体地址都依赖它们），去掉逐跳与 Sec-WebSocket-* header，再应用帧中的 headers
+func newWSRequest(ctx context.Context, upgrade *http.Request, frame wsClientFrame, path string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, path, bytes.NewReader(frame.Body))
+	if err != nil {
+		return nil, err
+	}
+	req.Host = upgrade.Host
+	req.TLS = upgrade.TLS
+	req.RemoteAddr = upgrade.RemoteAddr
+
+	for name, values := range upgrade.Header {
+		if wsForwardable(name, upgrade.Header) {
+			req.Header[name] = append([]string(nil), values...)
+		}
+	}
+	for name, value := range frame.Headers {
+		if wsForwardable(name, upgrade.Header) {
+			req.Header.Set(name, value)
+		}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return req, nil
+}
+
+// wsHopHeaders 只对升级连接本身有效、不能带到内部请求的 header
+var wsHopHeaders = map[string]bool{
+	"Connection":          true,
+	"Keep-Alive":          true,
+	"Proxy-Authenticate":  true,
+	"Proxy-Authorization": true,
+	"Proxy-Connection":    true,
+	"Te":                  true,
+	"Trailer":             true,
+	"Transfer-Encoding":   true,
+	"Upgrade":             true,
+	"Content-Length":      true,
+	"Host":                true,
+}
+
+// wsForwardable header 是否可以带到内部请求，Connection 中列出的 header 同样视为逐跳
+func wsForwardable(name string, upgradeHeader http.Header) bool {
+	name = http.CanonicalHeaderKey(name)
+	if wsHopHeaders[name] || strings.HasPrefix(name, "Sec-Websocket-") {
+		return false
+	}
+	for _, v := range upgradeHeader.Values("Connection") {
+		for _, token := range strings.Split(v, ",") {
+			if http.CanonicalHeaderKey(strings.TrimSpace(token)) == name {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// wsStreamWriter 将 handler 的 SSE 输出转换为 WebSocket 帧
+type wsStreamWriter struct {
+	id     string
+	send   func(wsServerFrame)
+	header http.Header
+	status int
+	buf    bytes.Buffer
+}
+
+func (sw *wsStreamWriter) Header() http.Header {
+	return sw.header
+}
+
+func (sw *wsStreamWriter) WriteHeader(status int) {
+	if sw.status == 0 {
+		sw.status = status
+	}
+}
+
+func (sw *wsStreamWriter) Write(p []byte) (int, error) {
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+	sw.buf.Write(p)
+	if sw.isSSE() {
+		sw.drainEvents()
+	}
+	return len(p), nil
+}
+
+func (sw *wsStreamWriter) Flush() {}
+
+func (sw *wsStreamWriter) isSSE() bool {
+	return sw.status < 400 && strings.HasPrefix(sw.header.Get("Content-Type"), "text/event-stream")
+}
+
+// drainEvents 把缓冲区中完整的 SSE 事件逐条发出
+func (sw *wsStreamWriter) drainEvents() {
+	for {
+		data := sw.buf.Bytes()
+		end := bytes.Index(data, []byte("\n\n"))
+		if end < 0 {
+			return
+		}
+		raw := string(data[:end])
+		sw.buf.Next(end + 2)
+
+		var event, payload string
+		for _, line := range strings.Split(raw, "\n") {
+			switch {
+			case strings.HasPrefix(line, "event: "):
+				event = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				payload = strings.TrimPrefix(line, "data: ")
+			}
+		}
+		if payload == "" || payload == "[DONE]" {
+			continue
+		}
+
+		// 流式过程中的错误块单独作为 error 帧
+		if event == "" && strings.HasPrefix(payload, `{"error":`) {
+			sw.send(wsServerFrame{ID: sw.id, Type: "error", Data: wsJSON(payload)})
+			continue
+		}
+		sw.send(wsServerFrame{ID: sw.id, Type: "event", Event: event, Data: wsJSON(payload)})
+	}
+}
+
+// finish 发送非流式响应或错误，并以 done 帧结束
+func (sw *wsStreamWriter) finish() {
+	if !sw.isSSE() && sw.buf.Len() > 0 {
+		frameType := "response"
+		if sw.status >= 400 {
+			frameType = "error"
+		}
+		sw.send(wsServerFrame{ID: sw.id, Type: frameType, Status: sw.status, Data: wsJSON(sw.buf.String())})
+		if sw.status >= 400 {
+			return
+		}
+	}
+	sw.send(wsServerFrame{ID: sw.id, Type: "done"})
+}
+
+func wsErrorFrame(id string, err error) wsServerFrame {
+	oe := toOpenAIError(err)
+	data, _ := json.Marshal(OpenAIErrorResponse{Error: oe.Detail})
+	return wsServerFrame{ID: id, Type: "error", Status: oe.Status, Data: data}
+}
+
+// wsJSON 保证 data 字段为合法 JSON，非 JSON 文本按字符串发送
+func wsJSON(s string) json.RawMessage {
+	s = strings.TrimSpace(s)
+	if json.Valid([]byte(s)) {
+		return json.RawMessage(s)
+	}
+	data, _ := json.Marshal(s)
+	return data
+}
@@ -0,0 +1,58 @@
+package handler
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNewWSRequest(t *testing.T) {
+	upgrade := httptest.NewRequest(http.MethodGet, "/v1/ws", nil)
+	upgrade.Host = "gateway.example.com"
+	upgrade.TLS = &tls.ConnectionState{}
+	upgrade.Header.Set("Connection", "Upgrade, X-Hop")
+	upgrade.Header.Set("Upgrade", "websocket")
+	upgrade.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	upgrade.Header.Set("Sec-WebSocket-Version", "13")
+	upgrade.Header.Set("X-Hop", "1")
+	upgrade.Header.Set("Authorization", "Bearer sk-team")
+	upgrade.Header.Set("X-Session-ID", "conn-session")
+	upgrade.Header.Set("X-Request-Priority", "high")
+	upgrade.Header.Set("X-Team", "a")
+
+	frame := wsClientFrame{ID: "1", Body: []byte(`{}`), Headers: map[string]string{
+		"x-session-id":    "frame-session",
+		"X-Queue-Timeout": "5s",
+		"Upgrade":         "h2c",
+		"Host":            "evil.example.com",
+	}}
+	req, err := newWSRequest(context.Background(), upgrade, frame, "/v1/chat/completions")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if req.Host != "gateway.example.com" || req.TLS == nil {
+		t.Errorf("got host %q tls %v, want the upgrade request's", req.Host, req.TLS != nil)
+	}
+	want := map[string]string{
+		"Authorization":      "Bearer sk-team",
+		"X-Session-Id":       "frame-session", // 帧中的 header 覆盖升级请求
+		"X-Request-Priority": "high",
+		"X-Queue-Timeout":    "5s",
+		"X-Team":             "a",
+		"Content-Type":       "application/json",
+		// 逐跳与握手 header 不转发
+		"Connection":            "",
+		"Upgrade":               "",
+		"X-Hop":                 "",
+		"Sec-Websocket-Key":     "",
+		"Sec-Websocket-Version": "",
+	}
+	for name, value := range want {
+		if got := req.Header.Get(name); got != value {
+			t.Errorf("header %s = %q, want %q", name, got, value)
+		}
+	}
+}
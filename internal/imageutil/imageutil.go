@@ -0,0 +1,187 @@
+package imageutil
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"strconv"
+	"strings"
+)
+
+// MaxPixels 解码前允许的最大像素数，防止超大图片耗尽内存
+const MaxPixels = 64 << 20
+
+var (
+	// ErrUnsupportedFormat 仅支持 PNG 与 JPEG
+	ErrUnsupportedFormat = errors.New("unsupported image format, expected PNG or JPEG")
+	// ErrTooLarge 图片尺寸过大
+	ErrTooLarge = errors.New("image dimensions are too large")
+	// ErrMaskNoAlpha 遮罩没有透明像素，无法标出要编辑的区域
+	ErrMaskNoAlpha = errors.New("the mask must have an alpha channel marking the area to edit")
+)
+
+// Decode 解码 PNG 或 JPEG，返回图片与格式名
+func Decode(data []byte) (image.Image, string, error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", ErrUnsupportedFormat
+	}
+	if format != "png" && format != "jpeg" {
+		return nil, "", ErrUnsupportedFormat
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 {
+		return nil, "", fmt.Errorf("invalid image dimensions %dx%d", cfg.Width, cfg.Height)
+	}
+	if int64(cfg.Width)*int64(cfg.Height) > MaxPixels {
+		return nil, "", ErrTooLarge
+	}
+
+	var img image.Image
+	if format == "png" {
+		img, err = png.Decode(bytes.NewReader(data))
+	} else {
+		img, err = jpeg.Decode(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decode %s image: %w", format, err)
+	}
+	return img, format, nil
+}
+
+// ParseSize 解析 "WxH" 格式的尺寸
+func ParseSize(size string) (int, int, error) {
+	ws, hs, ok := strings.Cut(strings.ToLower(size), "x")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid size %q, expected WIDTHxHEIGHT", size)
+	}
+	w, err1 := strconv.Atoi(ws)
+	h, err2 := strconv.Atoi(hs)
+	if err1 != nil || err2 != nil || w <= 0 || h <= 0 {
+		return 0, 0, fmt.Errorf("invalid size %q, expected WIDTHxHEIGHT", size)
+	}
+	return w, h, nil
+}
+
+// Fit 等比缩小到 maxW x maxH 以内，已在范围内时原样返回
+func Fit(img image.Image, maxW, maxH int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= maxW && h <= maxH {
+		return img
+	}
+	scale := float64(maxW) / float64(w)
+	if s := float64(maxH) / float64(h); s < scale {
+		scale = s
+	}
+	nw := max(1, int(float64(w)*scale+0.5))
+	nh := max(1, int(float64(h)*scale+0.5))
+	return Resize(img, nw, nh)
+}
+
+// Resize 缩放到指定尺寸。按目标像素覆盖的源区域求平均（预乘 alpha），
+// 缩小时不会产生锯齿，放大时退化为最近邻。
+func Resize(img image.Image, width, height int) *image.RGBA {
+	src := toRGBA(img)
+	sb := src.Bounds()
+	sw, sh := sb.Dx(), sb.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	if sw == width && sh == height {
+		copy(dst.Pix, src.Pix)
+		return dst
+	}
+
+	xRanges := spans(sw, width)
+	yRanges := spans(sh, height)
+
+	for dy := 0; dy < height; dy++ {
+		y0, y1 := yRanges[dy][0], yRanges[dy][1]
+		for dx := 0; dx < width; dx++ {
+			x0, x1 := xRanges[dx][0], xRanges[dx][1]
+			var r, g, b, a, n uint64
+			for y := y0; y < y1; y++ {
+				row := src.Pix[y*src.Stride:]
+				for x := x0; x < x1; x++ {
+					p := row[x*4 : x*4+4]
+					r += uint64(p[0])
+					g += uint64(p[1])
+					b += uint64(p[2])
+					a += uint64(p[3])
+					n++
+				}
+			}
+			off := dy*dst.Stride + dx*4
+			dst.Pix[off] = uint8(r / n)
+			dst.Pix[off+1] = uint8(g / n)
+			dst.Pix[off+2] = uint8(b / n)
+			dst.Pix[off+3] = uint8(a / n)
+		}
+	}
+	return dst
+}
+
+// EncodePNG 编码为 PNG
+func EncodePNG(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// PrepareMask 校验遮罩带透明通道，并缩放到与图片相同的 width x height
+func PrepareMask(mask image.Image, width, height int) (image.Image, error) {
+	if !HasAlpha(mask) {
+		return nil, ErrMaskNoAlpha
+	}
+	if b := mask.Bounds(); b.Dx() != width || b.Dy() != height {
+		return Resize(mask, width, height), nil
+	}
+	return mask, nil
+}
+
+// HasAlpha 图片是否包含透明像素
+func HasAlpha(img image.Image) bool {
+	if opaque, ok := img.(interface{ Opaque() bool }); ok {
+		return !opaque.Opaque()
+	}
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if _, _, _, a := img.At(x, y).RGBA(); a != 0xffff {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// spans 计算每个目标像素对应的源像素区间 [start, end)
+func spans(srcLen, dstLen int) [][2]int {
+	out := make([][2]int, dstLen)
+	for i := range out {
+		start := i * srcLen / dstLen
+		end := (i + 1) * srcLen / dstLen
+		if end <= start {
+			end = start + 1
+		}
+		if end > srcLen {
+			end = srcLen
+		}
+		out[i] = [2]int{start, end}
+	}
+	return out
+}
+
+func toRGBA(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	if rgba, ok := img.(*image.RGBA); ok && b.Min == (image.Point{}) {
+		return rgba
+	}
+	rgba := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, b.Min, draw.Src)
+	return rgba
+}
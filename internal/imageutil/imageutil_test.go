@@ -0,0 +1,79 @@
+package imageutil
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// solid 返回 w x h 的纯色图片
+func solid(w, h int, c color.RGBA) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = c.R, c.G, c.B, c.A
+	}
+	return img
+}
+
+func TestFit(t *testing.T) {
+	tests := []struct {
+		name         string
+		w, h         int
+		maxW, maxH   int
+		wantW, wantH int
+	}{
+		{"已在范围内", 800, 600, 1024, 1024, 800, 600},
+		{"宽度受限", 2048, 1024, 1024, 1024, 1024, 512},
+		{"高度受限", 1000, 3000, 1024, 1024, 341, 1024},
+		{"两边都超出", 4000, 2000, 1000, 1000, 1000, 500},
+		{"极窄图片至少保留 1 像素", 4000, 2, 1000, 1000, 1000, 1},
+	}
+	for _, tt := range tests {
+		got := Fit(solid(tt.w, tt.h, color.RGBA{A: 255}), tt.maxW, tt.maxH).Bounds()
+		if got.Dx() != tt.wantW || got.Dy() != tt.wantH {
+			t.Errorf("%s: Fit = %dx%d, want %dx%d", tt.name, got.Dx(), got.Dy(), tt.wantW, tt.wantH)
+		}
+	}
+}
+
+func TestResizeAverages(t *testing.T) {
+	// 左半黑右半白，缩成 1 像素后为灰色
+	img := solid(2, 1, color.RGBA{A: 255})
+	img.Set(1, 0, color.RGBA{R: 255, G: 255, B: 255, A: 255})
+	got := Resize(img, 1, 1).RGBAAt(0, 0)
+	if got.R != 127 || got.A != 255 {
+		t.Errorf("Resize = %v, want gray", got)
+	}
+}
+
+func TestPrepareMask(t *testing.T) {
+	transparent := solid(4, 4, color.RGBA{})
+
+	// 尺寸一致时原样返回
+	mask, err := PrepareMask(transparent, 4, 4)
+	if err != nil || mask != image.Image(transparent) {
+		t.Errorf("got %v (%v), want the original mask", mask.Bounds(), err)
+	}
+
+	// 尺寸不一致时缩放到图片尺寸，透明度保留
+	mask, err = PrepareMask(transparent, 16, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := mask.Bounds(); b.Dx() != 16 || b.Dy() != 8 {
+		t.Errorf("mask size = %dx%d, want 16x8", b.Dx(), b.Dy())
+	}
+	if !HasAlpha(mask) {
+		t.Error("缩放后的遮罩丢失了透明通道")
+	}
+
+	// 不透明的遮罩与没有 alpha 通道的格式都被拒绝
+	for name, img := range map[string]image.Image{
+		"不透明 RGBA": solid(4, 4, color.RGBA{R: 255, A: 255}),
+		"灰度图":      image.NewGray(image.Rect(0, 0, 4, 4)),
+	} {
+		if _, err := PrepareMask(img, 4, 4); err != ErrMaskNoAlpha {
+			t.Errorf("%s: err = %v, want %v", name, err, ErrMaskNoAlpha)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package loadbalancer
+
+import (
+	"log"
+	"time"
+
+	"orchids-api/internal/store"
+)
+
+// affinitySweepInterval 清理过期会话绑定的最短间隔
+const affinitySweepInterval = time.Minute
+
+// affinity 会话到账号的绑定，每次命中后续期
+type affinity struct {
+	accountID int64
+	expiresAt time.Time
+}
+
+// SetSessionTTL 设置会话绑定的有效期，0 表示关闭会话粘滞
+func (lb *LoadBalancer) SetSessionTTL(ttl time.Duration) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	lb.sessionTTL = ttl
+	if ttl <= 0 {
+		lb.affinity = make(map[string]affinity)
+	}
+}
+
+// pinnedAccount 返回会话绑定且仍在候选中的账号；绑定的账号被排除、停用、熔断或不支持该模型时返回 nil。
+// 调用方需持有 lb.mu
+func (lb *LoadBalancer) pinnedAccount(session string, candidates []*store.Account, now time.Time) *store.Account {
+	if session == "" || lb.sessionTTL <= 0 {
+		return nil
+	}
+	a, ok := lb.affinity[session]
+	if !ok || now.After(a.expiresAt) {
+		return nil
+	}
+	for _, acc := range candidates {
+		if acc.ID == a.accountID {
+			return acc
+		}
+	}
+	log.Printf("会话绑定的账号 #%d 不可用，重新分配", a.accountID)
+	return nil
+}
+
+// bindSession 绑定会话与账号并续期。调用方需持有 lb.mu
+func (lb *LoadBalancer) bindSession(session string, acc *store.Account, now time.Time) {
+	if session == "" || lb.sessionTTL <= 0 {
+		return
+	}
+	lb.affinity[session] = affinity{accountID: acc.ID, expiresAt: now.Add(lb.sessionTTL)}
+
+	if now.Sub(lb.affinitySweptAt) < affinitySweepInterval {
+		return
+	}
+	lb.affinitySweptAt = now
+	for key, a := range lb.affinity {
+		if now.After(a.expiresAt) {
+			delete(lb.affinity, key)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package loadbalancer
+
+import (
+	"testing"
+	"time"
+
+	"orchids-api/internal/store"
+)
+
+func TestSessionAffinity(t *testing.T) {
+	s := newTestStore(t, namedAccounts("a", "b", "c")...)
+	lb := New(s)
+	lb.SetSessionTTL(time.Minute)
+	if err := lb.SetStrategy(StrategyWeightedRoundRobin); err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := lb.GetNextAccountForSession("conv-1", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 轮询会把其他请求分到别的账号，同一会话保持不变
+	for i := 0; i < 3; i++ {
+		lb.GetNextAccount()
+		got, err := lb.GetNextAccountForSession("conv-1", "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.ID != first.ID {
+			t.Errorf("request %d went to account %d, want %d", i, got.ID, first.ID)
+		}
+	}
+
+	// 绑定的账号被排除（本次请求已失败）时改绑到其他账号
+	moved, err := lb.GetNextAccountForSession("conv-1", "", []int64{first.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved.ID == first.ID {
+		t.Fatalf("excluded account %d was selected", first.ID)
+	}
+	got, _ := lb.GetNextAccountForSession("conv-1", "", nil)
+	if got.ID != moved.ID {
+		t.Errorf("after rebinding got account %d, want %d", got.ID, moved.ID)
+	}
+
+	// 绑定的账号停用后重新分配
+	moved.Enabled = false
+	if err := s.UpdateAccount(moved); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := lb.GetNextAccountForSession("conv-1", "", nil); got.ID == moved.ID {
+		t.Errorf("disabled account %d was selected", moved.ID)
+	}
+
+	// 过期后绑定失效
+	lb.mu.Lock()
+	a := lb.affinity["conv-1"]
+	a.expiresAt = time.Now().Add(-time.Second)
+	lb.affinity["conv-1"] = a
+	pinned := lb.pinnedAccount("conv-1", []*store.Account{{ID: a.accountID}}, time.Now())
+	lb.mu.Unlock()
+	if pinned != nil {
+		t.Errorf("expired session still pinned to account %d", pinned.ID)
+	}
+}
@@ -0,0 +1,92 @@
+package loadbalancer
+
+import (
+	"testing"
+	"time"
+
+	"orchids-api/internal/store"
+)
+
+func TestGroupSelection(t *testing.T) {
+	lb := New(newTestStore(t,
+		&store.Account{Name: "team", Weight: 1, Groups: []string{"team-a"}, MaxConcurrency: 1, Enabled: true},
+		&store.Account{Name: "shared", Weight: 1, Enabled: true},
+	))
+	team, err := lb.Select(Request{Group: "TEAM-A"})
+	if err != nil || team.Name != "team" {
+		t.Fatalf("got %v, %v, want account team", team, err)
+	}
+
+	// 分组内满载或失败时不使用其他分组
+	if _, err := lb.Select(Request{Group: "team-a"}); err != ErrAccountsBusy {
+		t.Errorf("busy: err = %v, want %v", err, ErrAccountsBusy)
+	}
+	if _, err := lb.Select(Request{Group: "team-a", Exclude: []int64{team.ID}}); err != ErrNoAccounts {
+		t.Errorf("excluded: err = %v, want %v", err, ErrNoAccounts)
+	}
+
+	// 允许溢出时使用其他账号
+	got, err := lb.Select(Request{Group: "team-a", Spillover: true})
+	if err != nil || got.Name != "shared" {
+		t.Errorf("spillover: got %v, %v, want account shared", got, err)
+	}
+	lb.Release(got)
+
+	// 不限制分组时可以使用全部账号
+	lb.Release(team)
+	seen := make(map[string]bool)
+	for i := 0; i < 20; i++ {
+		acc, err := lb.Select(Request{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		seen[acc.Name] = true
+		lb.Release(acc)
+	}
+	if !seen["team"] || !seen["shared"] {
+		t.Errorf("ungrouped requests used %v, want both accounts", seen)
+	}
+}
+
+func TestPriorityTiers(t *testing.T) {
+	lb := New(newTestStore(t,
+		&store.Account{Name: "primary-1", Weight: 1, MaxConcurrency: 1, Enabled: true},
+		&store.Account{Name: "primary-2", Weight: 1, MaxConcurrency: 1, Enabled: true},
+		&store.Account{Name: "backup", Weight: 100, Priority: 10, Enabled: true},
+	))
+	lb.SetSessionTTL(time.Hour)
+	// 备用账号权重再高也只在主账号都不可用时使用
+	first, _ := lb.Select(Request{Session: "s"})
+	second, _ := lb.Select(Request{})
+	if first.Priority != 0 || second.Priority != 0 || first.ID == second.ID {
+		t.Fatalf("got %s and %s, want both primary accounts", first.Name, second.Name)
+	}
+	overflow, err := lb.Select(Request{})
+	if err != nil || overflow.Name != "backup" {
+		t.Fatalf("got %v, %v, want backup", overflow, err)
+	}
+	lb.Release(overflow)
+
+	// 主账号恢复后不再使用备用账号
+	lb.Release(second)
+	if got, _ := lb.Select(Request{}); got.ID != second.ID {
+		t.Errorf("got %s, want %s", got.Name, second.Name)
+	}
+
+	// 会话绑定到备用账号后，主账号可用时改绑
+	lb.Release(first)
+	lb.Release(second)
+	lb.mu.Lock()
+	lb.bindSession("s", overflow, time.Now())
+	lb.mu.Unlock()
+	got, _ := lb.Select(Request{Session: "s"})
+	if got.Priority != 0 {
+		t.Errorf("session stayed on %s, want a primary account", got.Name)
+	}
+	lb.mu.Lock()
+	pinned := lb.affinity["s"].accountID
+	lb.mu.Unlock()
+	if pinned != got.ID {
+		t.Errorf("session pinned to #%d, want #%d", pinned, got.ID)
+	}
+}
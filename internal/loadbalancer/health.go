@@ -0,0 +1,211 @@
+package loadbalancer
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"orchids-api/internal/client"
+	"orchids-api/internal/store"
+)
+
+const (
+	// failureThreshold 连续失败多少次后熔断
+	failureThreshold = 3
+	// baseCooldown 首次熔断的冷却时间，之后每次熔断翻倍，最长 maxCooldown
+	baseCooldown = 30 * time.Second
+	maxCooldown  = 10 * time.Minute
+	// probeTimeout 半开状态下探测请求超过该时间仍未回报时，允许再次探测
+	probeTimeout = 2 * time.Minute
+	// successPersistInterval 状态未变化时，成功时间最多按该间隔标记落库
+	successPersistInterval = time.Minute
+)
+
+// accountHealth 内存中的健康状态，probing 不落库
+type accountHealth struct {
+	store.AccountHealth
+	probing bool
+	probeAt time.Time
+}
+
+// loadHealth 从 store 恢复健康状态
+func (lb *LoadBalancer) loadHealth() {
+	list, err := lb.store.ListAccountHealth()
+	if err != nil {
+		log.Printf("加载账号健康状态失败: %v", err)
+		return
+	}
+	for id, h := range list {
+		lb.health[id] = &accountHealth{AccountHealth: *h}
+	}
+}
+
+// healthOf 调用方需持有 lb.mu
+func (lb *LoadBalancer) healthOf(id int64) *accountHealth {
+	h, ok := lb.health[id]
+	if !ok {
+		h = &accountHealth{AccountHealth: store.AccountHealth{AccountID: id, State: store.CircuitClosed}}
+		lb.health[id] = h
+	}
+	return h
+}
+
+// admits 判断账号当前是否可接收请求，冷却结束的熔断账号转为半开。调用方需持有 lb.mu
+func (lb *LoadBalancer) admits(acc *store.Account, now time.Time) bool {
+	h := lb.healthOf(acc.ID)
+	switch h.State {
+	case store.CircuitOpen:
+		if now.Before(h.OpenUntil) {
+			return false
+		}
+		h.State = store.CircuitHalfOpen
+		h.probing = false
+		lb.markHealthDirty(h)
+		log.Printf("账号 %s 冷却结束，进入半开状态", acc.Name)
+		return true
+	case store.CircuitHalfOpen:
+		// 半开时同一时间只放行一个探测请求
+		return !h.probing || now.Sub(h.probeAt) >= probeTimeout
+	}
+	return true
+}
+
+// markProbe 被选中的半开账号标记为探测中。调用方需持有 lb.mu
+func (lb *LoadBalancer) markProbe(acc *store.Account, now time.Time) {
+	h := lb.healthOf(acc.ID)
+	if h.State == store.CircuitHalfOpen {
+		h.probing = true
+		h.probeAt = now
+		log.Printf("账号 %s 处于半开状态，放行探测请求", acc.Name)
+	}
+}
+
+// ReportResult 回报账号请求结果，更新熔断状态。acc 为 nil（默认配置）时忽略；
+// 与账号无关的错误（客户端取消、请求无效）不计入
+func (lb *LoadBalancer) ReportResult(acc *store.Account, err error) {
+	if acc == nil {
+		return
+	}
+
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	lb.reportLocked(acc, err, time.Now())
+}
+
+// reportLocked 按 now 记录请求结果。调用方需持有 lb.mu
+func (lb *LoadBalancer) reportLocked(acc *store.Account, err error, now time.Time) {
+	h := lb.healthOf(acc.ID)
+	switch {
+	case err == nil:
+		lb.recordSuccess(acc, h, now)
+	case client.IsAccountError(err):
+		lb.recordFailure(acc, h, now, err)
+	default:
+		// 结果不能说明账号状态，释放探测名额
+		h.probing = false
+	}
+}
+
+func (lb *LoadBalancer) recordSuccess(acc *store.Account, h *accountHealth, now time.Time) {
+	recovered := h.State != store.CircuitClosed
+	changed := recovered || h.ConsecutiveFailures > 0
+	persist := changed || now.Sub(h.LastSuccessAt) >= successPersistInterval
+
+	h.State = store.CircuitClosed
+	h.ConsecutiveFailures = 0
+	h.Trips = 0
+	h.OpenUntil = time.Time{}
+	h.LastSuccessAt = now
+	h.probing = false
+
+	if recovered {
+		log.Printf("账号 %s 探测成功，熔断关闭", acc.Name)
+	}
+	if persist {
+		lb.markHealthDirty(h)
+	}
+}
+
+func (lb *LoadBalancer) recordFailure(acc *store.Account, h *accountHealth, now time.Time, err error) {
+	h.ConsecutiveFailures++
+	h.LastFailureAt = now
+	h.LastError = err.Error()
+	if len(h.LastError) > 500 {
+		h.LastError = h.LastError[:500]
+	}
+
+	wasProbing := h.probing
+	h.probing = false
+	if (h.State == store.CircuitHalfOpen && wasProbing) ||
+		(h.State == store.CircuitClosed && h.ConsecutiveFailures >= failureThreshold) {
+		h.Trips++
+		cooldown := baseCooldown << (h.Trips - 1)
+		if cooldown > maxCooldown || cooldown <= 0 {
+			cooldown = maxCooldown
+		}
+		h.State = store.CircuitOpen
+		h.OpenUntil = now.Add(cooldown)
+		log.Printf("账号 %s 熔断 %v (连续失败 %d 次): %v", acc.Name, cooldown, h.ConsecutiveFailures, err)
+	}
+	lb.markHealthDirty(h)
+}
+
+// markHealthDirty 标记健康状态待落库，由 Flush 批量写入。调用方需持有 lb.mu
+func (lb *LoadBalancer) markHealthDirty(h *accountHealth) {
+	lb.dirtyHealth[h.AccountID] = true
+}
+
+// Health 返回账号当前的健康状态，没有记录时为 nil
+func (lb *LoadBalancer) Health(acc *store.Account) *store.AccountHealth {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+	h, ok := lb.health[acc.ID]
+	if !ok {
+		return nil
+	}
+	state := h.AccountHealth
+	return &state
+}
+
+// StartProber 定期主动探测冷却结束的熔断账号，probe 成功即关闭熔断，失败则按退避重新熔断
+func (lb *LoadBalancer) StartProber(ctx context.Context, interval time.Duration, probe func(*store.Account) error) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				lb.probeAccounts(ctx, probe)
+			}
+		}
+	}()
+}
+
+func (lb *LoadBalancer) probeAccounts(ctx context.Context, probe func(*store.Account) error) {
+	accounts, err := lb.enabledAccounts()
+	if err != nil {
+		log.Printf("探测账号失败: %v", err)
+		return
+	}
+
+	for _, acc := range accounts {
+		if ctx.Err() != nil {
+			return
+		}
+
+		lb.mu.Lock()
+		now := time.Now()
+		due := lb.healthOf(acc.ID).State != store.CircuitClosed && lb.admits(acc, now)
+		if due {
+			lb.markProbe(acc, now)
+		}
+		lb.mu.Unlock()
+		if !due {
+			continue
+		}
+
+		lb.ReportResult(acc, probe(acc))
+	}
+}
@@ -0,0 +1,125 @@
+package loadbalancer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"orchids-api/internal/client"
+	"orchids-api/internal/store"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	accountErr := &client.UpstreamError{Op: "request", StatusCode: http.StatusServiceUnavailable}
+	start := time.Now()
+
+	// 按顺序作用于同一个账号，at 为相对 start 的时间
+	steps := []struct {
+		name      string
+		at        time.Duration
+		op        string // fail | succeed | report | admit | probe
+		err       error
+		wantState string
+		wantAdmit bool
+	}{
+		{name: "首次失败", op: "fail", err: accountErr, wantState: store.CircuitClosed, wantAdmit: true},
+		{name: "第二次失败", op: "fail", err: accountErr, wantState: store.CircuitClosed, wantAdmit: true},
+		{name: "连续失败达到阈值熔断", op: "fail", err: accountErr, wantState: store.CircuitOpen},
+		{name: "冷却期内不放行", at: baseCooldown - time.Second, op: "admit", wantState: store.CircuitOpen},
+		{name: "冷却结束进入半开", at: baseCooldown, op: "admit", wantState: store.CircuitHalfOpen, wantAdmit: true},
+		{name: "探测中不放行其他请求", at: baseCooldown, op: "probe", wantState: store.CircuitHalfOpen},
+		{name: "与账号无关的错误释放探测名额", at: baseCooldown, op: "report", err: context.Canceled, wantState: store.CircuitHalfOpen, wantAdmit: true},
+		{name: "再次探测", at: baseCooldown, op: "probe", wantState: store.CircuitHalfOpen},
+		{name: "探测超时后允许再次探测", at: baseCooldown + probeTimeout, op: "admit", wantState: store.CircuitHalfOpen, wantAdmit: true},
+		{name: "探测失败重新熔断且冷却翻倍", at: baseCooldown + probeTimeout, op: "fail", err: accountErr, wantState: store.CircuitOpen},
+		{name: "翻倍后的冷却期内不放行", at: 3*baseCooldown + probeTimeout - time.Second, op: "admit", wantState: store.CircuitOpen},
+		{name: "翻倍后的冷却结束", at: 3*baseCooldown + probeTimeout, op: "admit", wantState: store.CircuitHalfOpen, wantAdmit: true},
+		{name: "探测成功关闭熔断", at: 3*baseCooldown + probeTimeout, op: "succeed", wantState: store.CircuitClosed, wantAdmit: true},
+	}
+
+	lb := New(newTestStore(t, namedAccounts("a")...))
+	acc, err := lb.GetNextAccount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lb.Release(acc)
+
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	for _, step := range steps {
+		now := start.Add(step.at)
+		switch step.op {
+		case "fail", "report":
+			lb.reportLocked(acc, step.err, now)
+		case "succeed":
+			lb.reportLocked(acc, nil, now)
+		case "probe":
+			if !lb.admits(acc, now) {
+				t.Fatalf("%s: 账号不可探测", step.name)
+			}
+			lb.markProbe(acc, now)
+		case "admit":
+			// 由下面的 admits 检查，冷却结束时 admits 负责转为半开
+		}
+
+		if got := lb.admits(acc, now); got != step.wantAdmit {
+			t.Errorf("%s: admits = %v, want %v", step.name, got, step.wantAdmit)
+		}
+		if got := lb.healthOf(acc.ID).State; got != step.wantState {
+			t.Errorf("%s: state = %s, want %s", step.name, got, step.wantState)
+		}
+	}
+
+	h := lb.healthOf(acc.ID)
+	if h.Trips != 0 || h.ConsecutiveFailures != 0 || !h.OpenUntil.IsZero() {
+		t.Errorf("恢复后 health = %+v, want reset", h.AccountHealth)
+	}
+	if !lb.dirtyHealth[acc.ID] {
+		t.Error("健康状态变化后没有标记待落库")
+	}
+}
+
+func TestCircuitBreakerIgnoresRequestErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+	}{
+		{"客户端取消", context.Canceled},
+		{"包装的客户端取消", fmt.Errorf("stream: %w", context.Canceled)},
+		{"400", &client.UpstreamError{StatusCode: http.StatusBadRequest}},
+		{"404", &client.UpstreamError{StatusCode: http.StatusNotFound}},
+		{"413", &client.UpstreamError{StatusCode: http.StatusRequestEntityTooLarge}},
+		{"422", &client.UpstreamError{StatusCode: http.StatusUnprocessableEntity}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lb := New(newTestStore(t, namedAccounts("a")...))
+			acc, err := lb.GetNextAccount()
+			if err != nil {
+				t.Fatal(err)
+			}
+			lb.Release(acc)
+
+			for i := 0; i < failureThreshold*2; i++ {
+				lb.ReportResult(acc, tt.err)
+			}
+			if h := lb.Health(acc); h != nil && (h.State != store.CircuitClosed || h.ConsecutiveFailures != 0) {
+				t.Errorf("health = %+v, want closed without failures", *h)
+			}
+		})
+	}
+
+	// 对照：同样次数的账号错误会熔断
+	lb := New(newTestStore(t, namedAccounts("a")...))
+	acc, _ := lb.GetNextAccount()
+	lb.Release(acc)
+	for i := 0; i < failureThreshold; i++ {
+		lb.ReportResult(acc, errors.New("connection reset"))
+	}
+	if h := lb.Health(acc); h == nil || h.State != store.CircuitOpen {
+		t.Errorf("health = %+v, want open", h)
+	}
+}
@@ -0,0 +1,43 @@
+package loadbalancer
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"orchids-api/internal/store"
+)
+
+// newTestStore 创建临时数据库并依次写入 accounts，写入后账号的 ID 已回填
+func newTestStore(t testing.TB, accounts ...*store.Account) *store.Store {
+	t.Helper()
+	s, err := store.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	for _, acc := range accounts {
+		if err := s.CreateAccount(acc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s
+}
+
+// namedAccounts 返回权重为 1 的启用账号
+func namedAccounts(names ...string) []*store.Account {
+	list := make([]*store.Account, len(names))
+	for i, name := range names {
+		list[i] = &store.Account{Name: name, Weight: 1, Enabled: true}
+	}
+	return list
+}
+
+// numberedAccounts 返回 n 个名为 acc-0、acc-1… 的启用账号
+func numberedAccounts(n int) []*store.Account {
+	names := make([]string, n)
+	for i := range names {
+		names[i] = fmt.Sprintf("acc-%d", i)
+	}
+	return namedAccounts(names...)
+}
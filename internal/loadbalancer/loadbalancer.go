@@ -2,21 +2,81 @@ package loadbalancer
 
 import (
 	"errors"
-	"math/rand"
+	"log"
 	"sync"
+	"time"
 
 	"orchids-api/internal/store"
 )
 
+// ErrNoAccounts 没有可用账号
+var ErrNoAccounts = errors.New("no enabled accounts available")
+
+// ErrAccountsBusy 有可用账号，但都已达到并发上限
+var ErrAccountsBusy = errors.New("all accounts are at max concurrency")
+
+// latencyAlpha 延迟指数加权平均中新样本的权重
+const latencyAlpha = 0.3
+
 type LoadBalancer struct {
-	store *store.Store
-	mu    sync.RWMutex
+	store  *store.Store
+	mu     sync.RWMutex
+	health map[int64]*accountHealth
+
+	accounts         []*store.Account // 启用账号的快照
+	accountsVersion  uint64
+	accountsLoadedAt time.Time
+	reloadMu         sync.Mutex
+
+	pending     map[int64]store.RequestCount // 尚未落库的请求计数
+	dirtyUsage  map[int64]bool               // 尚未落库的配额用量
+	dirtyHealth map[int64]bool               // 尚未落库的健康状态
+	flushMu     sync.Mutex
+
+	strategy          Strategy
+	strategySetting   string // settings 表中的原始值
+	strategyCheckedAt time.Time
+
+	inFlight map[int64]int
+	latency  map[int64]time.Duration
+	lastUsed map[int64]time.Time
+
+	usage     map[int64]*store.AccountUsage // 当前配额窗口的用量
+	locations map[string]*time.Location
+
+	sessionTTL      time.Duration
+	affinity        map[string]affinity
+	affinitySweptAt time.Time
+
+	queueSize    int
+	queueTimeout time.Duration
+	queue        []*waiter
+	queueSeq     uint64
+	queueStats   QueueStats
 }
 
 func New(s *store.Store) *LoadBalancer {
-	return &LoadBalancer{
-		store: s,
+	lb := &LoadBalancer{
+		store:    s,
+		health:   make(map[int64]*accountHealth),
+		inFlight: make(map[int64]int),
+		latency:  make(map[int64]time.Duration),
+		lastUsed: make(map[int64]time.Time),
+		affinity: make(map[string]affinity),
+
+		pending:     make(map[int64]store.RequestCount),
+		dirtyUsage:  make(map[int64]bool),
+		dirtyHealth: make(map[int64]bool),
+		usage:       make(map[int64]*store.AccountUsage),
+		locations:   make(map[string]*time.Location),
+
+		queueSize:    defaultQueueSize,
+		queueTimeout: defaultQueueTimeout,
 	}
+	lb.loadHealth()
+	lb.loadUsage()
+	lb.refreshStrategy(time.Now())
+	return lb
 }
 
 func (lb *LoadBalancer) GetNextAccount() (*store.Account, error) {
@@ -24,60 +84,192 @@ func (lb *LoadBalancer) GetNextAccount() (*store.Account, error) {
 }
 
 func (lb *LoadBalancer) GetNextAccountExcluding(excludeIDs []int64) (*store.Account, error) {
+	return lb.GetNextAccountForModel("", excludeIDs)
+}
+
+// GetNextAccountForModel 选择可以服务 model 的账号，model 为空时不限制
+func (lb *LoadBalancer) GetNextAccountForModel(model string, excludeIDs []int64) (*store.Account, error) {
+	return lb.GetNextAccountForSession("", model, excludeIDs)
+}
+
+// GetNextAccountForSession 选择可以服务 model 的账号；session 非空时优先使用该会话绑定的账号，
+// 绑定的账号不可用时按策略重新选择并改绑。不排队，全部账号满载时返回 ErrAccountsBusy。
+// 选中的账号计入进行中请求，请求结束后需调用 Release
+func (lb *LoadBalancer) GetNextAccountForSession(session, model string, excludeIDs []int64) (*store.Account, error) {
+	return lb.Select(Request{Session: session, Model: model, Exclude: excludeIDs})
+}
+
+// Select 按 req 选择账号，不排队；Priority 与 Timeout 不生效
+func (lb *LoadBalancer) Select(req Request) (*store.Account, error) {
+	accounts, err := lb.enabledAccounts()
+	if err != nil {
+		return nil, err
+	}
+
 	lb.mu.Lock()
 	defer lb.mu.Unlock()
+	return lb.selectLocked(accounts, req, time.Now())
+}
 
-	accounts, err := lb.store.GetEnabledAccounts()
+// selectLocked 从启用的账号中为 req 选择一个并计入进行中请求。调用方需持有 lb.mu
+func (lb *LoadBalancer) selectLocked(accounts []*store.Account, req Request, now time.Time) (*store.Account, error) {
+	eligible, available, err := lb.candidatesLocked(accounts, req, req.Group, now)
+	if len(available) == 0 && req.Group != "" && req.Spillover {
+		if e, a, _ := lb.candidatesLocked(accounts, req, "", now); len(a) > 0 {
+			log.Printf("分组 %s 没有可用账号 (%v)，溢出到其他分组", req.Group, err)
+			eligible, available, err = e, a, nil
+		}
+	}
 	if err != nil {
 		return nil, err
 	}
+	// 只使用可用账号中优先级最高的一层
+	available = topTier(available)
+
+	pinned := lb.pinnedAccount(req.Session, eligible, now)
+	if pinned != nil && pinned.Priority > available[0].Priority {
+		log.Printf("会话绑定的账号 %s 优先级较低，改用优先级 %d 的账号", pinned.Name, available[0].Priority)
+		pinned = nil
+	}
+	account := pinned
+	if pinned != nil && lb.saturated(pinned) {
+		log.Printf("会话绑定的账号 %s 并发已满，本次临时使用其他账号", pinned.Name)
+		account = nil
+	}
+	if account == nil {
+		account = lb.strategy.Select(available, lb.statsOf)
+	}
+	// 绑定的账号只是暂时满载时保留原绑定
+	if pinned == nil || account == pinned {
+		lb.bindSession(req.Session, account, now)
+	}
+	lb.markProbe(account, now)
+
+	lb.inFlight[account.ID]++
+	lb.lastUsed[account.ID] = now
+	lb.countRequest(account, now)
+	lb.addUsage(account, 1, 0, now)
+
+	return account, nil
+}
 
-	if len(excludeIDs) > 0 {
-		excludeSet := make(map[int64]bool)
-		for _, id := range excludeIDs {
-			excludeSet[id] = true
+// candidatesLocked 返回 group 内可以服务 req 的账号（eligible）及其中并发未满的账号（available），
+// 没有可用账号时 err 说明原因。调用方需持有 lb.mu
+func (lb *LoadBalancer) candidatesLocked(accounts []*store.Account, req Request, group string, now time.Time) (eligible, available []*store.Account, err error) {
+	excludeSet := make(map[int64]bool)
+	for _, id := range req.Exclude {
+		excludeSet[id] = true
+	}
+	// 排除其他分组、本次请求已失败、不支持该模型、熔断中以及配额已用完的账号
+	quotaExhausted := false
+	for _, acc := range accounts {
+		if !acc.InGroup(group) || excludeSet[acc.ID] || !acc.Supports(req.Model) || !lb.admits(acc, now) {
+			continue
 		}
-		var filtered []*store.Account
-		for _, acc := range accounts {
-			if !excludeSet[acc.ID] {
-				filtered = append(filtered, acc)
-			}
+		if lb.exhausted(acc, now) {
+			quotaExhausted = true
+			continue
+		}
+		eligible = append(eligible, acc)
+	}
+	if len(eligible) == 0 {
+		if quotaExhausted {
+			return nil, nil, ErrQuotaExhausted
 		}
-		accounts = filtered
+		return nil, nil, ErrNoAccounts
 	}
 
-	if len(accounts) == 0 {
-		return nil, errors.New("no enabled accounts available")
+	// 跳过并发已满的账号
+	for _, acc := range eligible {
+		if !lb.saturated(acc) {
+			available = append(available, acc)
+		}
+	}
+	if len(available) == 0 {
+		return eligible, nil, ErrAccountsBusy
 	}
+	return eligible, available, nil
+}
 
-	account := lb.selectAccount(accounts)
+// topTier 返回 priority 数值最小的账号
+func topTier(accounts []*store.Account) []*store.Account {
+	best := accounts[0].Priority
+	for _, acc := range accounts[1:] {
+		if acc.Priority < best {
+			best = acc.Priority
+		}
+	}
+	tier := accounts[:0:0]
+	for _, acc := range accounts {
+		if acc.Priority == best {
+			tier = append(tier, acc)
+		}
+	}
+	return tier
+}
 
-	if err := lb.store.IncrementRequestCount(account.ID); err != nil {
-		return nil, err
+// saturated 账号进行中的请求是否已达到并发上限。调用方需持有 lb.mu
+func (lb *LoadBalancer) saturated(acc *store.Account) bool {
+	return acc.MaxConcurrency > 0 && lb.inFlight[acc.ID] >= acc.MaxConcurrency
+}
+
+// Release 结束账号上的一个进行中请求，acc 为 nil（默认配置）时忽略
+func (lb *LoadBalancer) Release(acc *store.Account) {
+	if acc == nil {
+		return
 	}
 
-	return account, nil
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	lb.releaseLocked(acc)
 }
 
-func (lb *LoadBalancer) selectAccount(accounts []*store.Account) *store.Account {
-	if len(accounts) == 1 {
-		return accounts[0]
+// releaseLocked 释放占用并把空出的名额交给排队的请求。调用方需持有 lb.mu
+func (lb *LoadBalancer) releaseLocked(acc *store.Account) {
+	if lb.inFlight[acc.ID] <= 1 {
+		delete(lb.inFlight, acc.ID)
+	} else {
+		lb.inFlight[acc.ID]--
 	}
+	lb.dispatchLocked(time.Now())
+}
 
-	var totalWeight int
-	for _, acc := range accounts {
-		totalWeight += acc.Weight
+// ObserveLatency 记录一次上游响应延迟，更新账号的指数加权平均
+func (lb *LoadBalancer) ObserveLatency(acc *store.Account, d time.Duration) {
+	if acc == nil || d <= 0 {
+		return
 	}
 
-	randomWeight := rand.Intn(totalWeight)
-	currentWeight := 0
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
 
-	for _, acc := range accounts {
-		currentWeight += acc.Weight
-		if currentWeight > randomWeight {
-			return acc
-		}
+	if prev, ok := lb.latency[acc.ID]; ok {
+		d = time.Duration(latencyAlpha*float64(d) + (1-latencyAlpha)*float64(prev))
+	}
+	lb.latency[acc.ID] = d
+}
+
+// Stats 返回账号的运行时指标
+func (lb *LoadBalancer) Stats(acc *store.Account) AccountStats {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+	return lb.statsOf(acc)
+}
+
+// statsOf 调用方需持有 lb.mu；最近使用时间取内存与数据库记录中较新的一个
+func (lb *LoadBalancer) statsOf(acc *store.Account) AccountStats {
+	lastUsed := acc.LastUsedAt
+	if t := lb.lastUsed[acc.ID]; t.After(lastUsed) {
+		lastUsed = t
 	}
+	return AccountStats{
+		InFlight:   lb.inFlight[acc.ID],
+		Latency:    lb.latency[acc.ID],
+		LastUsedAt: lastUsed,
+	}
+}
 
-	return accounts[0]
+// EnabledAccounts 返回全部启用的账号（快照，只读）
+func (lb *LoadBalancer) EnabledAccounts() ([]*store.Account, error) {
+	return lb.enabledAccounts()
 }
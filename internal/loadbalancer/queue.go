@@ -0,0 +1,239 @@
+package loadbalancer
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"orchids-api/internal/store"
+)
+
+// 排队失败的原因
+var (
+	ErrQueueFull    = errors.New("account wait queue is full")
+	ErrQueueTimeout = errors.New("timed out waiting for an available account")
+)
+
+const (
+	defaultQueueSize    = 100
+	defaultQueueTimeout = 30 * time.Second
+	// queuePollInterval 除释放名额外，排队请求定期重试，以感知新增、启用或熔断恢复的账号
+	queuePollInterval = time.Second
+)
+
+// Request 一次账号选择的条件
+type Request struct {
+	Session   string
+	Model     string
+	Exclude   []int64
+	Group     string        // 只使用该分组的账号，为空时不限制
+	Spillover bool          // 分组内没有可用账号时允许使用其他账号
+	Priority  int           // 排队优先级，数值大的先分配，相同时先到先得
+	Timeout   time.Duration // 最长排队时间，0 或超过队列配置时使用队列配置
+}
+
+// QueueInfo 一次获取的排队情况
+type QueueInfo struct {
+	Depth int           // 入队时的队列长度（含自身），未排队为 0
+	Wait  time.Duration // 排队耗时
+}
+
+// QueueStats 等待队列的累计统计
+type QueueStats struct {
+	Depth       int   `json:"depth"`
+	Size        int   `json:"size"`
+	Enqueued    int64 `json:"enqueued"`
+	Served      int64 `json:"served"`
+	TimedOut    int64 `json:"timed_out"`
+	Cancelled   int64 `json:"cancelled"`
+	Rejected    int64 `json:"rejected"` // 队列已满被拒绝
+	TotalWaitMs int64 `json:"total_wait_ms"`
+	MaxWaitMs   int64 `json:"max_wait_ms"`
+}
+
+// waiter 排队中的请求，account / err 在 ready 关闭前写入
+type waiter struct {
+	req      Request
+	seq      uint64
+	enqueued time.Time
+	ready    chan struct{}
+	account  *store.Account
+	err      error
+}
+
+// before 出队顺序：优先级高的在前，相同时先入队的在前
+func (w *waiter) before(o *waiter) bool {
+	if w.req.Priority != o.req.Priority {
+		return w.req.Priority > o.req.Priority
+	}
+	return w.seq < o.seq
+}
+
+// SetQueue 设置等待队列的容量与默认超时，size 为 0 时不排队
+func (lb *LoadBalancer) SetQueue(size int, timeout time.Duration) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	lb.queueSize = size
+	lb.queueTimeout = timeout
+}
+
+// QueueStats 返回等待队列的统计
+func (lb *LoadBalancer) QueueStats() QueueStats {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+	stats := lb.queueStats
+	stats.Depth = len(lb.queue)
+	stats.Size = lb.queueSize
+	return stats
+}
+
+// Acquire 选择账号；全部可用账号都满载时进入等待队列，直到有账号空出、超时或 ctx 取消。
+// 已有请求排队时新请求排在其后，不会插队
+func (lb *LoadBalancer) Acquire(ctx context.Context, req Request) (*store.Account, QueueInfo, error) {
+	accounts, err := lb.enabledAccounts()
+	if err != nil {
+		return nil, QueueInfo{}, err
+	}
+
+	lb.mu.Lock()
+
+	now := time.Now()
+	if len(lb.queue) == 0 {
+		account, err := lb.selectLocked(accounts, req, now)
+		if err != ErrAccountsBusy || lb.queueSize <= 0 {
+			lb.mu.Unlock()
+			return account, QueueInfo{}, err
+		}
+	}
+	if len(lb.queue) >= lb.queueSize {
+		lb.queueStats.Rejected++
+		lb.mu.Unlock()
+		return nil, QueueInfo{}, ErrQueueFull
+	}
+
+	lb.queueSeq++
+	w := &waiter{req: req, seq: lb.queueSeq, enqueued: now, ready: make(chan struct{})}
+	lb.enqueueLocked(w)
+	info := QueueInfo{Depth: len(lb.queue)}
+	timeout := lb.queueTimeout
+	if req.Timeout > 0 && req.Timeout < timeout {
+		timeout = req.Timeout
+	}
+	// 前面的请求可能在等待其他模型的账号，新请求或许可以立即分配
+	lb.dispatchAccountsLocked(accounts, now)
+	lb.mu.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	ticker := time.NewTicker(queuePollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.ready:
+			info.Wait = time.Since(w.enqueued)
+			return w.account, info, w.err
+		case <-ticker.C:
+			// 定期重试时刷新快照，以感知新增或启用的账号
+			accounts, err := lb.enabledAccounts()
+			if err != nil {
+				log.Printf("分配排队请求失败: %v", err)
+				continue
+			}
+			lb.mu.Lock()
+			lb.dispatchAccountsLocked(accounts, time.Now())
+			lb.mu.Unlock()
+		case <-timer.C:
+			return lb.abandon(w, info, ErrQueueTimeout)
+		case <-ctx.Done():
+			return lb.abandon(w, info, ctx.Err())
+		}
+	}
+}
+
+// enqueueLocked 按出队顺序插入。调用方需持有 lb.mu
+func (lb *LoadBalancer) enqueueLocked(w *waiter) {
+	i := len(lb.queue)
+	for i > 0 && w.before(lb.queue[i-1]) {
+		i--
+	}
+	lb.queue = append(lb.queue, nil)
+	copy(lb.queue[i+1:], lb.queue[i:])
+	lb.queue[i] = w
+	lb.queueStats.Enqueued++
+}
+
+// dispatchLocked 按顺序为排队的请求分配账号，使用当前快照。调用方需持有 lb.mu
+func (lb *LoadBalancer) dispatchLocked(now time.Time) {
+	if len(lb.queue) == 0 {
+		return
+	}
+	lb.dispatchAccountsLocked(lb.accounts, now)
+}
+
+func (lb *LoadBalancer) dispatchAccountsLocked(accounts []*store.Account, now time.Time) {
+	remaining := lb.queue[:0]
+	for _, w := range lb.queue {
+		account, err := lb.selectLocked(accounts, w.req, now)
+		if err == ErrAccountsBusy {
+			remaining = append(remaining, w)
+			continue
+		}
+		lb.finishWaiterLocked(w, account, err, now)
+	}
+	for i := len(remaining); i < len(lb.queue); i++ {
+		lb.queue[i] = nil
+	}
+	lb.queue = remaining
+}
+
+func (lb *LoadBalancer) finishWaiterLocked(w *waiter, account *store.Account, err error, now time.Time) {
+	w.account, w.err = account, err
+	if err == nil {
+		lb.queueStats.Served++
+		lb.recordWaitLocked(now.Sub(w.enqueued))
+	}
+	close(w.ready)
+}
+
+func (lb *LoadBalancer) recordWaitLocked(wait time.Duration) {
+	ms := wait.Milliseconds()
+	lb.queueStats.TotalWaitMs += ms
+	if ms > lb.queueStats.MaxWaitMs {
+		lb.queueStats.MaxWaitMs = ms
+	}
+}
+
+// abandon 超时或取消时移出队列。如果恰好已分配到账号：超时仍返回该账号，取消则归还
+func (lb *LoadBalancer) abandon(w *waiter, info QueueInfo, reason error) (*store.Account, QueueInfo, error) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	info.Wait = time.Since(w.enqueued)
+	select {
+	case <-w.ready:
+		if reason == ErrQueueTimeout || w.err != nil {
+			return w.account, info, w.err
+		}
+		lb.healthOf(w.account.ID).probing = false
+		lb.releaseLocked(w.account)
+		return nil, info, reason
+	default:
+	}
+
+	for i, q := range lb.queue {
+		if q == w {
+			lb.queue = append(lb.queue[:i], lb.queue[i+1:]...)
+			break
+		}
+	}
+	lb.recordWaitLocked(info.Wait)
+	if reason == ErrQueueTimeout {
+		lb.queueStats.TimedOut++
+		log.Printf("排队等待账号超时 (%v)", info.Wait.Round(time.Millisecond))
+	} else {
+		lb.queueStats.Cancelled++
+	}
+	return nil, info, reason
+}
@@ -0,0 +1,138 @@
+package loadbalancer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"orchids-api/internal/store"
+)
+
+// newQueueTestLB 创建只有一个并发为 1 的账号的负载均衡，并占用该账号
+func newQueueTestLB(t *testing.T) (*LoadBalancer, *store.Account) {
+	lb := New(newTestStore(t, &store.Account{Name: "a", Weight: 1, MaxConcurrency: 1, Enabled: true}))
+	held, _, err := lb.Acquire(context.Background(), Request{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lb, held
+}
+
+// waitDepth 等待队列长度达到 n
+func waitDepth(t *testing.T, lb *LoadBalancer, n int) {
+	deadline := time.Now().Add(time.Second)
+	for lb.QueueStats().Depth != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("queue depth = %d, want %d", lb.QueueStats().Depth, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestQueueOrder(t *testing.T) {
+	lb, held := newQueueTestLB(t)
+
+	type result struct {
+		name string
+		info QueueInfo
+	}
+	results := make(chan result, 3)
+	enqueue := func(name string, priority int) {
+		go func() {
+			acc, info, err := lb.Acquire(context.Background(), Request{Priority: priority})
+			if err != nil {
+				t.Errorf("%s: %v", name, err)
+				return
+			}
+			results <- result{name, info}
+			lb.Release(acc)
+		}()
+	}
+
+	// 优先级高的先分配，相同优先级先到先得
+	enqueue("low-1", 0)
+	waitDepth(t, lb, 1)
+	enqueue("low-2", 0)
+	waitDepth(t, lb, 2)
+	enqueue("high", 5)
+	waitDepth(t, lb, 3)
+
+	lb.Release(held)
+	var order []string
+	for i := 0; i < 3; i++ {
+		r := <-results
+		order = append(order, r.name)
+		if r.info.Depth == 0 {
+			t.Errorf("%s: depth = 0, want queued", r.name)
+		}
+	}
+	want := []string{"high", "low-1", "low-2"}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("order = %v, want %v", order, want)
+		}
+	}
+
+	stats := lb.QueueStats()
+	if stats.Depth != 0 || stats.Enqueued != 3 || stats.Served != 3 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestQueueTimeoutAndCancel(t *testing.T) {
+	lb, _ := newQueueTestLB(t)
+
+	_, info, err := lb.Acquire(context.Background(), Request{Timeout: 20 * time.Millisecond})
+	if err != ErrQueueTimeout {
+		t.Errorf("err = %v, want %v", err, ErrQueueTimeout)
+	}
+	if info.Depth != 1 || info.Wait < 20*time.Millisecond {
+		t.Errorf("info = %+v", info)
+	}
+
+	// 客户端断开时离开队列
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, _, err := lb.Acquire(ctx, Request{})
+		done <- err
+	}()
+	waitDepth(t, lb, 1)
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("err = %v, want %v", err, context.Canceled)
+	}
+
+	stats := lb.QueueStats()
+	if stats.Depth != 0 || stats.TimedOut != 1 || stats.Cancelled != 1 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestQueueFull(t *testing.T) {
+	lb, held := newQueueTestLB(t)
+	lb.SetQueue(1, time.Minute)
+
+	done := make(chan error)
+	go func() {
+		acc, _, err := lb.Acquire(context.Background(), Request{})
+		lb.Release(acc)
+		done <- err
+	}()
+	waitDepth(t, lb, 1)
+
+	if _, _, err := lb.Acquire(context.Background(), Request{}); err != ErrQueueFull {
+		t.Errorf("err = %v, want %v", err, ErrQueueFull)
+	}
+	lb.Release(held)
+	if err := <-done; err != nil {
+		t.Errorf("queued request: %v", err)
+	}
+
+	// 不排队时直接返回满载
+	lb.SetQueue(0, time.Minute)
+	held, _, _ = lb.Acquire(context.Background(), Request{})
+	if _, _, err := lb.Acquire(context.Background(), Request{}); err != ErrAccountsBusy {
+		t.Errorf("err = %v, want %v", err, ErrAccountsBusy)
+	}
+}
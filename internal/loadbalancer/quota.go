@@ -0,0 +1,144 @@
+package loadbalancer
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"orchids-api/internal/store"
+)
+
+// ErrQuotaExhausted 有可用账号，但都已用完当前窗口的配额
+var ErrQuotaExhausted = errors.New("all accounts have exhausted their quota")
+
+// loadUsage 从 store 恢复配额用量
+func (lb *LoadBalancer) loadUsage() {
+	list, err := lb.store.ListAccountUsage()
+	if err != nil {
+		log.Printf("加载账号配额用量失败: %v", err)
+		return
+	}
+	lb.usage = list
+}
+
+// location 返回账号配额所在的时区，无效时按 UTC。调用方需持有 lb.mu
+func (lb *LoadBalancer) location(acc *store.Account) *time.Location {
+	name := acc.QuotaTimezone
+	if name == "" {
+		return time.UTC
+	}
+	if loc, ok := lb.locations[name]; ok {
+		return loc
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		log.Printf("账号 %s 的配额时区 %q 无效，按 UTC 计算: %v", acc.Name, name, err)
+		loc = time.UTC
+	}
+	lb.locations[name] = loc
+	return loc
+}
+
+// quotaWindow 返回 now 所在配额窗口的起止时间。调用方需持有 lb.mu
+func (lb *LoadBalancer) quotaWindow(acc *store.Account, now time.Time) (start, end time.Time) {
+	t := now.In(lb.location(acc))
+	if acc.QuotaWindow == store.QuotaHourly {
+		start = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location())
+		return start, start.Add(time.Hour)
+	}
+	start = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	return start, start.AddDate(0, 0, 1)
+}
+
+// usageOf 返回账号当前窗口的用量，进入新窗口时清零。调用方需持有 lb.mu
+func (lb *LoadBalancer) usageOf(acc *store.Account, now time.Time) *store.AccountUsage {
+	start, _ := lb.quotaWindow(acc, now)
+	u, ok := lb.usage[acc.ID]
+	if !ok {
+		u = &store.AccountUsage{AccountID: acc.ID, WindowStart: start}
+		lb.usage[acc.ID] = u
+		return u
+	}
+	if !u.WindowStart.Equal(start) {
+		if lb.exhaustedBy(acc, u) {
+			log.Printf("账号 %s 配额窗口已重置，恢复使用", acc.Name)
+		}
+		u.WindowStart = start
+		u.Requests = 0
+		u.Tokens = 0
+	}
+	return u
+}
+
+func (lb *LoadBalancer) exhaustedBy(acc *store.Account, u *store.AccountUsage) bool {
+	return (acc.RequestQuota > 0 && u.Requests >= acc.RequestQuota) ||
+		(acc.TokenQuota > 0 && u.Tokens >= acc.TokenQuota)
+}
+
+// exhausted 账号是否已用完当前窗口的配额。调用方需持有 lb.mu
+func (lb *LoadBalancer) exhausted(acc *store.Account, now time.Time) bool {
+	if acc.RequestQuota <= 0 && acc.TokenQuota <= 0 {
+		return false
+	}
+	return lb.exhaustedBy(acc, lb.usageOf(acc, now))
+}
+
+// addUsage 累加用量，由 Flush 批量落库，刚好用完时记录日志。调用方需持有 lb.mu
+func (lb *LoadBalancer) addUsage(acc *store.Account, requests, tokens int64, now time.Time) {
+	u := lb.usageOf(acc, now)
+	wasExhausted := lb.exhaustedBy(acc, u)
+	u.Requests += requests
+	u.Tokens += tokens
+	if !wasExhausted && lb.exhaustedBy(acc, u) {
+		_, end := lb.quotaWindow(acc, now)
+		log.Printf("账号 %s 配额已用完 (请求 %d, tokens %d)，%s 重置", acc.Name, u.Requests, u.Tokens, end.Format(time.RFC3339))
+	}
+	lb.dirtyUsage[acc.ID] = true
+}
+
+// RecordTokens 累加账号消耗的 token，acc 为 nil（默认配置）时忽略
+func (lb *LoadBalancer) RecordTokens(acc *store.Account, tokens int) {
+	if acc == nil || tokens <= 0 {
+		return
+	}
+
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	lb.addUsage(acc, 0, int64(tokens), time.Now())
+}
+
+// Quota 返回账号当前窗口的配额使用情况
+func (lb *LoadBalancer) Quota(acc *store.Account) store.AccountQuota {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	now := time.Now()
+	u := lb.usageOf(acc, now)
+	start, end := lb.quotaWindow(acc, now)
+	q := store.AccountQuota{
+		Window:       acc.QuotaWindow,
+		WindowStart:  start,
+		ResetsAt:     end,
+		RequestsUsed: u.Requests,
+		TokensUsed:   u.Tokens,
+		Exhausted:    lb.exhaustedBy(acc, u),
+	}
+	if q.Window != store.QuotaHourly {
+		q.Window = store.QuotaDaily
+	}
+	if acc.RequestQuota > 0 {
+		q.RequestsRemaining = remaining(acc.RequestQuota, u.Requests)
+	}
+	if acc.TokenQuota > 0 {
+		q.TokensRemaining = remaining(acc.TokenQuota, u.Tokens)
+	}
+	return q
+}
+
+func remaining(quota, used int64) *int64 {
+	n := quota - used
+	if n < 0 {
+		n = 0
+	}
+	return &n
+}
@@ -0,0 +1,71 @@
+package loadbalancer
+
+import (
+	"testing"
+	"time"
+
+	"orchids-api/internal/store"
+)
+
+func TestQuotaWindow(t *testing.T) {
+	lb := &LoadBalancer{locations: make(map[string]*time.Location)}
+	now := time.Date(2025, 3, 1, 17, 30, 0, 0, time.UTC)
+	tests := []struct {
+		window, tz string
+		start      time.Time
+		end        time.Time
+	}{
+		{"", "", time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2025, 3, 2, 0, 0, 0, 0, time.UTC)},
+		{store.QuotaHourly, "", time.Date(2025, 3, 1, 17, 0, 0, 0, time.UTC), time.Date(2025, 3, 1, 18, 0, 0, 0, time.UTC)},
+		// 上海时间已是 3 月 2 日 01:30
+		{store.QuotaDaily, "Asia/Shanghai", time.Date(2025, 3, 1, 16, 0, 0, 0, time.UTC), time.Date(2025, 3, 2, 16, 0, 0, 0, time.UTC)},
+		{store.QuotaDaily, "Invalid/Zone", time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2025, 3, 2, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		acc := &store.Account{QuotaWindow: tt.window, QuotaTimezone: tt.tz}
+		start, end := lb.quotaWindow(acc, now)
+		if !start.Equal(tt.start) || !end.Equal(tt.end) {
+			t.Errorf("%s %s: window = %v - %v, want %v - %v", tt.window, tt.tz, start, end, tt.start, tt.end)
+		}
+	}
+}
+
+func TestQuotaExhaustion(t *testing.T) {
+	s := newTestStore(t, &store.Account{Name: "a", Weight: 1, RequestQuota: 2, TokenQuota: 1000, QuotaWindow: store.QuotaHourly, Enabled: true})
+
+	lb := New(s)
+	first, err := lb.GetNextAccount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lb.Release(first)
+	lb.RecordTokens(first, 300)
+
+	q := lb.Quota(first)
+	if *q.RequestsRemaining != 1 || *q.TokensRemaining != 700 || q.Exhausted {
+		t.Errorf("quota = %+v, requests remaining %d, tokens remaining %d", q, *q.RequestsRemaining, *q.TokensRemaining)
+	}
+
+	// token 用完后不再选择该账号
+	lb.RecordTokens(first, 700)
+	if _, err := lb.GetNextAccount(); err != ErrQuotaExhausted {
+		t.Errorf("err = %v, want %v", err, ErrQuotaExhausted)
+	}
+
+	// 用量落库后重启仍然生效
+	if err := lb.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	lb = New(s)
+	if q := lb.Quota(first); !q.Exhausted || q.RequestsUsed != 1 || q.TokensUsed != 1000 {
+		t.Errorf("after restart quota = %+v", q)
+	}
+
+	// 进入下一个窗口后恢复
+	lb.mu.Lock()
+	exhausted := lb.exhausted(first, time.Now().Add(time.Hour))
+	lb.mu.Unlock()
+	if exhausted {
+		t.Error("account still exhausted in next window")
+	}
+}
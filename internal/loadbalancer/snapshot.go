@@ -0,0 +1,146 @@
+package loadbalancer
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"orchids-api/internal/store"
+)
+
+// accountsRefreshInterval 账号快照的最长有效期，直接修改数据库后最多延迟该时间生效
+const accountsRefreshInterval = 30 * time.Second
+
+// enabledAccounts 返回启用账号的快照，通过 store 修改账号后或快照过期时重新加载，
+// 同时按间隔刷新策略设置，选择账号时不再访问数据库。快照与其中的账号只读。调用方不能持有 lb.mu
+func (lb *LoadBalancer) enabledAccounts() ([]*store.Account, error) {
+	lb.refreshStrategy(time.Now())
+
+	version := lb.store.AccountsVersion()
+	lb.mu.RLock()
+	accounts, fresh := lb.accounts, lb.snapshotFresh(version, time.Now())
+	lb.mu.RUnlock()
+	if fresh {
+		return accounts, nil
+	}
+
+	// 同一时间只有一个请求重新加载，其余等待后使用新快照
+	lb.reloadMu.Lock()
+	defer lb.reloadMu.Unlock()
+	version = lb.store.AccountsVersion()
+	lb.mu.RLock()
+	accounts, fresh = lb.accounts, lb.snapshotFresh(version, time.Now())
+	lb.mu.RUnlock()
+	if fresh {
+		return accounts, nil
+	}
+
+	accounts, err := lb.store.GetEnabledAccounts()
+	if err != nil {
+		return nil, err
+	}
+	lb.mu.Lock()
+	lb.accounts = accounts
+	lb.accountsVersion = version
+	lb.accountsLoadedAt = time.Now()
+	lb.mu.Unlock()
+	return accounts, nil
+}
+
+// snapshotFresh 调用方需持有 lb.mu
+func (lb *LoadBalancer) snapshotFresh(version uint64, now time.Time) bool {
+	return !lb.accountsLoadedAt.IsZero() && lb.accountsVersion == version &&
+		now.Sub(lb.accountsLoadedAt) < accountsRefreshInterval
+}
+
+// countRequest 累计账号的请求数，由 Flush 批量落库。调用方需持有 lb.mu
+func (lb *LoadBalancer) countRequest(acc *store.Account, now time.Time) {
+	c := lb.pending[acc.ID]
+	c.Count++
+	c.LastUsedAt = now
+	lb.pending[acc.ID] = c
+}
+
+// StartFlusher 按 interval 定期落库请求计数、配额用量与健康状态，ctx 取消时最后写入一次
+func (lb *LoadBalancer) StartFlusher(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				lb.Flush()
+				return
+			case <-ticker.C:
+				lb.Flush()
+			}
+		}
+	}()
+}
+
+// Flush 立即落库累计的请求计数、配额用量与健康状态，写入失败的部分保留到下次
+func (lb *LoadBalancer) Flush() error {
+	lb.flushMu.Lock()
+	defer lb.flushMu.Unlock()
+
+	lb.mu.Lock()
+	counts := lb.pending
+	lb.pending = make(map[int64]store.RequestCount)
+	usage := make([]*store.AccountUsage, 0, len(lb.dirtyUsage))
+	for id := range lb.dirtyUsage {
+		if u, ok := lb.usage[id]; ok {
+			copied := *u
+			usage = append(usage, &copied)
+		}
+	}
+	dirty := lb.dirtyUsage
+	lb.dirtyUsage = make(map[int64]bool)
+	health := make([]*store.AccountHealth, 0, len(lb.dirtyHealth))
+	for id := range lb.dirtyHealth {
+		if h, ok := lb.health[id]; ok {
+			state := h.AccountHealth
+			health = append(health, &state)
+		}
+	}
+	dirtyHealth := lb.dirtyHealth
+	lb.dirtyHealth = make(map[int64]bool)
+	lb.mu.Unlock()
+
+	countErr := lb.store.AddRequestCounts(counts)
+	usageErr := lb.store.SaveAccountUsage(usage...)
+	healthErr := lb.store.SaveAccountHealth(health...)
+	if countErr == nil && usageErr == nil && healthErr == nil {
+		return nil
+	}
+
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	if countErr != nil {
+		log.Printf("写入账号请求计数失败: %v", countErr)
+		for id, c := range counts {
+			p := lb.pending[id]
+			p.Count += c.Count
+			if c.LastUsedAt.After(p.LastUsedAt) {
+				p.LastUsedAt = c.LastUsedAt
+			}
+			lb.pending[id] = p
+		}
+	}
+	if healthErr != nil {
+		log.Printf("写入账号健康状态失败: %v", healthErr)
+		for id := range dirtyHealth {
+			lb.dirtyHealth[id] = true
+		}
+	}
+	if usageErr != nil {
+		log.Printf("写入账号配额用量失败: %v", usageErr)
+		for id := range dirty {
+			lb.dirtyUsage[id] = true
+		}
+		return usageErr
+	}
+	if healthErr != nil {
+		return healthErr
+	}
+	return countErr
+}
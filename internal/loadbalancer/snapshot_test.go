@@ -0,0 +1,107 @@
+package loadbalancer
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"orchids-api/internal/store"
+)
+
+func TestAccountSnapshot(t *testing.T) {
+	s := newTestStore(t, namedAccounts("a")...)
+	lb := New(s)
+
+	acc, err := lb.GetNextAccount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lb.Release(acc)
+
+	// 通过 store 修改账号后立即重新加载
+	disabled := *acc
+	disabled.Enabled = false
+	if err := s.UpdateAccount(&disabled); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lb.GetNextAccount(); err != ErrNoAccounts {
+		t.Errorf("err = %v, want %v", err, ErrNoAccounts)
+	}
+}
+
+func TestFlushRequestCounts(t *testing.T) {
+	s := newTestStore(t, namedAccounts("a")...)
+	lb := New(s)
+
+	for i := 0; i < 3; i++ {
+		acc, err := lb.GetNextAccount()
+		if err != nil {
+			t.Fatal(err)
+		}
+		lb.Release(acc)
+	}
+
+	// 选择账号时不写数据库
+	acc, _ := s.GetAccount(1)
+	if acc.RequestCount != 0 {
+		t.Errorf("request count before flush = %d, want 0", acc.RequestCount)
+	}
+
+	if err := lb.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	acc, _ = s.GetAccount(1)
+	if acc.RequestCount != 3 || acc.LastUsedAt.IsZero() {
+		t.Errorf("after flush request count = %d, last used %v", acc.RequestCount, acc.LastUsedAt)
+	}
+	if err := lb.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if acc, _ = s.GetAccount(1); acc.RequestCount != 3 {
+		t.Errorf("request count after second flush = %d, want 3", acc.RequestCount)
+	}
+}
+
+// BenchmarkGetNextAccount 快照 + 批量计数下的并发选择
+func BenchmarkGetNextAccount(b *testing.B) {
+	lb := New(newTestStore(b, numberedAccounts(10)...))
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			acc, err := lb.GetNextAccount()
+			if err != nil {
+				b.Error(err)
+				return
+			}
+			lb.Release(acc)
+		}
+	})
+}
+
+// BenchmarkGetNextAccountFromStore 对照组：每次选择都读取账号表并写入请求计数（改造前的做法）
+func BenchmarkGetNextAccountFromStore(b *testing.B) {
+	s := newTestStore(b, numberedAccounts(10)...)
+	lb := New(s)
+	var mu sync.Mutex
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			mu.Lock()
+			accounts, err := s.GetEnabledAccounts()
+			if err != nil {
+				mu.Unlock()
+				b.Error(err)
+				return
+			}
+			lb.mu.Lock()
+			acc := lb.strategy.Select(accounts, lb.statsOf)
+			lb.mu.Unlock()
+			err = s.AddRequestCounts(map[int64]store.RequestCount{acc.ID: {Count: 1, LastUsedAt: time.Now()}})
+			mu.Unlock()
+			if err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
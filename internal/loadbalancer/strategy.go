@@ -0,0 +1,285 @@
+package loadbalancer
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"time"
+
+	"orchids-api/internal/store"
+)
+
+// 可选的账号选择策略，通过 settings 表的 lb_strategy 切换
+const (
+	StrategyWeightedRandom     = "weighted_random"
+	StrategyWeightedRoundRobin = "weighted_round_robin"
+	StrategyLeastRecentlyUsed  = "least_recently_used"
+	StrategyLeastInFlight      = "least_in_flight"
+	StrategyLatencyEWMA        = "latency_ewma"
+)
+
+// AccountStats 负载均衡记录的账号运行时指标
+type AccountStats struct {
+	InFlight   int           `json:"in_flight"`
+	Latency    time.Duration `json:"latency_ewma"` // 首个上游事件耗时的指数加权平均，0 表示尚无样本
+	LastUsedAt time.Time     `json:"last_used_at"`
+}
+
+// StatsFunc 返回账号当前的运行时指标
+type StatsFunc func(acc *store.Account) AccountStats
+
+// Strategy 从非空的候选账号中选择一个。调用方持有负载均衡的锁，实现可以保存内部状态
+type Strategy interface {
+	Name() string
+	Select(candidates []*store.Account, stats StatsFunc) *store.Account
+}
+
+// strategySettingKey settings 表中保存当前策略的键
+const strategySettingKey = "lb_strategy"
+
+// strategyRefreshInterval 重新读取策略设置的最短间隔，直接修改数据库后最多延迟该时间生效
+const strategyRefreshInterval = 5 * time.Second
+
+// StrategyNames 全部可用策略
+func StrategyNames() []string {
+	return []string{
+		StrategyWeightedRandom,
+		StrategyWeightedRoundRobin,
+		StrategyLeastRecentlyUsed,
+		StrategyLeastInFlight,
+		StrategyLatencyEWMA,
+	}
+}
+
+// NewStrategy 按名称创建策略，名称未知时返回 false
+func NewStrategy(name string) (Strategy, bool) {
+	switch name {
+	case StrategyWeightedRandom:
+		return &weightedRandom{intn: rand.Intn}, true
+	case StrategyWeightedRoundRobin:
+		return &weightedRoundRobin{current: make(map[int64]int)}, true
+	case StrategyLeastRecentlyUsed:
+		return leastRecentlyUsed{}, true
+	case StrategyLeastInFlight:
+		return leastInFlight{}, true
+	case StrategyLatencyEWMA:
+		return latencyEWMA{}, true
+	}
+	return nil, false
+}
+
+// StrategyName 返回当前使用的策略
+func (lb *LoadBalancer) StrategyName() string {
+	lb.refreshStrategy(time.Now())
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+	return lb.strategy.Name()
+}
+
+// SetStrategy 切换策略并写入 settings 表，立即生效
+func (lb *LoadBalancer) SetStrategy(name string) error {
+	strategy, ok := NewStrategy(name)
+	if !ok {
+		return fmt.Errorf("unknown strategy %q", name)
+	}
+	if err := lb.store.SetSetting(strategySettingKey, name); err != nil {
+		return err
+	}
+
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	lb.strategy = strategy
+	lb.strategySetting = name
+	lb.strategyCheckedAt = time.Now()
+	log.Printf("负载均衡策略切换为 %s", name)
+	return nil
+}
+
+// refreshStrategy 按 settings 表更新策略，未设置时使用 weighted_random。
+// 数据库在 lb.mu 外读取，调用方不能持有 lb.mu
+func (lb *LoadBalancer) refreshStrategy(now time.Time) {
+	lb.mu.RLock()
+	due := lb.strategy == nil || now.Sub(lb.strategyCheckedAt) >= strategyRefreshInterval
+	lb.mu.RUnlock()
+	if !due {
+		return
+	}
+
+	// 先占用本次检查，并发的请求不重复读取
+	lb.mu.Lock()
+	if lb.strategy != nil && now.Sub(lb.strategyCheckedAt) < strategyRefreshInterval {
+		lb.mu.Unlock()
+		return
+	}
+	lb.strategyCheckedAt = now
+	lb.mu.Unlock()
+
+	setting, err := lb.store.GetSetting(strategySettingKey)
+
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	if err != nil {
+		log.Printf("读取负载均衡策略失败: %v", err)
+		if lb.strategy == nil {
+			lb.strategy, _ = NewStrategy(StrategyWeightedRandom)
+		}
+		return
+	}
+	// 读取期间 SetStrategy 已切换策略时，以它为准
+	if lb.strategy != nil && (setting == lb.strategySetting || !lb.strategyCheckedAt.Equal(now)) {
+		return
+	}
+	lb.strategySetting = setting
+
+	name := setting
+	if name == "" {
+		name = StrategyWeightedRandom
+	}
+	strategy, ok := NewStrategy(name)
+	if !ok {
+		log.Printf("未知的负载均衡策略 %q，使用 %s", name, StrategyWeightedRandom)
+		strategy, _ = NewStrategy(StrategyWeightedRandom)
+	}
+	// 策略未变化时保留内部状态（如轮询进度）
+	if lb.strategy == nil || lb.strategy.Name() != strategy.Name() {
+		lb.strategy = strategy
+		log.Printf("负载均衡策略: %s", strategy.Name())
+	}
+}
+
+// weightOf 权重小于 1 的账号按 1 计算
+func weightOf(acc *store.Account) int {
+	if acc.Weight < 1 {
+		return 1
+	}
+	return acc.Weight
+}
+
+// weightedRandom 按权重随机
+type weightedRandom struct {
+	intn func(n int) int
+}
+
+func (s *weightedRandom) Name() string { return StrategyWeightedRandom }
+
+func (s *weightedRandom) Select(candidates []*store.Account, _ StatsFunc) *store.Account {
+	if len(candidates) == 1 {
+		return candidates[0]
+	}
+
+	var totalWeight int
+	for _, acc := range candidates {
+		totalWeight += weightOf(acc)
+	}
+
+	randomWeight := s.intn(totalWeight)
+	currentWeight := 0
+	for _, acc := range candidates {
+		currentWeight += weightOf(acc)
+		if currentWeight > randomWeight {
+			return acc
+		}
+	}
+	return candidates[0]
+}
+
+// weightedRoundRobin 平滑加权轮询（nginx 算法），同一周期内按权重交错分配
+type weightedRoundRobin struct {
+	current map[int64]int
+}
+
+func (s *weightedRoundRobin) Name() string { return StrategyWeightedRoundRobin }
+
+func (s *weightedRoundRobin) Select(candidates []*store.Account, _ StatsFunc) *store.Account {
+	// 不在候选中的账号（已删除、停用或本次被排除）清除累计值，
+	// 避免 map 随账号变更无限增长，重新出现时从 0 开始
+	inCandidates := make(map[int64]bool, len(candidates))
+	for _, acc := range candidates {
+		inCandidates[acc.ID] = true
+	}
+	for id := range s.current {
+		if !inCandidates[id] {
+			delete(s.current, id)
+		}
+	}
+
+	var best *store.Account
+	total := 0
+	for _, acc := range candidates {
+		w := weightOf(acc)
+		s.current[acc.ID] += w
+		total += w
+		if best == nil || s.current[acc.ID] > s.current[best.ID] {
+			best = acc
+		}
+	}
+	s.current[best.ID] -= total
+	return best
+}
+
+// leastRecentlyUsed 选择最久未使用的账号
+type leastRecentlyUsed struct{}
+
+func (leastRecentlyUsed) Name() string { return StrategyLeastRecentlyUsed }
+
+func (leastRecentlyUsed) Select(candidates []*store.Account, stats StatsFunc) *store.Account {
+	return minBy(candidates, stats, func(a, b AccountStats) bool { return false })
+}
+
+// leastInFlight 选择进行中请求数相对权重最少的账号，相同时选最久未使用的
+type leastInFlight struct{}
+
+func (leastInFlight) Name() string { return StrategyLeastInFlight }
+
+func (leastInFlight) Select(candidates []*store.Account, stats StatsFunc) *store.Account {
+	weights := make(map[int64]int, len(candidates))
+	for _, acc := range candidates {
+		weights[acc.ID] = weightOf(acc)
+	}
+	return minByAccount(candidates, stats, func(a, b *store.Account, sa, sb AccountStats) bool {
+		// sa.InFlight/wa < sb.InFlight/wb
+		return sa.InFlight*weights[b.ID] < sb.InFlight*weights[a.ID]
+	})
+}
+
+// latencyEWMA 选择延迟（指数加权平均）乘以负载最低的账号；尚无样本的账号优先，以便采样
+type latencyEWMA struct{}
+
+func (latencyEWMA) Name() string { return StrategyLatencyEWMA }
+
+func (latencyEWMA) Select(candidates []*store.Account, stats StatsFunc) *store.Account {
+	return minBy(candidates, stats, func(a, b AccountStats) bool {
+		if (a.Latency == 0) != (b.Latency == 0) {
+			return a.Latency == 0
+		}
+		return int64(a.Latency)*int64(a.InFlight+1) < int64(b.Latency)*int64(b.InFlight+1)
+	})
+}
+
+// minBy 按 less 选出最小的账号，相同时依次比较最近使用时间与 ID
+func minBy(candidates []*store.Account, stats StatsFunc, less func(a, b AccountStats) bool) *store.Account {
+	return minByAccount(candidates, stats, func(_, _ *store.Account, sa, sb AccountStats) bool {
+		return less(sa, sb)
+	})
+}
+
+func minByAccount(candidates []*store.Account, stats StatsFunc, less func(a, b *store.Account, sa, sb AccountStats) bool) *store.Account {
+	best := candidates[0]
+	bestStats := stats(best)
+	for _, acc := range candidates[1:] {
+		st := stats(acc)
+		switch {
+		case less(acc, best, st, bestStats):
+		case less(best, acc, bestStats, st):
+			continue
+		case !st.LastUsedAt.Equal(bestStats.LastUsedAt):
+			if !st.LastUsedAt.Before(bestStats.LastUsedAt) {
+				continue
+			}
+		case acc.ID > best.ID:
+			continue
+		}
+		best, bestStats = acc, st
+	}
+	return best
+}
@@ -0,0 +1,250 @@
+package loadbalancer
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"orchids-api/internal/store"
+)
+
+var baseTime = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func testAccounts(weights ...int) []*store.Account {
+	accounts := make([]*store.Account, len(weights))
+	for i, w := range weights {
+		accounts[i] = &store.Account{ID: int64(i + 1), Name: string(rune('a' + i)), Weight: w}
+	}
+	return accounts
+}
+
+func staticStats(m map[int64]AccountStats) StatsFunc {
+	return func(acc *store.Account) AccountStats { return m[acc.ID] }
+}
+
+func selectSequence(s Strategy, accounts []*store.Account, stats StatsFunc, n int) string {
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		b.WriteString(s.Select(accounts, stats).Name)
+	}
+	return b.String()
+}
+
+func TestWeightedRandom(t *testing.T) {
+	accounts := testAccounts(1, 3, 0)
+	// 总权重 1+3+1=5，区间 [0,1) -> a，[1,4) -> b，[4,5) -> c
+	tests := []struct {
+		roll int
+		want string
+	}{
+		{0, "a"}, {1, "b"}, {3, "b"}, {4, "c"},
+	}
+	for _, tt := range tests {
+		var gotTotal int
+		s := &weightedRandom{intn: func(n int) int { gotTotal = n; return tt.roll }}
+		got := s.Select(accounts, nil).Name
+		if got != tt.want {
+			t.Errorf("roll %d: got %s, want %s", tt.roll, got, tt.want)
+		}
+		if gotTotal != 5 {
+			t.Errorf("total weight = %d, want 5", gotTotal)
+		}
+	}
+}
+
+func TestWeightedRoundRobin(t *testing.T) {
+	s, _ := NewStrategy(StrategyWeightedRoundRobin)
+	accounts := testAccounts(5, 1, 1)
+	// nginx 平滑加权轮询的经典序列
+	got := selectSequence(s, accounts, nil, 14)
+	want := "aabacaaaabacaa"
+	if got != want {
+		t.Errorf("sequence = %s, want %s", got, want)
+	}
+}
+
+func TestWeightedRoundRobinPrune(t *testing.T) {
+	s := &weightedRoundRobin{current: make(map[int64]int)}
+	accounts := testAccounts(5, 1, 1)
+	selectSequence(s, accounts, nil, 3)
+
+	// 账号删除后其累计值不再保留
+	remaining := accounts[1:]
+	selectSequence(s, remaining, nil, 1)
+	if _, ok := s.current[accounts[0].ID]; ok || len(s.current) != len(remaining) {
+		t.Errorf("current = %v, want only ids of %d remaining accounts", s.current, len(remaining))
+	}
+
+	// 账号不断新建、删除时 map 大小不超过候选数
+	stable := &store.Account{ID: 1000, Name: "s", Weight: 1}
+	for id := int64(2000); id < 2100; id++ {
+		s.Select([]*store.Account{stable, {ID: id, Name: "t", Weight: 1}}, nil)
+	}
+	if len(s.current) != 2 {
+		t.Errorf("got %d tracked accounts, want 2", len(s.current))
+	}
+}
+
+func TestLeastRecentlyUsed(t *testing.T) {
+	s, _ := NewStrategy(StrategyLeastRecentlyUsed)
+	accounts := testAccounts(1, 1, 1)
+	used := map[int64]AccountStats{
+		1: {LastUsedAt: baseTime.Add(2 * time.Minute)},
+		2: {LastUsedAt: baseTime.Add(time.Minute)},
+		3: {LastUsedAt: baseTime.Add(3 * time.Minute)},
+	}
+	if got := s.Select(accounts, staticStats(used)).Name; got != "b" {
+		t.Errorf("got %s, want b", got)
+	}
+
+	// 从未使用的账号优先，相同时按 ID
+	used[3] = AccountStats{}
+	used[2] = AccountStats{}
+	if got := s.Select(accounts, staticStats(used)).Name; got != "b" {
+		t.Errorf("got %s, want b", got)
+	}
+}
+
+func TestLeastInFlight(t *testing.T) {
+	s, _ := NewStrategy(StrategyLeastInFlight)
+	accounts := testAccounts(1, 4, 1)
+	stats := map[int64]AccountStats{
+		1: {InFlight: 1},
+		2: {InFlight: 3}, // 3/4 < 1/1
+		3: {InFlight: 2},
+	}
+	if got := s.Select(accounts, staticStats(stats)).Name; got != "b" {
+		t.Errorf("got %s, want b", got)
+	}
+
+	// 负载相同时选择最久未使用的
+	stats = map[int64]AccountStats{
+		1: {InFlight: 1, LastUsedAt: baseTime.Add(time.Minute)},
+		2: {InFlight: 4, LastUsedAt: baseTime.Add(2 * time.Minute)},
+		3: {InFlight: 1, LastUsedAt: baseTime},
+	}
+	if got := s.Select(accounts, staticStats(stats)).Name; got != "c" {
+		t.Errorf("got %s, want c", got)
+	}
+}
+
+func TestLatencyEWMA(t *testing.T) {
+	s, _ := NewStrategy(StrategyLatencyEWMA)
+	accounts := testAccounts(1, 1, 1)
+	stats := map[int64]AccountStats{
+		1: {Latency: 300 * time.Millisecond},
+		2: {Latency: 100 * time.Millisecond, InFlight: 3}, // 100ms*4 > 300ms*1
+		3: {Latency: 200 * time.Millisecond},
+	}
+	if got := s.Select(accounts, staticStats(stats)).Name; got != "c" {
+		t.Errorf("got %s, want c", got)
+	}
+
+	// 尚无样本的账号优先
+	stats[1] = AccountStats{InFlight: 5}
+	if got := s.Select(accounts, staticStats(stats)).Name; got != "a" {
+		t.Errorf("got %s, want a", got)
+	}
+}
+
+func TestNewStrategy(t *testing.T) {
+	for _, name := range StrategyNames() {
+		s, ok := NewStrategy(name)
+		if !ok || s.Name() != name {
+			t.Errorf("NewStrategy(%q) = %v, %v", name, s, ok)
+		}
+	}
+	if _, ok := NewStrategy("random"); ok {
+		t.Error("NewStrategy(\"random\") should fail")
+	}
+}
+
+func TestLoadBalancerStrategySetting(t *testing.T) {
+	s := newTestStore(t, namedAccounts("a", "b")...)
+	lb := New(s)
+	if got := lb.StrategyName(); got != StrategyWeightedRandom {
+		t.Errorf("default strategy = %s, want %s", got, StrategyWeightedRandom)
+	}
+	if err := lb.SetStrategy("random"); err == nil {
+		t.Error("SetStrategy(\"random\") should fail")
+	}
+	if err := lb.SetStrategy(StrategyLeastInFlight); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := s.GetSetting(strategySettingKey); v != StrategyLeastInFlight {
+		t.Errorf("setting = %q, want %q", v, StrategyLeastInFlight)
+	}
+
+	// least_in_flight 下两个请求分到不同账号，释放后进行中请求归零
+	first, err := lb.GetNextAccount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := lb.GetNextAccount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.ID == second.ID {
+		t.Errorf("both requests went to account %d", first.ID)
+	}
+	if got := lb.Stats(first).InFlight; got != 1 {
+		t.Errorf("in flight = %d, want 1", got)
+	}
+	lb.Release(first)
+	lb.Release(first)
+	if got := lb.Stats(first).InFlight; got != 0 {
+		t.Errorf("in flight after release = %d, want 0", got)
+	}
+
+	// 直接修改 settings 表，刷新间隔过后生效
+	if err := s.SetSetting(strategySettingKey, StrategyLatencyEWMA); err != nil {
+		t.Fatal(err)
+	}
+	lb.refreshStrategy(time.Now().Add(strategyRefreshInterval))
+	lb.mu.RLock()
+	got := lb.strategy.Name()
+	lb.mu.RUnlock()
+	if got != StrategyLatencyEWMA {
+		t.Errorf("strategy = %s, want %s", got, StrategyLatencyEWMA)
+	}
+}
+
+func TestObserveLatency(t *testing.T) {
+	lb := &LoadBalancer{latency: make(map[int64]time.Duration), inFlight: make(map[int64]int), lastUsed: make(map[int64]time.Time)}
+	acc := &store.Account{ID: 1}
+	lb.ObserveLatency(acc, 100*time.Millisecond)
+	lb.ObserveLatency(acc, 200*time.Millisecond)
+	// 0.3*200 + 0.7*100 = 130
+	if got := lb.Stats(acc).Latency; got != 130*time.Millisecond {
+		t.Errorf("latency = %v, want 130ms", got)
+	}
+}
+
+func TestMaxConcurrency(t *testing.T) {
+	s := newTestStore(t, &store.Account{Name: "a", Weight: 1, MaxConcurrency: 1, Enabled: true})
+	lb := New(s)
+	first, err := lb.GetNextAccount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lb.GetNextAccount(); err != ErrAccountsBusy {
+		t.Errorf("err = %v, want %v", err, ErrAccountsBusy)
+	}
+
+	// 满载时使用其他账号
+	if err := s.CreateAccount(&store.Account{Name: "b", Weight: 1, Enabled: true}); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := lb.GetNextAccount(); err != nil || got.Name != "b" {
+		t.Errorf("got %v, %v, want account b", got, err)
+	}
+
+	lb.Release(first)
+	if got := lb.Stats(first).InFlight; got != 0 {
+		t.Errorf("in flight = %d, want 0", got)
+	}
+	lb.SetStrategy(StrategyLeastInFlight)
+	if got, err := lb.GetNextAccount(); err != nil || got.Name != "a" {
+		t.Errorf("after release got %v, %v, want account a", got, err)
+	}
+}
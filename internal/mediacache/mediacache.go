@@ -0,0 +1,234 @@
+package mediacache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// maxMediaSize 单个媒体文件的下载上限
+const maxMediaSize = 256 << 20
+
+// ErrNotFound 缓存中不存在或已过期
+var ErrNotFound = errors.New("media not found")
+
+var downloadClient = &http.Client{Timeout: 5 * time.Minute}
+
+// Cache 本地内容寻址媒体缓存，文件名为内容的 sha256，按修改时间过期
+type Cache struct {
+	dir string
+	ttl time.Duration
+}
+
+func New(dir string, ttl time.Duration) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Cache{dir: dir, ttl: ttl}, nil
+}
+
+// Fetch 下载远程媒体并写入缓存，返回 key 与内容
+func (c *Cache) Fetch(ctx context.Context, url string) (string, []byte, error) {
+	data, contentType, err := Download(ctx, url)
+	if err != nil {
+		return "", nil, err
+	}
+	key, err := c.Put(data, contentType)
+	if err != nil {
+		return "", nil, err
+	}
+	return key, data, nil
+}
+
+// Download 下载远程媒体，返回内容与 Content-Type
+func Download(ctx context.Context, url string) ([]byte, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	resp, err := downloadClient.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("media download failed with status %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxMediaSize+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if len(data) > maxMediaSize {
+		return nil, "", fmt.Errorf("media exceeds %d bytes", maxMediaSize)
+	}
+	return data, resp.Header.Get("Content-Type"), nil
+}
+
+// Put 写入内容，相同内容只保存一份并刷新过期时间
+func (c *Cache) Put(data []byte, contentType string) (string, error) {
+	sum := sha256.Sum256(data)
+	key := hex.EncodeToString(sum[:]) + extensionFor(data, contentType)
+	path := filepath.Join(c.dir, key)
+
+	if _, err := os.Stat(path); err == nil {
+		now := time.Now()
+		return key, os.Chtimes(path, now, now)
+	}
+
+	// 先写临时文件再改名，避免并发读取到不完整内容
+	tmp, err := os.CreateTemp(c.dir, ".tmp-*")
+	if err != nil {
+		return "", err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return key, nil
+}
+
+// Open 打开未过期的缓存文件
+func (c *Cache) Open(key string) (*os.File, os.FileInfo, error) {
+	if !validKey(key) {
+		return nil, nil, ErrNotFound
+	}
+	f, err := os.Open(filepath.Join(c.dir, key))
+	if err != nil {
+		return nil, nil, ErrNotFound
+	}
+	info, err := f.Stat()
+	if err != nil || c.expired(info) {
+		f.Close()
+		return nil, nil, ErrNotFound
+	}
+	return f, info, nil
+}
+
+// ServeHTTP 提供 {prefix}/{key} 形式的下载，prefix 由调用方 StripPrefix
+func (c *Cache) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	key := strings.TrimPrefix(r.URL.Path, "/")
+	f, info, err := c.Open(key)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+
+	if contentType := mime.TypeByExtension(filepath.Ext(key)); contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	expires := info.ModTime().Add(c.ttl)
+	w.Header().Set("Expires", expires.UTC().Format(http.TimeFormat))
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d, immutable", int(time.Until(expires).Seconds())))
+	http.ServeContent(w, r, key, info.ModTime(), f)
+}
+
+// Start 定期清理过期文件
+func (c *Cache) Start(ctx context.Context) {
+	interval := c.ttl / 4
+	if interval < time.Minute {
+		interval = time.Minute
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			c.cleanup()
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (c *Cache) cleanup() {
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		log.Printf("清理媒体缓存失败: %v", err)
+		return
+	}
+	removed := 0
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || entry.IsDir() {
+			continue
+		}
+		// 残留的临时文件同样按过期处理
+		if c.expired(info) {
+			if os.Remove(filepath.Join(c.dir, entry.Name())) == nil {
+				removed++
+			}
+		}
+	}
+	if removed > 0 {
+		log.Printf("已清理 %d 个过期媒体文件", removed)
+	}
+}
+
+func (c *Cache) expired(info os.FileInfo) bool {
+	return c.ttl > 0 && time.Since(info.ModTime()) > c.ttl
+}
+
+// extensionFor 根据 Content-Type 或文件内容推断扩展名
+func extensionFor(data []byte, contentType string) string {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if mediaType == "" || mediaType == "application/octet-stream" || mediaType == "binary/octet-stream" {
+		mediaType, _, _ = mime.ParseMediaType(http.DetectContentType(data))
+	}
+	switch mediaType {
+	case "image/png":
+		return ".png"
+	case "image/jpeg":
+		return ".jpg"
+	case "image/webp":
+		return ".webp"
+	case "image/gif":
+		return ".gif"
+	case "video/mp4":
+		return ".mp4"
+	case "video/webm":
+		return ".webm"
+	}
+	if exts, _ := mime.ExtensionsByType(mediaType); len(exts) > 0 {
+		return exts[0]
+	}
+	return ".bin"
+}
+
+// validKey 只允许 64 位十六进制摘要加扩展名，防止路径穿越
+func validKey(key string) bool {
+	hash, ext, ok := strings.Cut(key, ".")
+	if !ok || len(hash) != 64 || ext == "" || strings.ContainsAny(ext, "./\\") {
+		return false
+	}
+	_, err := hex.DecodeString(hash)
+	return err == nil
+}
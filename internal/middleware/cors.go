@@ -2,17 +2,30 @@ package middleware
 
 import "net/http"
 
+// allowHeaders 客户端可携带的请求头：各格式的认证头，以及会话粘滞与排队控制头
+const allowHeaders = "Content-Type, Authorization, x-api-key, x-goog-api-key, X-Session-ID, X-Request-Priority, X-Queue-Timeout"
+
+// exposeHeaders 浏览器客户端可读取的响应头：实际服务的模型与排队情况
+const exposeHeaders = "X-Served-Model, X-Queue-Depth, X-Queue-Wait-Ms"
+
 // CORS 中间件,允许跨域请求
 func CORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// 设置CORS头
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Expose-Headers", exposeHeaders)
 		w.Header().Set("Access-Control-Max-Age", "3600")
 
 		// 处理预检请求
 		if r.Method == "OPTIONS" {
+			// 账号分组规则的 header 名称可在运行时配置，预检请求的头一并放行
+			allowed := allowHeaders
+			if requested := r.Header.Get("Access-Control-Request-Headers"); requested != "" {
+				allowed += ", " + requested
+			}
+			w.Header().Set("Access-Control-Allow-Headers", allowed)
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
 			w.WriteHeader(http.StatusOK)
 			return
 		}
@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCORS(t *testing.T) {
+	called := false
+	h := CORS(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		w.Header().Set("X-Served-Model", "claude-opus-4-5")
+	}))
+
+	// 预检请求放行网关识别的请求头及分组规则配置的头
+	req := httptest.NewRequest(http.MethodOptions, "/v1/chat/completions", nil)
+	req.Header.Set("Access-Control-Request-Headers", "x-team")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || called {
+		t.Fatalf("preflight status = %d, handler called = %v", rec.Code, called)
+	}
+	allowed := rec.Header().Get("Access-Control-Allow-Headers")
+	for _, name := range []string{"Authorization", "X-Session-ID", "X-Request-Priority", "X-Queue-Timeout", "x-team"} {
+		if !strings.Contains(allowed, name) {
+			t.Errorf("Access-Control-Allow-Headers = %q, missing %s", allowed, name)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil))
+	if !called {
+		t.Fatal("handler not called")
+	}
+	exposed := rec.Header().Get("Access-Control-Expose-Headers")
+	for _, name := range []string{"X-Served-Model", "X-Queue-Depth", "X-Queue-Wait-Ms"} {
+		if !strings.Contains(exposed, name) {
+			t.Errorf("Access-Control-Expose-Headers = %q, missing %s", exposed, name)
+		}
+	}
+}
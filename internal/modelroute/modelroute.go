@@ -0,0 +1,195 @@
+package modelroute
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"orchids-api/internal/store"
+)
+
+// ErrNoRoute 没有路由规则匹配请求的模型
+var ErrNoRoute = errors.New("no model route matches the requested model")
+
+// Target 路由结果
+type Target struct {
+	RequestModel string
+	Model        string   // 上游 model
+	AgentMode    string   // 为空时使用账号配置
+	Fallbacks    []string // 降级模型名称，按顺序尝试
+	RouteID      int64
+}
+
+type compiledRoute struct {
+	route store.ModelRoute
+	names map[string]bool // 别名及 exact 规则的 pattern（小写）
+	re    *regexp.Regexp
+}
+
+// Router 缓存已编译的路由规则，规则变更后调用 Reload 立即生效
+type Router struct {
+	store  *store.Store
+	mu     sync.RWMutex
+	routes []compiledRoute
+}
+
+// New 从 store 加载路由；s 为 nil 时使用默认路由
+func New(s *store.Store) (*Router, error) {
+	r := &Router{store: s}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload 重新加载并编译全部启用的规则
+func (r *Router) Reload() error {
+	var routes []store.ModelRoute
+	if r.store == nil {
+		routes = store.DefaultModelRoutes()
+	} else {
+		list, err := r.store.ListModelRoutes()
+		if err != nil {
+			return err
+		}
+		for _, route := range list {
+			routes = append(routes, *route)
+		}
+	}
+
+	compiled := make([]compiledRoute, 0, len(routes))
+	for _, route := range routes {
+		if !route.Enabled {
+			continue
+		}
+		c, err := compile(route)
+		if err != nil {
+			// 单条坏规则不影响其他规则
+			log.Printf("跳过无效的模型路由 #%d: %v", route.ID, err)
+			continue
+		}
+		compiled = append(compiled, c)
+	}
+
+	// 优先级相同时 exact > prefix > regex
+	sort.SliceStable(compiled, func(i, j int) bool {
+		a, b := compiled[i].route, compiled[j].route
+		if a.Priority != b.Priority {
+			return a.Priority < b.Priority
+		}
+		return matchOrder(a.MatchType) < matchOrder(b.MatchType)
+	})
+
+	r.mu.Lock()
+	r.routes = compiled
+	r.mu.Unlock()
+	return nil
+}
+
+// Resolve 返回第一条命中的规则
+func (r *Router) Resolve(model string) (Target, error) {
+	route, ok := r.Match(model)
+	if !ok {
+		return Target{RequestModel: model}, ErrNoRoute
+	}
+	return Target{
+		RequestModel: model,
+		Model:        route.UpstreamModel,
+		AgentMode:    route.AgentMode,
+		Fallbacks:    route.Fallbacks,
+		RouteID:      route.ID,
+	}, nil
+}
+
+// Match 返回第一条命中 model 的规则
+func (r *Router) Match(model string) (store.ModelRoute, bool) {
+	lower := strings.ToLower(model)
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, c := range r.routes {
+		if c.matches(model, lower) {
+			return c.route, true
+		}
+	}
+	return store.ModelRoute{}, false
+}
+
+// Routes 返回当前生效的规则（按匹配顺序）
+func (r *Router) Routes() []store.ModelRoute {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	routes := make([]store.ModelRoute, len(r.routes))
+	for i, c := range r.routes {
+		routes[i] = c.route
+	}
+	return routes
+}
+
+// Validate 检查规则字段，供管理接口在写入前调用
+func Validate(route *store.ModelRoute) error {
+	if route.Pattern == "" {
+		return errors.New("pattern is required")
+	}
+	if route.UpstreamModel == "" {
+		return errors.New("upstream_model is required")
+	}
+	for _, name := range route.Fallbacks {
+		if strings.TrimSpace(name) == "" {
+			return errors.New("fallbacks must not contain empty model names")
+		}
+	}
+	_, err := compile(*route)
+	return err
+}
+
+func compile(route store.ModelRoute) (compiledRoute, error) {
+	c := compiledRoute{route: route, names: make(map[string]bool)}
+	for _, alias := range route.Aliases {
+		c.names[strings.ToLower(alias)] = true
+	}
+	switch route.MatchType {
+	case store.MatchExact:
+		c.names[strings.ToLower(route.Pattern)] = true
+	case store.MatchPrefix:
+	case store.MatchRegex:
+		re, err := regexp.Compile(route.Pattern)
+		if err != nil {
+			return c, fmt.Errorf("invalid regex: %w", err)
+		}
+		c.re = re
+	default:
+		return c, fmt.Errorf("match_type must be %s, %s or %s", store.MatchExact, store.MatchPrefix, store.MatchRegex)
+	}
+	return c, nil
+}
+
+func (c *compiledRoute) matches(model, lower string) bool {
+	if c.names[lower] {
+		return true
+	}
+	switch c.route.MatchType {
+	case store.MatchPrefix:
+		return strings.HasPrefix(lower, strings.ToLower(c.route.Pattern))
+	case store.MatchRegex:
+		return c.re.MatchString(model)
+	}
+	return false
+}
+
+func matchOrder(matchType string) int {
+	switch matchType {
+	case store.MatchExact:
+		return 0
+	case store.MatchPrefix:
+		return 1
+	default:
+		return 2
+	}
+}
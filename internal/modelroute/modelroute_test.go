@@ -0,0 +1,159 @@
+package modelroute
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"orchids-api/internal/store"
+)
+
+// newTestRouter 创建只包含 routes 的路由，清除建库时写入的默认路由
+func newTestRouter(t *testing.T, routes ...store.ModelRoute) (*Router, *store.Store) {
+	t.Helper()
+	s, err := store.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+
+	seeded, err := s.ListModelRoutes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, route := range seeded {
+		if err := s.DeleteModelRoute(route.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := range routes {
+		if err := s.CreateModelRoute(&routes[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r, err := New(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r, s
+}
+
+func TestDefaultRoutes(t *testing.T) {
+	r, err := New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		model string
+		want  string
+	}{
+		{"claude-opus-4-5", "claude-opus-4.5"},
+		{"Claude-Opus-4.5", "claude-opus-4.5"},
+		{"claude-sonnet-4-20250514", "claude-sonnet-4-5"},
+		{"claude-3-opus-20240229", "claude-opus-4.5"},
+		{"claude-3-5-haiku-latest", "gemini-3-flash"},
+		{"gpt-4o", "claude-sonnet-4-5"},
+	}
+	for _, tt := range tests {
+		target, err := r.Resolve(tt.model)
+		if err != nil || target.Model != tt.want || target.RequestModel != tt.model {
+			t.Errorf("Resolve(%q) = %+v (%v), want model %q", tt.model, target, err, tt.want)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	r, _ := newTestRouter(t,
+		store.ModelRoute{MatchType: store.MatchRegex, Pattern: "^claude", UpstreamModel: "regex", Priority: 10, Enabled: true},
+		store.ModelRoute{MatchType: store.MatchPrefix, Pattern: "claude-", UpstreamModel: "prefix", Priority: 10, Enabled: true},
+		store.ModelRoute{MatchType: store.MatchExact, Pattern: "claude-x", Aliases: []string{"x"}, UpstreamModel: "exact", AgentMode: "agent", Priority: 10, Enabled: true},
+		store.ModelRoute{MatchType: store.MatchExact, Pattern: "claude-y", UpstreamModel: "urgent", Priority: 1, Enabled: true},
+		store.ModelRoute{MatchType: store.MatchExact, Pattern: "claude-z", UpstreamModel: "disabled", Priority: 1, Enabled: false},
+		store.ModelRoute{MatchType: store.MatchRegex, Pattern: "(", UpstreamModel: "broken", Priority: 1, Enabled: true},
+	)
+
+	tests := []struct {
+		model string
+		want  string
+	}{
+		// 优先级相同时 exact > prefix > regex
+		{"claude-x", "exact"},
+		{"X", "exact"},
+		{"claude-other", "prefix"},
+		{"claudeish", "regex"},
+		// 优先级数值小的先匹配
+		{"claude-y", "urgent"},
+		// 停用的规则不参与匹配
+		{"claude-z", "prefix"},
+	}
+	for _, tt := range tests {
+		target, err := r.Resolve(tt.model)
+		if err != nil || target.Model != tt.want {
+			t.Errorf("Resolve(%q) = %+v (%v), want model %q", tt.model, target, err, tt.want)
+		}
+	}
+
+	if target, _ := r.Resolve("claude-x"); target.AgentMode != "agent" || target.RouteID == 0 {
+		t.Errorf("got %+v, want agent mode and route id", target)
+	}
+	if _, err := r.Resolve("gpt-4o"); err != ErrNoRoute {
+		t.Errorf("err = %v, want %v", err, ErrNoRoute)
+	}
+	// 无效规则被跳过，不影响其他规则
+	if got := len(r.Routes()); got != 4 {
+		t.Errorf("got %d routes, want 4", got)
+	}
+}
+
+func TestFallbackOrder(t *testing.T) {
+	r, s := newTestRouter(t,
+		store.ModelRoute{MatchType: store.MatchExact, Pattern: "primary", UpstreamModel: "p", Fallbacks: []string{"second", "first", "third"}, Priority: 10, Enabled: true},
+	)
+
+	// 降级模型按配置顺序返回，不做排序
+	target, err := r.Resolve("primary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"second", "first", "third"}; !reflect.DeepEqual(target.Fallbacks, want) {
+		t.Errorf("fallbacks = %v, want %v", target.Fallbacks, want)
+	}
+
+	// 修改后 Reload 立即生效
+	route, err := s.GetModelRoute(target.RouteID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	route.Fallbacks = []string{"third", "second"}
+	if err := s.UpdateModelRoute(route); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	target, _ = r.Resolve("primary")
+	if want := []string{"third", "second"}; !reflect.DeepEqual(target.Fallbacks, want) {
+		t.Errorf("after reload fallbacks = %v, want %v", target.Fallbacks, want)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		route   store.ModelRoute
+		wantErr bool
+	}{
+		{"有效", store.ModelRoute{MatchType: store.MatchPrefix, Pattern: "gpt-", UpstreamModel: "m"}, false},
+		{"缺少 pattern", store.ModelRoute{MatchType: store.MatchExact, UpstreamModel: "m"}, true},
+		{"缺少上游模型", store.ModelRoute{MatchType: store.MatchExact, Pattern: "a"}, true},
+		{"空的降级模型", store.ModelRoute{MatchType: store.MatchExact, Pattern: "a", UpstreamModel: "m", Fallbacks: []string{" "}}, true},
+		{"无效正则", store.ModelRoute{MatchType: store.MatchRegex, Pattern: "(", UpstreamModel: "m"}, true},
+		{"未知匹配方式", store.ModelRoute{MatchType: "glob", Pattern: "a", UpstreamModel: "m"}, true},
+	}
+	for _, tt := range tests {
+		if err := Validate(&tt.route); (err != nil) != tt.wantErr {
+			t.Errorf("%s: Validate() = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+)
+
+// 账号熔断状态
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half_open"
+)
+
+// AccountHealth 账号健康状态，由负载均衡维护
+type AccountHealth struct {
+	AccountID           int64     `json:"-"`
+	State               string    `json:"state"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	Trips               int       `json:"trips"` // 连续熔断次数，决定冷却时间的退避
+	OpenUntil           time.Time `json:"open_until"`
+	LastError           string    `json:"last_error"`
+	LastFailureAt       time.Time `json:"last_failure_at"`
+	LastSuccessAt       time.Time `json:"last_success_at"`
+	UpdatedAt           time.Time `json:"updated_at"`
+}
+
+// SaveAccountHealth 在一个事务中写入或覆盖账号健康状态
+func (s *Store) SaveAccountHealth(list ...*AccountHealth) error {
+	if len(list) == 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO account_health (account_id, state, consecutive_failures, trips, open_until, last_error, last_failure_at, last_success_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(account_id) DO UPDATE SET
+			state = excluded.state, consecutive_failures = excluded.consecutive_failures, trips = excluded.trips,
+			open_until = excluded.open_until, last_error = excluded.last_error, last_failure_at = excluded.last_failure_at,
+			last_success_at = excluded.last_success_at, updated_at = excluded.updated_at
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	now := time.Now()
+	for _, h := range list {
+		h.UpdatedAt = now
+		if _, err := stmt.Exec(h.AccountID, h.State, h.ConsecutiveFailures, h.Trips, nullTime(h.OpenUntil), h.LastError,
+			nullTime(h.LastFailureAt), nullTime(h.LastSuccessAt), h.UpdatedAt); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ListAccountHealth 返回全部账号的健康状态，按账号 ID 索引
+func (s *Store) ListAccountHealth() (map[int64]*AccountHealth, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rows, err := s.db.Query(`
+		SELECT account_id, state, consecutive_failures, trips, open_until, last_error, last_failure_at, last_success_at, updated_at
+		FROM account_health
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make(map[int64]*AccountHealth)
+	for rows.Next() {
+		h := &AccountHealth{}
+		var openUntil, lastFailureAt, lastSuccessAt sql.NullTime
+		err := rows.Scan(&h.AccountID, &h.State, &h.ConsecutiveFailures, &h.Trips, &openUntil, &h.LastError,
+			&lastFailureAt, &lastSuccessAt, &h.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		h.OpenUntil = openUntil.Time
+		h.LastFailureAt = lastFailureAt.Time
+		h.LastSuccessAt = lastSuccessAt.Time
+		result[h.AccountID] = h
+	}
+	return result, rows.Err()
+}
+
+func nullTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t
+}
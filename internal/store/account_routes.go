@@ -0,0 +1,118 @@
+package store
+
+import (
+	"time"
+)
+
+// 账号分组规则的匹配依据
+const (
+	RouteByAPIKey = "api_key"
+	RouteByModel  = "model"
+	RouteByHeader = "header"
+)
+
+// AccountRoute 账号分组规则：请求命中后只在 Group 分组的账号中选择。
+// Header 为 header 规则匹配的请求头名称；Spillover 为 true 时分组内没有可用账号可以使用其他账号
+type AccountRoute struct {
+	ID        int64     `json:"id"`
+	MatchType string    `json:"match_type"`
+	Header    string    `json:"header"`
+	Pattern   string    `json:"pattern"`
+	Group     string    `json:"group"`
+	Spillover bool      `json:"spillover"`
+	Priority  int       `json:"priority"`
+	Enabled   bool      `json:"enabled"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (s *Store) CreateAccountRoute(route *AccountRoute) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	result, err := s.db.Exec(`
+		INSERT INTO account_routes (match_type, header, pattern, group_name, spillover, priority, enabled, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, route.MatchType, route.Header, route.Pattern, route.Group, route.Spillover, route.Priority, route.Enabled, now, now)
+	if err != nil {
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	route.ID = id
+	route.CreatedAt = now
+	route.UpdatedAt = now
+	return nil
+}
+
+func (s *Store) UpdateAccountRoute(route *AccountRoute) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	route.UpdatedAt = time.Now()
+	_, err := s.db.Exec(`
+		UPDATE account_routes SET
+			match_type = ?, header = ?, pattern = ?, group_name = ?, spillover = ?,
+			priority = ?, enabled = ?, updated_at = ?
+		WHERE id = ?
+	`, route.MatchType, route.Header, route.Pattern, route.Group, route.Spillover,
+		route.Priority, route.Enabled, route.UpdatedAt, route.ID)
+	return err
+}
+
+func (s *Store) DeleteAccountRoute(id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err := s.db.Exec("DELETE FROM account_routes WHERE id = ?", id)
+	return err
+}
+
+func (s *Store) GetAccountRoute(id int64) (*AccountRoute, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return scanAccountRoute(s.db.QueryRow(`
+		SELECT id, match_type, header, pattern, group_name, spillover, priority, enabled, created_at, updated_at
+		FROM account_routes WHERE id = ?
+	`, id))
+}
+
+// ListAccountRoutes 按优先级列出全部分组规则
+func (s *Store) ListAccountRoutes() ([]*AccountRoute, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rows, err := s.db.Query(`
+		SELECT id, match_type, header, pattern, group_name, spillover, priority, enabled, created_at, updated_at
+		FROM account_routes ORDER BY priority, id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var routes []*AccountRoute
+	for rows.Next() {
+		route, err := scanAccountRoute(rows)
+		if err != nil {
+			return nil, err
+		}
+		routes = append(routes, route)
+	}
+	return routes, rows.Err()
+}
+
+func scanAccountRoute(row rowScanner) (*AccountRoute, error) {
+	route := &AccountRoute{}
+	err := row.Scan(&route.ID, &route.MatchType, &route.Header, &route.Pattern, &route.Group,
+		&route.Spillover, &route.Priority, &route.Enabled, &route.CreatedAt, &route.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return route, nil
+}
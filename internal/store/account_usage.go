@@ -0,0 +1,88 @@
+package store
+
+import (
+	"time"
+)
+
+// 配额窗口
+const (
+	QuotaDaily  = "daily"
+	QuotaHourly = "hourly"
+)
+
+// AccountUsage 账号在当前配额窗口内的用量，由负载均衡维护
+type AccountUsage struct {
+	AccountID   int64
+	WindowStart time.Time
+	Requests    int64
+	Tokens      int64
+}
+
+// AccountQuota 账号配额的使用情况，remaining 为 nil 表示不限制
+type AccountQuota struct {
+	Window            string    `json:"window"`
+	WindowStart       time.Time `json:"window_start"`
+	ResetsAt          time.Time `json:"resets_at"`
+	RequestsUsed      int64     `json:"requests_used"`
+	TokensUsed        int64     `json:"tokens_used"`
+	RequestsRemaining *int64    `json:"requests_remaining"`
+	TokensRemaining   *int64    `json:"tokens_remaining"`
+	Exhausted         bool      `json:"exhausted"`
+}
+
+// SaveAccountUsage 在一个事务中写入或覆盖账号用量
+func (s *Store) SaveAccountUsage(list ...*AccountUsage) error {
+	if len(list) == 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO account_usage (account_id, window_start, requests, tokens, updated_at)
+		VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(account_id) DO UPDATE SET
+			window_start = excluded.window_start, requests = excluded.requests,
+			tokens = excluded.tokens, updated_at = excluded.updated_at
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, u := range list {
+		if _, err := stmt.Exec(u.AccountID, u.WindowStart, u.Requests, u.Tokens); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ListAccountUsage 返回全部账号的用量，按账号 ID 索引
+func (s *Store) ListAccountUsage() (map[int64]*AccountUsage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rows, err := s.db.Query(`SELECT account_id, window_start, requests, tokens FROM account_usage`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make(map[int64]*AccountUsage)
+	for rows.Next() {
+		u := &AccountUsage{}
+		if err := rows.Scan(&u.AccountID, &u.WindowStart, &u.Requests, &u.Tokens); err != nil {
+			return nil, err
+		}
+		result[u.AccountID] = u
+	}
+	return result, rows.Err()
+}
@@ -0,0 +1,194 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// 模型路由匹配方式
+const (
+	MatchExact  = "exact"
+	MatchPrefix = "prefix"
+	MatchRegex  = "regex"
+)
+
+// ModelRoute 模型路由规则：请求的 model 命中后转发到 UpstreamModel。
+// AgentMode 为空时使用账号自身的 agent_mode。
+// Fallbacks 为降级模型名称，当前模型的账号全部失败后按顺序尝试。
+type ModelRoute struct {
+	ID            int64     `json:"id"`
+	MatchType     string    `json:"match_type"`
+	Pattern       string    `json:"pattern"`
+	Aliases       []string  `json:"aliases"`
+	UpstreamModel string    `json:"upstream_model"`
+	AgentMode     string    `json:"agent_mode"`
+	Fallbacks     []string  `json:"fallbacks"`
+	Priority      int       `json:"priority"`
+	Enabled       bool      `json:"enabled"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// DefaultModelRoutes 初始路由，与原先硬编码的映射行为一致
+func DefaultModelRoutes() []ModelRoute {
+	return []ModelRoute{
+		{MatchType: MatchExact, Pattern: "claude-opus-4-5", Aliases: []string{"claude-opus-4-5-thinking", "claude-opus-4.5"}, UpstreamModel: "claude-opus-4.5", Priority: 10, Enabled: true},
+		{MatchType: MatchExact, Pattern: "claude-sonnet-4-5", Aliases: []string{"claude-sonnet-4-20250514", "claude-sonnet-4.5"}, UpstreamModel: "claude-sonnet-4-5", Priority: 10, Enabled: true},
+		{MatchType: MatchExact, Pattern: "gemini-3-flash", UpstreamModel: "gemini-3-flash", Priority: 10, Enabled: true},
+		{MatchType: MatchRegex, Pattern: "(?i)opus", UpstreamModel: "claude-opus-4.5", Priority: 100, Enabled: true},
+		{MatchType: MatchRegex, Pattern: "(?i)haiku", UpstreamModel: "gemini-3-flash", Priority: 100, Enabled: true},
+		{MatchType: MatchRegex, Pattern: ".*", UpstreamModel: "claude-sonnet-4-5", Priority: 1000, Enabled: true},
+	}
+}
+
+// seedModelRoutes 首次建表时写入默认路由，之后由管理员维护
+func (s *Store) seedModelRoutes() error {
+	var seeded string
+	err := s.db.QueryRow("SELECT value FROM settings WHERE key = 'model_routes_seeded'").Scan(&seeded)
+	if err == nil {
+		return nil
+	}
+	if err != sql.ErrNoRows {
+		return err
+	}
+
+	for _, route := range DefaultModelRoutes() {
+		route := route
+		if err := s.insertModelRoute(&route); err != nil {
+			return err
+		}
+	}
+	_, err = s.db.Exec("INSERT INTO settings (key, value) VALUES ('model_routes_seeded', '1')")
+	return err
+}
+
+func (s *Store) CreateModelRoute(route *ModelRoute) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.insertModelRoute(route)
+}
+
+func (s *Store) insertModelRoute(route *ModelRoute) error {
+	aliases, fallbacks, err := marshalRouteLists(route)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	result, err := s.db.Exec(`
+		INSERT INTO model_routes (match_type, pattern, aliases, upstream_model, agent_mode, fallbacks, priority, enabled, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, route.MatchType, route.Pattern, aliases, route.UpstreamModel, route.AgentMode, fallbacks, route.Priority, route.Enabled, now, now)
+	if err != nil {
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	route.ID = id
+	route.CreatedAt = now
+	route.UpdatedAt = now
+	return nil
+}
+
+func (s *Store) UpdateModelRoute(route *ModelRoute) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	aliases, fallbacks, err := marshalRouteLists(route)
+	if err != nil {
+		return err
+	}
+
+	route.UpdatedAt = time.Now()
+	_, err = s.db.Exec(`
+		UPDATE model_routes SET
+			match_type = ?, pattern = ?, aliases = ?, upstream_model = ?, agent_mode = ?,
+			fallbacks = ?, priority = ?, enabled = ?, updated_at = ?
+		WHERE id = ?
+	`, route.MatchType, route.Pattern, aliases, route.UpstreamModel, route.AgentMode,
+		fallbacks, route.Priority, route.Enabled, route.UpdatedAt, route.ID)
+	return err
+}
+
+func (s *Store) DeleteModelRoute(id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err := s.db.Exec("DELETE FROM model_routes WHERE id = ?", id)
+	return err
+}
+
+func (s *Store) GetModelRoute(id int64) (*ModelRoute, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return scanModelRoute(s.db.QueryRow(`
+		SELECT id, match_type, pattern, aliases, upstream_model, agent_mode, fallbacks, priority, enabled, created_at, updated_at
+		FROM model_routes WHERE id = ?
+	`, id))
+}
+
+// ListModelRoutes 按优先级列出全部路由
+func (s *Store) ListModelRoutes() ([]*ModelRoute, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rows, err := s.db.Query(`
+		SELECT id, match_type, pattern, aliases, upstream_model, agent_mode, fallbacks, priority, enabled, created_at, updated_at
+		FROM model_routes ORDER BY priority, id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var routes []*ModelRoute
+	for rows.Next() {
+		route, err := scanModelRoute(rows)
+		if err != nil {
+			return nil, err
+		}
+		routes = append(routes, route)
+	}
+	return routes, rows.Err()
+}
+
+func scanModelRoute(row rowScanner) (*ModelRoute, error) {
+	route := &ModelRoute{}
+	var aliases, fallbacks string
+	err := row.Scan(&route.ID, &route.MatchType, &route.Pattern, &aliases, &route.UpstreamModel,
+		&route.AgentMode, &fallbacks, &route.Priority, &route.Enabled, &route.CreatedAt, &route.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	json.Unmarshal([]byte(aliases), &route.Aliases)
+	json.Unmarshal([]byte(fallbacks), &route.Fallbacks)
+	route.Aliases = nonNilStrings(route.Aliases)
+	route.Fallbacks = nonNilStrings(route.Fallbacks)
+	return route, nil
+}
+
+func marshalRouteLists(route *ModelRoute) (string, string, error) {
+	route.Aliases = nonNilStrings(route.Aliases)
+	route.Fallbacks = nonNilStrings(route.Fallbacks)
+	aliases, err := json.Marshal(route.Aliases)
+	if err != nil {
+		return "", "", err
+	}
+	fallbacks, err := json.Marshal(route.Fallbacks)
+	if err != nil {
+		return "", "", err
+	}
+	return string(aliases), string(fallbacks), nil
+}
+
+func nonNilStrings(list []string) []string {
+	if list == nil {
+		return []string{}
+	}
+	return list
+}
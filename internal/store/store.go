@@ -2,29 +2,70 @@ package store
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	_ "modernc.org/sqlite"
 )
 
 type Account struct {
-	ID           int64     `json:"id"`
-	Name         string    `json:"name"`
-	SessionID    string    `json:"session_id"`
-	ClientCookie string    `json:"client_cookie"`
-	ClientUat    string    `json:"client_uat"`
-	ProjectID    string    `json:"project_id"`
-	UserID       string    `json:"user_id"`
-	AgentMode    string    `json:"agent_mode"`
-	Email        string    `json:"email"`
-	Weight       int       `json:"weight"`
-	Enabled      bool      `json:"enabled"`
-	RequestCount int64     `json:"request_count"`
-	LastUsedAt   time.Time `json:"last_used_at"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
+	ID             int64     `json:"id"`
+	Name           string    `json:"name"`
+	SessionID      string    `json:"session_id"`
+	ClientCookie   string    `json:"client_cookie"`
+	ClientUat      string    `json:"client_uat"`
+	ProjectID      string    `json:"project_id"`
+	UserID         string    `json:"user_id"`
+	AgentMode      string    `json:"agent_mode"`
+	Email          string    `json:"email"`
+	Models         []string  `json:"models"` // 可服务的上游模型，为空表示全部
+	Groups         []string  `json:"groups"` // 所属分组，供账号分组规则选择
+	Weight         int       `json:"weight"`
+	Priority       int       `json:"priority"`        // 优先级，数值小的先用，较低优先级只在更高优先级的账号都不可用时使用
+	MaxConcurrency int       `json:"max_concurrency"` // 同时进行的请求上限，0 表示不限制
+	RequestQuota   int64     `json:"request_quota"`   // 每个配额窗口的请求数上限，0 表示不限制
+	TokenQuota     int64     `json:"token_quota"`     // 每个配额窗口的 token 上限，0 表示不限制
+	QuotaWindow    string    `json:"quota_window"`    // daily 或 hourly，为空按 daily
+	QuotaTimezone  string    `json:"quota_timezone"`  // 配额窗口重置所在的时区（IANA 名称），为空按 UTC
+	Enabled        bool      `json:"enabled"`
+	RequestCount   int64     `json:"request_count"`
+	LastUsedAt     time.Time `json:"last_used_at"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+
+	Health   *AccountHealth `json:"health,omitempty"` // 仅管理接口返回
+	InFlight int            `json:"in_flight"`        // 仅管理接口返回
+	Quota    *AccountQuota  `json:"quota,omitempty"`  // 仅管理接口返回
+}
+
+// Supports 账号是否可以服务指定的上游模型
+func (a *Account) Supports(model string) bool {
+	if len(a.Models) == 0 || model == "" {
+		return true
+	}
+	for _, m := range a.Models {
+		if strings.EqualFold(m, model) {
+			return true
+		}
+	}
+	return false
+}
+
+// InGroup 账号是否属于 group，group 为空时不限制
+func (a *Account) InGroup(group string) bool {
+	if group == "" {
+		return true
+	}
+	for _, g := range a.Groups {
+		if strings.EqualFold(g, group) {
+			return true
+		}
+	}
+	return false
 }
 
 type Settings struct {
@@ -36,6 +77,8 @@ type Settings struct {
 type Store struct {
 	db *sql.DB
 	mu sync.RWMutex
+
+	accountsVersion atomic.Uint64
 }
 
 func New(dbPath string) (*Store, error) {
@@ -77,6 +120,65 @@ func (s *Store) migrate() error {
 			value TEXT NOT NULL
 		)`,
 		`CREATE INDEX IF NOT EXISTS idx_accounts_enabled ON accounts(enabled)`,
+		`CREATE TABLE IF NOT EXISTS video_jobs (
+			id TEXT PRIMARY KEY,
+			status TEXT NOT NULL,
+			progress INTEGER DEFAULT 0,
+			model TEXT NOT NULL DEFAULT '',
+			prompt TEXT NOT NULL,
+			params TEXT NOT NULL DEFAULT '{}',
+			result_url TEXT NOT NULL DEFAULT '',
+			error TEXT NOT NULL DEFAULT '',
+			account_id INTEGER NOT NULL DEFAULT 0,
+			attempts INTEGER DEFAULT 0,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			completed_at DATETIME
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_video_jobs_status ON video_jobs(status)`,
+		`CREATE TABLE IF NOT EXISTS model_routes (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			match_type TEXT NOT NULL,
+			pattern TEXT NOT NULL,
+			aliases TEXT NOT NULL DEFAULT '[]',
+			upstream_model TEXT NOT NULL,
+			agent_mode TEXT NOT NULL DEFAULT '',
+			fallbacks TEXT NOT NULL DEFAULT '[]',
+			priority INTEGER DEFAULT 100,
+			enabled INTEGER DEFAULT 1,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS account_health (
+			account_id INTEGER PRIMARY KEY,
+			state TEXT NOT NULL DEFAULT 'closed',
+			consecutive_failures INTEGER DEFAULT 0,
+			trips INTEGER DEFAULT 0,
+			open_until DATETIME,
+			last_error TEXT NOT NULL DEFAULT '',
+			last_failure_at DATETIME,
+			last_success_at DATETIME,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS account_routes (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			match_type TEXT NOT NULL,
+			header TEXT NOT NULL DEFAULT '',
+			pattern TEXT NOT NULL,
+			group_name TEXT NOT NULL,
+			spillover INTEGER DEFAULT 0,
+			priority INTEGER DEFAULT 100,
+			enabled INTEGER DEFAULT 1,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS account_usage (
+			account_id INTEGER PRIMARY KEY,
+			window_start DATETIME NOT NULL,
+			requests INTEGER DEFAULT 0,
+			tokens INTEGER DEFAULT 0,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
 	}
 
 	for _, q := range queries {
@@ -85,7 +187,56 @@ func (s *Store) migrate() error {
 		}
 	}
 
-	return nil
+	columns := []struct{ table, name, def string }{
+		{"accounts", "models", "TEXT NOT NULL DEFAULT '[]'"},
+		{"accounts", "max_concurrency", "INTEGER NOT NULL DEFAULT 0"},
+		{"accounts", "request_quota", "INTEGER NOT NULL DEFAULT 0"},
+		{"accounts", "token_quota", "INTEGER NOT NULL DEFAULT 0"},
+		{"accounts", "quota_window", "TEXT NOT NULL DEFAULT 'daily'"},
+		{"accounts", "quota_timezone", "TEXT NOT NULL DEFAULT ''"},
+		{"accounts", "account_groups", "TEXT NOT NULL DEFAULT '[]'"},
+		{"accounts", "priority", "INTEGER NOT NULL DEFAULT 0"},
+		{"model_routes", "fallbacks", "TEXT NOT NULL DEFAULT '[]'"},
+	}
+	for _, c := range columns {
+		if err := s.addColumn(c.table, c.name, c.def); err != nil {
+			return err
+		}
+	}
+
+	return s.seedModelRoutes()
+}
+
+// addColumn 为已存在的表补充新列，列已存在时跳过
+func (s *Store) addColumn(table, column, definition string) error {
+	rows, err := s.db.Query("PRAGMA table_info(" + table + ")")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid        int
+			name, kind string
+			notNull    int
+			dflt       sql.NullString
+			pk         int
+		)
+		if err := rows.Scan(&cid, &name, &kind, &notNull, &dflt, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	_, err = s.db.Exec("ALTER TABLE " + table + " ADD COLUMN " + column + " " + definition)
+	return err
 }
 
 func (s *Store) Close() error {
@@ -96,10 +247,17 @@ func (s *Store) CreateAccount(acc *Account) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	models, groups, err := marshalAccountLists(acc)
+	if err != nil {
+		return err
+	}
+
 	result, err := s.db.Exec(`
-		INSERT INTO accounts (name, session_id, client_cookie, client_uat, project_id, user_id, agent_mode, email, weight, enabled)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-	`, acc.Name, acc.SessionID, acc.ClientCookie, acc.ClientUat, acc.ProjectID, acc.UserID, acc.AgentMode, acc.Email, acc.Weight, acc.Enabled)
+		INSERT INTO accounts (name, session_id, client_cookie, client_uat, project_id, user_id, agent_mode, email, models, account_groups, weight, priority, max_concurrency,
+			request_quota, token_quota, quota_window, quota_timezone, enabled)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, acc.Name, acc.SessionID, acc.ClientCookie, acc.ClientUat, acc.ProjectID, acc.UserID, acc.AgentMode, acc.Email, models, groups, acc.Weight, acc.Priority, acc.MaxConcurrency,
+		acc.RequestQuota, acc.TokenQuota, acc.QuotaWindow, acc.QuotaTimezone, acc.Enabled)
 	if err != nil {
 		return err
 	}
@@ -109,6 +267,7 @@ func (s *Store) CreateAccount(acc *Account) error {
 		return err
 	}
 	acc.ID = id
+	s.accountsVersion.Add(1)
 	return nil
 }
 
@@ -116,21 +275,40 @@ func (s *Store) UpdateAccount(acc *Account) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	_, err := s.db.Exec(`
+	models, groups, err := marshalAccountLists(acc)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec(`
 		UPDATE accounts SET
 			name = ?, session_id = ?, client_cookie = ?, client_uat = ?,
-			project_id = ?, user_id = ?, agent_mode = ?, email = ?,
-			weight = ?, enabled = ?, updated_at = CURRENT_TIMESTAMP
+			project_id = ?, user_id = ?, agent_mode = ?, email = ?, models = ?,
+			account_groups = ?, weight = ?, priority = ?, max_concurrency = ?,
+			request_quota = ?, token_quota = ?, quota_window = ?, quota_timezone = ?,
+			enabled = ?, updated_at = CURRENT_TIMESTAMP
 		WHERE id = ?
-	`, acc.Name, acc.SessionID, acc.ClientCookie, acc.ClientUat, acc.ProjectID, acc.UserID, acc.AgentMode, acc.Email, acc.Weight, acc.Enabled, acc.ID)
-	return err
+	`, acc.Name, acc.SessionID, acc.ClientCookie, acc.ClientUat, acc.ProjectID, acc.UserID, acc.AgentMode, acc.Email, models, groups, acc.Weight, acc.Priority, acc.MaxConcurrency,
+		acc.RequestQuota, acc.TokenQuota, acc.QuotaWindow, acc.QuotaTimezone, acc.Enabled, acc.ID)
+	if err != nil {
+		return err
+	}
+	s.accountsVersion.Add(1)
+	return nil
 }
 
 func (s *Store) DeleteAccount(id int64) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	_, err := s.db.Exec("DELETE FROM accounts WHERE id = ?", id)
+	if _, err := s.db.Exec("DELETE FROM accounts WHERE id = ?", id); err != nil {
+		return err
+	}
+	s.accountsVersion.Add(1)
+	if _, err := s.db.Exec("DELETE FROM account_health WHERE account_id = ?", id); err != nil {
+		return err
+	}
+	_, err := s.db.Exec("DELETE FROM account_usage WHERE account_id = ?", id)
 	return err
 }
 
@@ -138,22 +316,11 @@ func (s *Store) GetAccount(id int64) (*Account, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	acc := &Account{}
-	var lastUsedAt sql.NullTime
-	err := s.db.QueryRow(`
-		SELECT id, name, session_id, client_cookie, client_uat, project_id, user_id,
-			   agent_mode, email, weight, enabled, request_count, last_used_at, created_at, updated_at
+	row := s.db.QueryRow(`
+		SELECT `+accountColumns+`
 		FROM accounts WHERE id = ?
-	`, id).Scan(&acc.ID, &acc.Name, &acc.SessionID, &acc.ClientCookie, &acc.ClientUat,
-		&acc.ProjectID, &acc.UserID, &acc.AgentMode, &acc.Email, &acc.Weight,
-		&acc.Enabled, &acc.RequestCount, &lastUsedAt, &acc.CreatedAt, &acc.UpdatedAt)
-	if err != nil {
-		return nil, err
-	}
-	if lastUsedAt.Valid {
-		acc.LastUsedAt = lastUsedAt.Time
-	}
-	return acc, nil
+	`, id)
+	return scanAccount(row)
 }
 
 func (s *Store) ListAccounts() ([]*Account, error) {
@@ -161,8 +328,7 @@ func (s *Store) ListAccounts() ([]*Account, error) {
 	defer s.mu.RUnlock()
 
 	rows, err := s.db.Query(`
-		SELECT id, name, session_id, client_cookie, client_uat, project_id, user_id,
-			   agent_mode, email, weight, enabled, request_count, last_used_at, created_at, updated_at
+		SELECT ` + accountColumns + `
 		FROM accounts ORDER BY id
 	`)
 	if err != nil {
@@ -172,17 +338,10 @@ func (s *Store) ListAccounts() ([]*Account, error) {
 
 	var accounts []*Account
 	for rows.Next() {
-		acc := &Account{}
-		var lastUsedAt sql.NullTime
-		err := rows.Scan(&acc.ID, &acc.Name, &acc.SessionID, &acc.ClientCookie, &acc.ClientUat,
-			&acc.ProjectID, &acc.UserID, &acc.AgentMode, &acc.Email, &acc.Weight,
-			&acc.Enabled, &acc.RequestCount, &lastUsedAt, &acc.CreatedAt, &acc.UpdatedAt)
+		acc, err := scanAccount(rows)
 		if err != nil {
 			return nil, err
 		}
-		if lastUsedAt.Valid {
-			acc.LastUsedAt = lastUsedAt.Time
-		}
 		accounts = append(accounts, acc)
 	}
 	return accounts, nil
@@ -193,8 +352,7 @@ func (s *Store) GetEnabledAccounts() ([]*Account, error) {
 	defer s.mu.RUnlock()
 
 	rows, err := s.db.Query(`
-		SELECT id, name, session_id, client_cookie, client_uat, project_id, user_id,
-			   agent_mode, email, weight, enabled, request_count, last_used_at, created_at, updated_at
+		SELECT ` + accountColumns + `
 		FROM accounts WHERE enabled = 1 ORDER BY id
 	`)
 	if err != nil {
@@ -204,31 +362,95 @@ func (s *Store) GetEnabledAccounts() ([]*Account, error) {
 
 	var accounts []*Account
 	for rows.Next() {
-		acc := &Account{}
-		var lastUsedAt sql.NullTime
-		err := rows.Scan(&acc.ID, &acc.Name, &acc.SessionID, &acc.ClientCookie, &acc.ClientUat,
-			&acc.ProjectID, &acc.UserID, &acc.AgentMode, &acc.Email, &acc.Weight,
-			&acc.Enabled, &acc.RequestCount, &lastUsedAt, &acc.CreatedAt, &acc.UpdatedAt)
+		acc, err := scanAccount(rows)
 		if err != nil {
 			return nil, err
 		}
-		if lastUsedAt.Valid {
-			acc.LastUsedAt = lastUsedAt.Time
-		}
 		accounts = append(accounts, acc)
 	}
 	return accounts, nil
 }
 
-func (s *Store) IncrementRequestCount(id int64) error {
+// accountColumns 与 scanAccount 的字段顺序一致
+const accountColumns = `id, name, session_id, client_cookie, client_uat, project_id, user_id,
+	agent_mode, email, models, account_groups, weight, priority, max_concurrency, request_quota, token_quota, quota_window, quota_timezone,
+	enabled, request_count, last_used_at, created_at, updated_at`
+
+func scanAccount(row rowScanner) (*Account, error) {
+	acc := &Account{}
+	var lastUsedAt sql.NullTime
+	var models, groups string
+	err := row.Scan(&acc.ID, &acc.Name, &acc.SessionID, &acc.ClientCookie, &acc.ClientUat,
+		&acc.ProjectID, &acc.UserID, &acc.AgentMode, &acc.Email, &models, &groups, &acc.Weight, &acc.Priority, &acc.MaxConcurrency,
+		&acc.RequestQuota, &acc.TokenQuota, &acc.QuotaWindow, &acc.QuotaTimezone, &acc.Enabled, &acc.RequestCount, &lastUsedAt, &acc.CreatedAt, &acc.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if lastUsedAt.Valid {
+		acc.LastUsedAt = lastUsedAt.Time
+	}
+	json.Unmarshal([]byte(models), &acc.Models)
+	json.Unmarshal([]byte(groups), &acc.Groups)
+	acc.Models = nonNilStrings(acc.Models)
+	acc.Groups = nonNilStrings(acc.Groups)
+	return acc, nil
+}
+
+func marshalAccountLists(acc *Account) (string, string, error) {
+	acc.Models = nonNilStrings(acc.Models)
+	acc.Groups = nonNilStrings(acc.Groups)
+	models, err := json.Marshal(acc.Models)
+	if err != nil {
+		return "", "", err
+	}
+	groups, err := json.Marshal(acc.Groups)
+	if err != nil {
+		return "", "", err
+	}
+	return string(models), string(groups), nil
+}
+
+// AccountsVersion 账号表的修改次数，增删改账号后递增，供负载均衡判断快照是否过期
+func (s *Store) AccountsVersion() uint64 {
+	return s.accountsVersion.Load()
+}
+
+// RequestCount 一个账号累计待写入的请求数
+type RequestCount struct {
+	Count      int64
+	LastUsedAt time.Time
+}
+
+// AddRequestCounts 在一个事务中批量累加请求数并更新最近使用时间
+func (s *Store) AddRequestCounts(counts map[int64]RequestCount) error {
+	if len(counts) == 0 {
+		return nil
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	_, err := s.db.Exec(`
-		UPDATE accounts SET request_count = request_count + 1, last_used_at = CURRENT_TIMESTAMP
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+		UPDATE accounts SET request_count = request_count + ?, last_used_at = ?
 		WHERE id = ?
-	`, id)
-	return err
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for id, c := range counts {
+		if _, err := stmt.Exec(c.Count, c.LastUsedAt.UTC(), id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
 }
 
 func (s *Store) GetSetting(key string) (string, error) {
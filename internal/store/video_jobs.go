@@ -0,0 +1,130 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+)
+
+// 视频任务状态
+const (
+	VideoJobQueued     = "queued"
+	VideoJobInProgress = "in_progress"
+	VideoJobCompleted  = "completed"
+	VideoJobFailed     = "failed"
+	VideoJobCancelled  = "cancelled"
+)
+
+// VideoJob 异步视频生成任务
+type VideoJob struct {
+	ID          string    `json:"id"`
+	Status      string    `json:"status"`
+	Progress    int       `json:"progress"`
+	Model       string    `json:"model"`
+	Prompt      string    `json:"prompt"`
+	Params      string    `json:"params"`
+	ResultURL   string    `json:"result_url"`
+	Error       string    `json:"error"`
+	AccountID   int64     `json:"account_id"`
+	Attempts    int       `json:"attempts"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	CompletedAt time.Time `json:"completed_at"`
+}
+
+// Finished 任务是否已结束
+func (j *VideoJob) Finished() bool {
+	return j.Status == VideoJobCompleted || j.Status == VideoJobFailed || j.Status == VideoJobCancelled
+}
+
+func (s *Store) CreateVideoJob(job *VideoJob) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	_, err := s.db.Exec(`
+		INSERT INTO video_jobs (id, status, progress, model, prompt, params, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, job.ID, job.Status, job.Progress, job.Model, job.Prompt, job.Params, now, now)
+	if err != nil {
+		return err
+	}
+	job.CreatedAt = now
+	job.UpdatedAt = now
+	return nil
+}
+
+func (s *Store) UpdateVideoJob(job *VideoJob) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var completedAt interface{}
+	if !job.CompletedAt.IsZero() {
+		completedAt = job.CompletedAt
+	}
+	job.UpdatedAt = time.Now()
+	_, err := s.db.Exec(`
+		UPDATE video_jobs SET
+			status = ?, progress = ?, result_url = ?, error = ?, account_id = ?,
+			attempts = ?, updated_at = ?, completed_at = ?
+		WHERE id = ?
+	`, job.Status, job.Progress, job.ResultURL, job.Error, job.AccountID,
+		job.Attempts, job.UpdatedAt, completedAt, job.ID)
+	return err
+}
+
+func (s *Store) GetVideoJob(id string) (*VideoJob, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return scanVideoJob(s.db.QueryRow(`
+		SELECT id, status, progress, model, prompt, params, result_url, error,
+			   account_id, attempts, created_at, updated_at, completed_at
+		FROM video_jobs WHERE id = ?
+	`, id))
+}
+
+// ListVideoJobsByStatus 按创建时间顺序列出指定状态的任务
+func (s *Store) ListVideoJobsByStatus(statuses ...string) ([]*VideoJob, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var jobs []*VideoJob
+	for _, status := range statuses {
+		rows, err := s.db.Query(`
+			SELECT id, status, progress, model, prompt, params, result_url, error,
+				   account_id, attempts, created_at, updated_at, completed_at
+			FROM video_jobs WHERE status = ? ORDER BY created_at
+		`, status)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			job, err := scanVideoJob(rows)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			jobs = append(jobs, job)
+		}
+		rows.Close()
+	}
+	return jobs, nil
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanVideoJob(row rowScanner) (*VideoJob, error) {
+	job := &VideoJob{}
+	var completedAt sql.NullTime
+	err := row.Scan(&job.ID, &job.Status, &job.Progress, &job.Model, &job.Prompt, &job.Params,
+		&job.ResultURL, &job.Error, &job.AccountID, &job.Attempts, &job.CreatedAt, &job.UpdatedAt, &completedAt)
+	if err != nil {
+		return nil, err
+	}
+	if completedAt.Valid {
+		job.CompletedAt = completedAt.Time
+	}
+	return job, nil
+}
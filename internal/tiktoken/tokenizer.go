@@ -109,6 +109,37 @@ func EstimateTextTokens(text string) int {
 	return count / 3
 }
 
+// TextCounter 流式累计 EstimateTextTokens，每次只处理新增的文本
+type TextCounter struct {
+	chars int
+}
+
+// Add 累计一段文本
+func (c *TextCounter) Add(text string) {
+	for range text {
+		c.chars++
+	}
+}
+
+// Tokens 返回已累计文本的估算，与对全部文本调用 EstimateTextTokens 结果一致
+func (c *TextCounter) Tokens() int {
+	return c.chars / 3
+}
+
+// With 返回再追加 text 后的估算，不修改计数
+func (c *TextCounter) With(text string) int {
+	n := c.chars
+	for range text {
+		n++
+	}
+	return n / 3
+}
+
+// Reset 清空计数
+func (c *TextCounter) Reset() {
+	c.chars = 0
+}
+
 // EstimateMessagesTokens 估算消息列表的 token 数量
 // 考虑消息格式和角色标记的开销
 func EstimateMessagesTokens(messages []map[string]interface{}) int {
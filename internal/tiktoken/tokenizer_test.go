@@ -154,3 +154,25 @@ func TestIsCJK(t *testing.T) {
 		})
 	}
 }
+
+func TestTextCounter(t *testing.T) {
+	// 按增量累计的结果与对全文估算一致
+	chunks := []string{"He", "llo", " ", "世界", "!", "a", "bc", "这是测试"}
+	var c TextCounter
+	full := ""
+	for _, chunk := range chunks {
+		if got, want := c.With(chunk), EstimateTextTokens(full+chunk); got != want {
+			t.Errorf("With(%q) = %d, want %d", chunk, got, want)
+		}
+		c.Add(chunk)
+		full += chunk
+		if got, want := c.Tokens(), EstimateTextTokens(full); got != want {
+			t.Errorf("Tokens() after %q = %d, want %d", full, got, want)
+		}
+	}
+
+	c.Reset()
+	if c.Tokens() != 0 {
+		t.Errorf("Tokens() after Reset = %d, want 0", c.Tokens())
+	}
+}
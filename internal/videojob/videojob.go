@@ -0,0 +1,362 @@
+package videojob
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"orchids-api/internal/client"
+	"orchids-api/internal/loadbalancer"
+	"orchids-api/internal/store"
+)
+
+// maxAttempts 单个任务最多尝试的账号数
+const maxAttempts = 3
+
+// queueSize 等待执行的任务上限
+const queueSize = 256
+
+// progressInterval 估算进度的刷新间隔
+const progressInterval = 5 * time.Second
+
+// expectedDuration 用于估算进度的典型生成耗时（上游不返回真实进度）
+const expectedDuration = 90 * time.Second
+
+var (
+	// ErrNotFound 任务不存在
+	ErrNotFound = errors.New("video job not found")
+	// ErrQueueFull 等待队列已满
+	ErrQueueFull = errors.New("video job queue is full")
+	// ErrFinished 任务已结束，无法取消
+	ErrFinished = errors.New("video job already finished")
+)
+
+// Manager 管理异步视频任务：持久化到 store，由后台 worker 执行
+type Manager struct {
+	store        *store.Store
+	loadBalancer *loadbalancer.LoadBalancer
+	client       *client.Client
+	workers      int
+	queue        chan string
+
+	mu      sync.Mutex
+	running map[string]*runningJob
+	backlog int // 重启恢复时超出队列容量、尚未送入队列的任务数
+}
+
+// runningJob 执行中的任务，job 的字段修改需持有 Manager.mu
+type runningJob struct {
+	job    *store.VideoJob
+	cancel context.CancelFunc
+}
+
+func New(s *store.Store, lb *loadbalancer.LoadBalancer, fallback *client.Client, workers int) *Manager {
+	if workers <= 0 {
+		workers = 1
+	}
+	return &Manager{
+		store:        s,
+		loadBalancer: lb,
+		client:       fallback,
+		workers:      workers,
+		queue:        make(chan string, queueSize),
+		running:      make(map[string]*runningJob),
+	}
+}
+
+// Start 恢复未完成的任务并启动 worker
+func (m *Manager) Start(ctx context.Context) error {
+	pending, err := m.store.ListVideoJobsByStatus(store.VideoJobInProgress, store.VideoJobQueued)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < m.workers; i++ {
+		go m.worker(ctx)
+	}
+
+	var overflow []string
+	for _, job := range pending {
+		// 进程重启时正在执行的任务重新排队
+		if job.Status == store.VideoJobInProgress {
+			job.Status = store.VideoJobQueued
+			job.Progress = 0
+			if err := m.store.UpdateVideoJob(job); err != nil {
+				return err
+			}
+		}
+		select {
+		case m.queue <- job.ID:
+		default:
+			overflow = append(overflow, job.ID)
+		}
+	}
+	if len(pending) > 0 {
+		log.Printf("恢复 %d 个未完成的视频任务", len(pending))
+	}
+	if len(overflow) > 0 {
+		// 已接受的任务不能因队列容量失败，保持排队状态，等队列有空位时依次送入
+		m.mu.Lock()
+		m.backlog = len(overflow)
+		m.mu.Unlock()
+		go m.feed(ctx, overflow)
+	}
+	return nil
+}
+
+// feed 依次把恢复的任务送入队列，队列满时等待 worker 取走
+func (m *Manager) feed(ctx context.Context, ids []string) {
+	for _, id := range ids {
+		select {
+		case m.queue <- id:
+		case <-ctx.Done():
+			return
+		}
+		m.mu.Lock()
+		m.backlog--
+		m.mu.Unlock()
+	}
+}
+
+// Submit 创建任务并加入队列，opts 以 JSON 保存在任务中
+func (m *Manager) Submit(prompt string, opts client.VideoOptions) (*store.VideoJob, error) {
+	raw, err := json.Marshal(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	job := &store.VideoJob{
+		ID:     newJobID(),
+		Status: store.VideoJobQueued,
+		Model:  opts.Model,
+		Prompt: prompt,
+		Params: string(raw),
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// 计入尚未送入队列的恢复任务，保证下面的发送不会阻塞
+	if len(m.queue)+m.backlog >= cap(m.queue) {
+		return nil, ErrQueueFull
+	}
+	if err := m.store.CreateVideoJob(job); err != nil {
+		return nil, err
+	}
+	m.queue <- job.ID
+
+	log.Printf("视频任务 %s 已创建", job.ID)
+	return job, nil
+}
+
+// Get 查询任务
+func (m *Manager) Get(id string) (*store.VideoJob, error) {
+	job, err := m.store.GetVideoJob(id)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	return job, nil
+}
+
+// Cancel 取消排队或执行中的任务
+func (m *Manager) Cancel(id string) (*store.VideoJob, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job, err := m.store.GetVideoJob(id)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	if job.Finished() {
+		return job, ErrFinished
+	}
+
+	if rj := m.running[id]; rj != nil {
+		job = rj.job
+		rj.cancel()
+	}
+	job.Status = store.VideoJobCancelled
+	job.CompletedAt = time.Now()
+	if err := m.store.UpdateVideoJob(job); err != nil {
+		return nil, err
+	}
+
+	log.Printf("视频任务 %s 已取消", id)
+	return job, nil
+}
+
+func (m *Manager) worker(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case id := <-m.queue:
+			m.run(ctx, id)
+		}
+	}
+}
+
+// run 执行单个任务，失败时换账号重试
+func (m *Manager) run(parent context.Context, id string) {
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+
+	m.mu.Lock()
+	job, err := m.store.GetVideoJob(id)
+	if err != nil || job.Status != store.VideoJobQueued {
+		// 已被取消或删除
+		m.mu.Unlock()
+		return
+	}
+	job.Status = store.VideoJobInProgress
+	job.Progress = 1
+	if err := m.store.UpdateVideoJob(job); err != nil {
+		m.mu.Unlock()
+		log.Printf("视频任务 %s 状态更新失败: %v", id, err)
+		return
+	}
+	m.running[id] = &runningJob{job: job, cancel: cancel}
+	m.mu.Unlock()
+
+	defer func() {
+		m.mu.Lock()
+		delete(m.running, id)
+		m.mu.Unlock()
+	}()
+
+	var opts client.VideoOptions
+	json.Unmarshal([]byte(job.Params), &opts)
+
+	go m.trackProgress(ctx, job)
+
+	var failedAccountIDs []int64
+	var lastErr error
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		apiClient, account, err := m.selectClient(ctx, failedAccountIDs)
+		if err != nil {
+			if ctx.Err() != nil {
+				// 取消由 Cancel 负责落库
+				return
+			}
+			if lastErr == nil {
+				lastErr = err
+			}
+			break
+		}
+
+		m.mu.Lock()
+		job.Attempts++
+		if account != nil {
+			job.AccountID = account.ID
+		}
+		m.mu.Unlock()
+
+		videoURL, err := apiClient.GenerateVideo(ctx, job.Prompt, opts)
+		if m.loadBalancer != nil {
+			m.loadBalancer.ReportResult(account, err)
+			m.loadBalancer.Release(account)
+		}
+		if err == nil {
+			m.finish(job, store.VideoJobCompleted, videoURL, nil)
+			log.Printf("视频任务 %s 完成: %s", id, videoURL)
+			return
+		}
+		if ctx.Err() != nil {
+			// 取消由 Cancel 负责落库
+			return
+		}
+
+		lastErr = err
+		log.Printf("视频任务 %s 第 %d 次尝试失败: %v", id, attempt+1, err)
+
+		// 请求本身有问题时换账号无意义
+		var upstreamErr *client.UpstreamError
+		if errors.As(err, &upstreamErr) && upstreamErr.StatusCode == http.StatusBadRequest {
+			break
+		}
+		if account == nil {
+			break
+		}
+		failedAccountIDs = append(failedAccountIDs, account.ID)
+	}
+
+	m.finish(job, store.VideoJobFailed, "", lastErr)
+	log.Printf("视频任务 %s 失败: %v", id, lastErr)
+}
+
+// selectClient 选择账号，全部满载时排队等待；没有可用账号时回退到默认配置
+func (m *Manager) selectClient(ctx context.Context, excludeIDs []int64) (*client.Client, *store.Account, error) {
+	if m.loadBalancer != nil {
+		account, _, err := m.loadBalancer.Acquire(ctx, loadbalancer.Request{Exclude: excludeIDs})
+		if err == nil {
+			log.Printf("使用账号: %s (%s) [优先级 %d]", account.Name, account.Email, account.Priority)
+			return client.NewFromAccount(account), account, nil
+		}
+		if len(excludeIDs) > 0 || m.client == nil || !errors.Is(err, loadbalancer.ErrNoAccounts) {
+			return nil, nil, err
+		}
+	}
+	if m.client != nil {
+		return m.client, nil, nil
+	}
+	return nil, nil, loadbalancer.ErrNoAccounts
+}
+
+// trackProgress 按耗时估算进度，直到任务结束
+func (m *Manager) trackProgress(ctx context.Context, job *store.VideoJob) {
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+	start := time.Now()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			elapsed := time.Since(start).Seconds() / expectedDuration.Seconds()
+			progress := int(95 * (1 - math.Exp(-elapsed)))
+
+			m.mu.Lock()
+			if job.Status == store.VideoJobInProgress && progress > job.Progress {
+				job.Progress = progress
+				m.store.UpdateVideoJob(job)
+			}
+			m.mu.Unlock()
+		}
+	}
+}
+
+// finish 写入任务的最终状态（已取消的任务保持不变）
+func (m *Manager) finish(job *store.VideoJob, status, resultURL string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if job.Status == store.VideoJobCancelled {
+		return
+	}
+
+	job.Status = status
+	job.ResultURL = resultURL
+	job.CompletedAt = time.Now()
+	if status == store.VideoJobCompleted {
+		job.Progress = 100
+	}
+	if err != nil {
+		job.Error = err.Error()
+	}
+	if updateErr := m.store.UpdateVideoJob(job); updateErr != nil {
+		log.Printf("视频任务 %s 状态更新失败: %v", job.ID, updateErr)
+	}
+}
+
+func newJobID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return "video_" + hex.EncodeToString(b)
+}
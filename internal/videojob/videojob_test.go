@@ -0,0 +1,253 @@
+package videojob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"orchids-api/internal/clerk"
+	"orchids-api/internal/client"
+	"orchids-api/internal/fakeupstream"
+	"orchids-api/internal/loadbalancer"
+	"orchids-api/internal/store"
+)
+
+// testSessionSeq client 的 token 缓存按 SessionID 全局共享，每个测试账号使用不同的会话
+var testSessionSeq atomic.Int64
+
+// newTestManager 创建上游指向 fakeupstream、带 accounts 个账号的 Manager，尚未 Start
+func newTestManager(t *testing.T, accounts int) (*Manager, *fakeupstream.Server, *store.Store) {
+	t.Helper()
+	fake := fakeupstream.New(fakeupstream.Options{})
+	srv := httptest.NewServer(fake)
+	client.SetUpstreamBaseURL(srv.URL)
+	clerk.SetBaseURL(srv.URL)
+	t.Cleanup(func() {
+		srv.Close()
+		client.SetUpstreamBaseURL("")
+		clerk.SetBaseURL("")
+	})
+
+	s, err := store.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	for i := 0; i < accounts; i++ {
+		acc := &store.Account{
+			Name:         fmt.Sprintf("acc-%d", i),
+			SessionID:    fmt.Sprintf("sess_video_%d", testSessionSeq.Add(1)),
+			ClientCookie: "cookie",
+			Enabled:      true,
+			Weight:       1,
+		}
+		if err := s.CreateAccount(acc); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return New(s, loadbalancer.New(s), nil, 1), fake, s
+}
+
+// start 启动 worker，测试结束时停止
+func start(t *testing.T, m *Manager) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	if err := m.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// waitFor 轮询直到 cond 成立，超时则失败
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// waitStatus 等待任务进入 status 并返回最新状态
+func waitStatus(t *testing.T, m *Manager, id, status string) *store.VideoJob {
+	t.Helper()
+	var job *store.VideoJob
+	waitFor(t, "job "+status, func() bool {
+		var err error
+		job, err = m.Get(id)
+		return err == nil && job.Status == status
+	})
+	return job
+}
+
+func TestRunFailover(t *testing.T) {
+	tests := []struct {
+		name         string
+		script       fakeupstream.Response
+		wantStatus   string
+		wantAttempts int
+	}{
+		{
+			name:         "429 后换账号成功",
+			script:       fakeupstream.Response{Times: 1, Status: http.StatusTooManyRequests, Body: "rate limited"},
+			wantStatus:   store.VideoJobCompleted,
+			wantAttempts: 2,
+		},
+		{
+			name:         "400 不换账号",
+			script:       fakeupstream.Response{Status: http.StatusBadRequest, Body: "bad prompt"},
+			wantStatus:   store.VideoJobFailed,
+			wantAttempts: 1,
+		},
+		{
+			name:         "所有账号都失败",
+			script:       fakeupstream.Response{Status: http.StatusInternalServerError, Body: "boom"},
+			wantStatus:   store.VideoJobFailed,
+			wantAttempts: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, fake, _ := newTestManager(t, 2)
+			fake.Script(tt.script)
+			start(t, m)
+
+			job, err := m.Submit("a cat", client.VideoOptions{Model: "video-model"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got *store.VideoJob
+			waitFor(t, "job finished", func() bool {
+				got, err = m.Get(job.ID)
+				return err == nil && got.Finished()
+			})
+
+			if got.Status != tt.wantStatus || got.Attempts != tt.wantAttempts {
+				t.Errorf("status = %s attempts = %d, want %s and %d", got.Status, got.Attempts, tt.wantStatus, tt.wantAttempts)
+			}
+			if reqs := len(fake.Requests()); reqs != tt.wantAttempts {
+				t.Errorf("got %d upstream requests, want %d", reqs, tt.wantAttempts)
+			}
+			switch got.Status {
+			case store.VideoJobCompleted:
+				if got.ResultURL == "" || got.Progress != 100 || got.Error != "" || got.AccountID == 0 {
+					t.Errorf("completed job = %+v", got)
+				}
+			case store.VideoJobFailed:
+				if got.ResultURL != "" || got.Error == "" {
+					t.Errorf("failed job = %+v", got)
+				}
+			}
+		})
+	}
+}
+
+func TestCancelRunning(t *testing.T) {
+	m, fake, _ := newTestManager(t, 1)
+	fake.Script(fakeupstream.Response{DelayMS: 10000})
+	start(t, m)
+
+	job, err := m.Submit("a cat", client.VideoOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitStatus(t, m, job.ID, store.VideoJobInProgress)
+
+	cancelled, err := m.Cancel(job.ID)
+	if err != nil || cancelled.Status != store.VideoJobCancelled {
+		t.Fatalf("Cancel = %+v, %v", cancelled, err)
+	}
+	// 取消会中断上游请求，worker 退出后不覆盖取消状态
+	waitFor(t, "worker exit", func() bool {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		return len(m.running) == 0
+	})
+	if got, _ := m.Get(job.ID); got.Status != store.VideoJobCancelled || got.ResultURL != "" {
+		t.Errorf("job = %+v, want cancelled", got)
+	}
+	if _, err := m.Cancel(job.ID); !errors.Is(err, ErrFinished) {
+		t.Errorf("second Cancel err = %v, want %v", err, ErrFinished)
+	}
+}
+
+func TestCancelRacesFinish(t *testing.T) {
+	// 上游恰好在取消时返回结果：Cancel 修改 running 中共享的 job，finish 据此保持取消状态
+	m, _, s := newTestManager(t, 0)
+	job := &store.VideoJob{ID: newJobID(), Status: store.VideoJobInProgress, Progress: 1}
+	if err := s.CreateVideoJob(job); err != nil {
+		t.Fatal(err)
+	}
+	cancelled := false
+	m.running[job.ID] = &runningJob{job: job, cancel: func() { cancelled = true }}
+
+	if _, err := m.Cancel(job.ID); err != nil {
+		t.Fatal(err)
+	}
+	if !cancelled {
+		t.Error("Cancel did not cancel the running job")
+	}
+	m.finish(job, store.VideoJobCompleted, "http://example.com/video.mp4", nil)
+
+	got, err := m.Get(job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != store.VideoJobCancelled || got.ResultURL != "" || got.Progress == 100 {
+		t.Errorf("job = %+v, want cancelled", got)
+	}
+}
+
+func TestStartRecovery(t *testing.T) {
+	m, fake, s := newTestManager(t, 1)
+	// 第一个任务阻塞唯一的 worker，其余任务留在队列与 backlog 中
+	fake.Script(fakeupstream.Response{Times: 1, DelayMS: 1000})
+	m.queue = make(chan string, 2)
+
+	// ListVideoJobsByStatus 先返回执行中的任务
+	var ids []string
+	for i, status := range []string{store.VideoJobInProgress, store.VideoJobQueued, store.VideoJobQueued, store.VideoJobQueued, store.VideoJobQueued} {
+		job := &store.VideoJob{ID: newJobID(), Status: status, Prompt: fmt.Sprintf("job %d", i), Params: "{}"}
+		if status == store.VideoJobInProgress {
+			job.Progress = 50
+		}
+		if err := s.CreateVideoJob(job); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, job.ID)
+	}
+	start(t, m)
+
+	// worker 占用 1 个，队列 2 个，backlog 剩余 2 个
+	waitFor(t, "backlog", func() bool {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		return len(m.running) == 1 && len(m.queue) == 2 && m.backlog == 2
+	})
+	if _, err := m.Submit("overflow", client.VideoOptions{}); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("Submit err = %v, want %v", err, ErrQueueFull)
+	}
+
+	// 执行中的任务重新排队，所有恢复的任务最终完成，backlog 归零
+	for _, id := range ids {
+		waitStatus(t, m, id, store.VideoJobCompleted)
+	}
+	m.mu.Lock()
+	backlog := m.backlog
+	m.mu.Unlock()
+	if backlog != 0 {
+		t.Errorf("backlog = %d, want 0", backlog)
+	}
+	if _, err := m.Submit("after", client.VideoOptions{}); err != nil {
+		t.Errorf("Submit after drain err = %v", err)
+	}
+}